@@ -0,0 +1,267 @@
+package business
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// assertionMethods signing algorithms accepted in the assertions of the JWT Bearer grant
+var assertionMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// subjectPlaceholder placeholder of the asserted subject in the subject rules of the trusted issuers
+const subjectPlaceholder = "{sub}"
+
+// DefaultAssertionMaxLifeTime maximum time until the expiration of the assertions accepted by the JWT Bearer grant
+const DefaultAssertionMaxLifeTime = time.Hour
+
+// minimumAssertionLifeTime minimum time until the expiration of an assertion, so its id can be remembered
+const minimumAssertionLifeTime = time.Second
+
+// TrustedIssuer external identity provider whose assertions are accepted by the JWT Bearer grant
+type TrustedIssuer struct {
+	// Keys finder of the public keys (model.JWK) of the issuer indexed by key id
+	// (e.g. repository.FileKeySet or repository.URLKeySet)
+	Keys repository.Finder
+	// Subject rule that maps the asserted "sub" to the id of a local owner, the "{sub}" placeholder
+	// is replaced by the asserted subject (e.g. "{sub}@partner.com")
+	//
+	// The rule is required and must namespace the subjects of the issuer, otherwise an issuer could assert
+	// the id of any local owner
+	Subject string
+}
+
+// MapSubject maps the asserted subject to the id of a local owner using the Subject rule
+func (t TrustedIssuer) MapSubject(subject string) (string, error) {
+	if t.Subject == "" || t.Subject == subjectPlaceholder || !strings.Contains(t.Subject, subjectPlaceholder) {
+		return "", fmt.Errorf("%w: the trusted issuer does not define a subject rule with namespace", model.InvalidGrant)
+	}
+
+	return strings.ReplaceAll(t.Subject, subjectPlaceholder, subject), nil
+}
+
+// _ "implement" constraint for JWTBearerGrant
+var _ CodeExchanger = JWTBearerGrant{}
+
+// JWTBearerGrant made the validations that correspond to the JWT Bearer authorization grant (RFC 7523)
+//
+// The assertions must be signed by a TrustedIssuer and the asserted subject must be mapped to an existing owner
+type JWTBearerGrant struct {
+	// Issuer identifier of the authorization server used as "iss" claim of the generated tokens,
+	// is accepted as "aud" of the assertions
+	Issuer string
+	// Audience additional "aud" value accepted in the assertions (e.g. the URL of the token endpoint)
+	Audience string
+	// TrustedIssuers external identity providers indexed by issuer identifier
+	TrustedIssuers map[string]TrustedIssuer
+	// Client authenticates the client if the request contains a client_id
+	Client Authenticator
+	// Owners store of the local owners
+	Owners repository.Storage
+	// Assertions store for the ids (jti) of the accepted assertions, so each assertion is accepted only once
+	Assertions repository.Storage
+	// MaxLifeTime maximum time until the expiration of the accepted assertions, the ids of the assertions are
+	// remembered until they expire (DefaultAssertionMaxLifeTime by default)
+	MaxLifeTime time.Duration
+	// ScopeParser parses a scope from string
+	ScopeParser
+	TokenGenerator
+	// SessionStorage store for the sessions of the issued tokens
+	SessionStorage repository.Storage
+	// Policy decides if the token can be issued (Optional)
+	Policy Policy
+	// AccessClaims adds claims to the access tokens (Optional)
+	AccessClaims ClaimsEnricher
+	// Clients finder of the clients used to obtain their allowed and default scopes (Optional)
+	Clients repository.Finder
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or not entitled to the owner are rejected or removed
+	ScopePolicy ScopePolicy
+	// Subjects obtains the subject identifiers sent to the pairwise clients (Optional)
+	Subjects SubjectResolver
+}
+
+// ExchangeCode exchanges a signed assertion for a token (grant_type=urn:ietf:params:oauth:grant-type:jwt-bearer)
+func (j JWTBearerGrant) ExchangeCode(exchange model.Exchange) (tkn model.Token, err error) {
+	if exchange.GrantType != model.JWTBearerGrantType {
+		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
+		return
+	}
+
+	// The client authentication is optional in the JWT Bearer grant (RFC 7523 section 3.1)
+	if exchange.Application.Id != "" {
+		if err = j.Client.Authenticate(exchange.Application); err != nil {
+			return
+		}
+	}
+
+	if exchange.Assertion == "" {
+		err = fmt.Errorf("%w: missing assertion", model.InvalidRequest)
+		return
+	}
+
+	owner, err := j.verifyAssertion(exchange.Assertion)
+	if err != nil {
+		return
+	}
+
+	requested, err := j.granter().grant(exchange.Application.Id, owner, exchange.Scope)
+	if err != nil {
+		return
+	}
+
+	scope, err := j.ParseScope(requested)
+	if err != nil {
+		return
+	}
+
+	err = enforcePolicy(j.Policy, model.PolicyRequest{
+		Grant:     model.JWTBearerGrantType,
+		Owner:     owner,
+		Client:    exchange.Application.Id,
+		IP:        exchange.Session.IP,
+		UserAgent: exchange.Session.UserAgent,
+	}, scope, model.InsufficientUserAuthentication)
+	if err != nil {
+		return
+	}
+
+	token := model.JWT{
+		Scope: scope,
+		StandardClaims: model.StandardClaims{
+			Id:       uuid.New().String(),
+			Issuer:   j.Issuer,
+			Subject:  owner,
+			Audience: exchange.Application.Id,
+			IssuedAt: time.Now().Unix(),
+		},
+	}
+
+	if err = enrichClaims(j.AccessClaims, exchange.Application.Id, &token); err != nil {
+		return
+	}
+
+	token.Subject, err = resolveSubject(j.Subjects, exchange.Application.Id, owner)
+	if err != nil {
+		return
+	}
+
+	tkn, err = j.GenerateToken(token)
+	if err != nil {
+		return
+	}
+
+	exchange.Session.Owner = model.Owner{Id: owner}
+
+	err = j.SessionStorage.Create(token.Id, exchange.Session)
+	return
+}
+
+// granter returns the scopeGranter that applies the scope rules of the clients and the entitlements of the owners,
+// the requests without client_id do not have client restrictions
+func (j JWTBearerGrant) granter() scopeGranter {
+	return scopeGranter{ScopeParser: j.ScopeParser, Clients: j.Clients, Entitler: j.Entitler, ScopePolicy: j.ScopePolicy}
+}
+
+// verifyAssertion validates the signature and claims of the assertion (RFC 7523 section 3)
+// and returns the id of the local owner identified by the asserted subject
+func (j JWTBearerGrant) verifyAssertion(assertion string) (string, error) {
+	parser := jwt.Parser{ValidMethods: assertionMethods}
+
+	var issuer TrustedIssuer
+
+	token, err := parser.ParseWithClaims(assertion, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		iss, _ := token.Claims.(jwt.MapClaims)["iss"].(string)
+
+		var ok bool
+
+		issuer, ok = j.TrustedIssuers[iss]
+		if !ok {
+			return nil, fmt.Errorf(`untrusted issuer "%s"`, iss)
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		i, err := issuer.Keys.Find(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return i.(model.JWK).PublicKey()
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid assertion: %s", model.InvalidGrant, err.Error())
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	// The "exp" claim is required and was validated by the parser
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", fmt.Errorf("%w: assertion without expiration", model.InvalidGrant)
+	}
+
+	maxLifeTime := j.MaxLifeTime
+	if maxLifeTime <= 0 {
+		maxLifeTime = DefaultAssertionMaxLifeTime
+	}
+
+	// The id of the assertion is remembered until it expires, so the expiration must be near but not immediate
+	lifeTime := time.Until(time.Unix(int64(exp), 0))
+	if lifeTime < minimumAssertionLifeTime || lifeTime > maxLifeTime {
+		return "", fmt.Errorf("%w: the expiration of the assertion is out of the accepted range", model.InvalidGrant)
+	}
+
+	if !claims.VerifyAudience(j.Issuer, true) && (j.Audience == "" || !claims.VerifyAudience(j.Audience, true)) {
+		return "", fmt.Errorf("%w: assertion was not issued for this server", model.InvalidGrant)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return "", fmt.Errorf("%w: assertion without subject", model.InvalidGrant)
+	}
+
+	// The "jti" claim is required to reject the replayed assertions (RFC 7523 section 3)
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		return "", fmt.Errorf("%w: assertion without jti", model.InvalidGrant)
+	}
+
+	owner, err := issuer.MapSubject(subject)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = j.Owners.Obtain(owner)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return "", fmt.Errorf(`%w: subject "%s" is not mapped to an owner`, model.InvalidGrant, subject)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// The ids are unique per issuer, the assertion is remembered until it expires
+	err = j.Assertions.Create(claims["iss"].(string)+" "+id, time.Unix(int64(exp), 0))
+	if _, ok := err.(model.DuplicateRecord); ok {
+		return "", fmt.Errorf("%w: assertion was already used", model.InvalidGrant)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return owner, nil
+}
@@ -0,0 +1,236 @@
+package business
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestJWTBearerGrant_ExchangeCode checks the validation of the assertions signed by trusted issuers
+// and the mapping of the asserted subject to a local owner (RFC 7523)
+func TestJWTBearerGrant_ExchangeCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(model.JWKSet{
+		Keys: []model.JWK{
+			{
+				KeyType: "EC",
+				KeyId:   "partner-1",
+				Curve:   "P-256",
+				X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			},
+		},
+	})
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+
+	if err = os.WriteFile(path, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	grant := JWTBearerGrant{
+		Issuer:   "go-test",
+		Audience: "http://localhost:8080/go-auth/v1/token",
+		TrustedIssuers: map[string]TrustedIssuer{
+			"https://idp.partner.com": {
+				Keys:    repository.FileKeySet{Path: path},
+				Subject: "{sub}@partner.com",
+			},
+			// The subjects are not mapped to a namespace
+			"https://idp.other.com": {
+				Keys: repository.FileKeySet{Path: path},
+			},
+		},
+		Owners: repository.NewMockStorage(map[string]interface{}{
+			"alice":             model.Owner{Id: "alice"},
+			"alice@partner.com": model.Owner{Id: "alice@partner.com"},
+		}),
+		Assertions:     repository.NewMockStorage(nil),
+		ScopeParser:    NewScopeParser(),
+		TokenGenerator: generator,
+		SessionStorage: repository.NewMockStorage(nil),
+	}
+
+	// sign signs the claims with the key of the trusted issuer
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "partner-1"
+
+		assertion, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return assertion
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+
+	valid := sign(jwt.MapClaims{
+		"iss": "https://idp.partner.com",
+		"sub": "alice",
+		"aud": []string{"http://localhost:8080/go-auth/v1/token"},
+		"exp": exp,
+		"jti": "assertion-1",
+	})
+
+	tdt := []struct {
+		assertion   string
+		expectedErr error
+	}{
+		// Valid assertion with audience list
+		{
+			assertion: valid,
+		},
+		// The assertion was already used
+		{
+			assertion:   valid,
+			expectedErr: model.InvalidGrant,
+		},
+		// Assertion without jti
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": exp,
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The issuer does not map the subjects to a namespace, so it cannot assert the local owners
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.other.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": exp,
+				"jti": "assertion-2",
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// Untrusted issuer
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.unknown.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": exp,
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The assertion was issued for another audience
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "another",
+				"exp": exp,
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The assertion has expired
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": time.Now().Add(-time.Minute).Unix(),
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The assertion expires immediately, so its id cannot be remembered
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": time.Now().Unix(),
+				"jti": "assertion-5",
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The expiration of the assertion exceeds the maximum life time
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "go-test",
+				"exp": time.Now().Add(DefaultAssertionMaxLifeTime + time.Minute).Unix(),
+				"jti": "assertion-6",
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// Assertion without expiration
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "alice",
+				"aud": "go-test",
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// The subject is not mapped to an owner
+		{
+			assertion: sign(jwt.MapClaims{
+				"iss": "https://idp.partner.com",
+				"sub": "bob",
+				"aud": "go-test",
+				"exp": exp,
+				"jti": "assertion-4",
+			}),
+			expectedErr: model.InvalidGrant,
+		},
+		// Missing assertion
+		{
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			token, err := grant.ExchangeCode(model.Exchange{
+				GrantType: model.JWTBearerGrantType,
+				Assertion: v.assertion,
+			})
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			claims, err := generator.ParseToken(token.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != "alice@partner.com" {
+				t.Fatalf(`expected subject "alice@partner.com" got "%s"`, claims.Subject)
+			}
+		})
+	}
+}
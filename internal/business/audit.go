@@ -0,0 +1,66 @@
+package business
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Auditor records the decisions taken by the authorization server in an audit log
+type Auditor interface {
+	// Audit records the event
+	Audit(model.AuditEvent) error
+}
+
+// _ "implement" constraint for LogAuditor and MemoryAuditor
+var (
+	_ Auditor = LogAuditor{}
+	_ Auditor = (*MemoryAuditor)(nil)
+)
+
+// LogAuditor writes the events in a log as JSON lines
+type LogAuditor struct {
+	// Logger destination of the events (log.Default() by default)
+	Logger *log.Logger
+}
+
+// Audit writes the event serialized as JSON in the log
+func (l LogAuditor) Audit(event model.AuditEvent) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("audit: %s", b)
+	return nil
+}
+
+// MemoryAuditor keeps the events in memory, useful in tests
+type MemoryAuditor struct {
+	mutex  sync.Mutex
+	events []model.AuditEvent
+}
+
+// Audit saves the event
+func (m *MemoryAuditor) Audit(event model.AuditEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Events returns a copy of the saved events
+func (m *MemoryAuditor) Events() []model.AuditEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]model.AuditEvent(nil), m.events...)
+}
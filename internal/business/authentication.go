@@ -1,6 +1,7 @@
 package business
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/yael-castro/goauth/internal/model"
@@ -50,16 +51,20 @@ var _ Authenticator = ClientAuthenticator{}
 // ClientAuthenticator authenticates a model.Application
 type ClientAuthenticator struct {
 	repository.Finder
+	// ExactRedirect disables the wildcard subdomain patterns of the clients as OAuth 2.1 requires
+	// (the port of loopback redirect uris is still ignored)
+	ExactRedirect bool
 }
 
 // Authenticate validates a model.Application to check if the client credentials and redirect url match
-// to some record in database
+// to some record in database, the redirect url is only validated if it is defined and the secret is only
+// validated (for the confidential clients) if the redirect url is not defined
 func (c ClientAuthenticator) Authenticate(i interface{}) (err error) {
 	application := i.(model.Application)
 
 	data, err := c.Finder.Find(application.Id)
 	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
-		return fmt.Errorf(`%w: client "%s" does not exist`, model.UnauthorizedClient, application.Id)
+		return fmt.Errorf(`%w: client "%s" does not exist`, model.InvalidClient, application.Id)
 	}
 
 	if err != nil {
@@ -68,9 +73,51 @@ func (c ClientAuthenticator) Authenticate(i interface{}) (err error) {
 
 	savedClient := data.(model.Client)
 
-	if !savedClient.IsValidOrigin(application.RedirectURL.String()) {
-		err = fmt.Errorf("%w: invalid redirect_uri", model.UnauthorizedClient)
+	// The clients are only identified if no redirect uri is received (e.g. token or device requests), the
+	// confidential clients must also present their secret
+	if application.RedirectURL == nil {
+		if savedClient.Secret == "" {
+			return nil
+		}
+
+		_, err = authenticateSecret(c.Finder, application)
+		return
+	}
+
+	matcher := RedirectMatcher{Wildcard: savedClient.WildcardRedirect && !c.ExactRedirect}
+
+	if !matcher.Match(savedClient.AllowedOrigins, application.RedirectURL.String()) {
+		err = fmt.Errorf("%w: invalid redirect_uri", model.InvalidRequest)
 	}
 
 	return
 }
+
+// findClient search a model.Client by id, the unknown clients are rejected with model.InvalidClient
+func findClient(finder repository.Finder, clientId string) (model.Client, error) {
+	i, err := finder.Find(clientId)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return model.Client{}, fmt.Errorf(`%w: client "%s" does not exist`, model.InvalidClient, clientId)
+	}
+
+	if err != nil {
+		return model.Client{}, err
+	}
+
+	return i.(model.Client), nil
+}
+
+// authenticateSecret authenticates a confidential client comparing its secret in constant time, the public
+// clients (without secret) are rejected with model.InvalidClient
+func authenticateSecret(finder repository.Finder, application model.Application) (model.Client, error) {
+	client, err := findClient(finder, application.Id)
+	if err != nil {
+		return model.Client{}, err
+	}
+
+	if client.Secret == "" || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(application.Secret)) != 1 {
+		return model.Client{}, fmt.Errorf("%w: invalid client credentials", model.InvalidClient)
+	}
+
+	return client, nil
+}
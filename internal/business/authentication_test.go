@@ -26,12 +26,12 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 	}{
 		{
 			authenticator: OwnerAuthenticator{
-				Storage: &repository.MockStorage{
+				Storage: repository.NewMockStorage(map[string]interface{}{
 					"contacto@yael-castro.com": model.Owner{
 						Id:       "contacto@yael-castro.com",
 						Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne", // yael.castro
 					},
-				},
+				}),
 			},
 			tests: []authenticationTestCase{
 				{
@@ -66,7 +66,7 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 				},
 				{
 					input:       model.Application{},
-					expectedErr: model.UnauthorizedClient,
+					expectedErr: model.InvalidClient,
 				},
 			},
 		},
@@ -88,3 +88,82 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 		})
 	}
 }
+
+// TestClientAuthenticator_Authenticate_Secret checks that the confidential clients must present their secret in
+// the token requests of every grant
+func TestClientAuthenticator_Authenticate_Secret(t *testing.T) {
+	client := ClientAuthenticator{
+		Finder: repository.MockClientFinder{
+			"web": {Id: "web", Secret: "secret"},
+		},
+	}
+
+	tdt := []struct {
+		exchanger CodeExchanger
+		exchange  model.Exchange
+		// expectedErr error of the token request with the valid secret
+		expectedErr error
+	}{
+		{
+			exchanger: AuthorizationCodeGrant{
+				Client:      client,
+				CodeStorage: repository.NewMockStorage(nil),
+			},
+			exchange: model.Exchange{
+				GrantType:         "authorization_code",
+				AuthorizationCode: "unknown",
+			},
+			expectedErr: model.InvalidGrant,
+		},
+		{
+			exchanger: DeviceAuthorizationGrant{
+				Client:        client,
+				DeviceStorage: repository.NewMockStorage(nil),
+			},
+			exchange: model.Exchange{
+				GrantType:  model.DeviceCodeGrantType,
+				DeviceCode: "unknown",
+			},
+			expectedErr: model.InvalidGrant,
+		},
+		{
+			exchanger: JWTBearerGrant{
+				Client: client,
+			},
+			exchange: model.Exchange{
+				GrantType: model.JWTBearerGrantType,
+			},
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for _, v := range tdt {
+		exchanger, exchange, expectedErr := v.exchanger, v.exchange, v.expectedErr
+
+		t.Run(reflect.TypeOf(exchanger).String(), func(t *testing.T) {
+			secrets := []struct {
+				secret      string
+				expectedErr error
+			}{
+				{secret: "secret", expectedErr: expectedErr},
+				// Wrong secret
+				{secret: "invalid", expectedErr: model.InvalidClient},
+				// Missing secret
+				{expectedErr: model.InvalidClient},
+			}
+
+			for i, v := range secrets {
+				t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+					exchange.Application = model.Application{Id: "web", Secret: v.secret}
+
+					_, err := exchanger.ExchangeCode(exchange)
+					if !errors.Is(err, v.expectedErr) {
+						t.Fatalf(`expected error "%v" but got "%v"`, v.expectedErr, err)
+					}
+
+					t.Log(err)
+				})
+			}
+		})
+	}
+}
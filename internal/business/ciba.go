@@ -0,0 +1,435 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// Default values for the CIBA flow
+const (
+	// DefaultBackchannelLifeTime life time of the authentication requests
+	DefaultBackchannelLifeTime = 5 * time.Minute
+	// MaximumBindingMessageLength maximum number of characters of a binding message
+	MaximumBindingMessageLength = 64
+)
+
+// BackchannelAuthorizer handles the requests of the Client-Initiated Backchannel Authentication flow (CIBA)
+type BackchannelAuthorizer interface {
+	// AuthorizeBackchannel receives the authentication request of the client, notifies the owner and returns the auth_req_id
+	AuthorizeBackchannel(model.BackchannelAuthorization) (model.BackchannelResponse, error)
+	// CompleteBackchannel receives the auth_req_id, the owner credentials and if the owner approves (true)
+	// or denies (false) the request
+	CompleteBackchannel(string, model.Owner, bool) error
+}
+
+// BackchannelGrant defines the interface related to the CIBA flow
+type BackchannelGrant interface {
+	BackchannelAuthorizer
+	CodeExchanger
+}
+
+// _ "implement" constraint for BackchannelAuthenticationGrant
+var _ BackchannelGrant = BackchannelAuthenticationGrant{}
+
+// BackchannelAuthenticationGrant made the validations that correspond to the CIBA flow
+//
+// The tokens are delivered depending on the mode of the client: in poll mode the client polls the token
+// endpoint, in ping mode the client is notified when the owner completes the request and in push mode
+// the tokens are sent to the client endpoint
+type BackchannelAuthenticationGrant struct {
+	// Issuer identifier of the authorization server used as "iss" claim of the generated tokens
+	Issuer string
+	// LifeTime of the authentication requests (DefaultBackchannelLifeTime by default)
+	LifeTime time.Duration
+	// Interval minimum amount of time between polling requests (DefaultPollingInterval by default)
+	Interval time.Duration
+	// ScopeParser parses a scope from string
+	ScopeParser
+	TokenGenerator
+	Owner  Authenticator
+	Client Authenticator
+	// Clients finder of the clients used to authenticate them and to obtain the token delivery mode,
+	// only the clients with secret can use the CIBA flow
+	Clients repository.Finder
+	// Owners store of the owners identified by the login hints
+	Owners repository.Storage
+	// Notifier reaches the device of the owner
+	Notifier
+	// ClientNotifier sends the notifications of the ping and push modes
+	ClientNotifier
+	// BackchannelStorage store for pending authentication requests indexed by auth_req_id
+	BackchannelStorage repository.DeviceStorer
+	// SessionStorage store for the sessions of the issued tokens
+	SessionStorage repository.Storage
+	// Policy decides if the token can be issued (Optional)
+	Policy Policy
+	// AccessClaims adds claims to the access tokens (Optional)
+	AccessClaims ClaimsEnricher
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or not entitled to the owner are rejected or removed
+	ScopePolicy ScopePolicy
+	// Subjects obtains the subject identifiers sent to the pairwise clients (Optional)
+	Subjects SubjectResolver
+}
+
+// AuthorizeBackchannel validates the authentication request, saves it and notifies the owner identified by the login_hint
+func (b BackchannelAuthenticationGrant) AuthorizeBackchannel(request model.BackchannelAuthorization) (res model.BackchannelResponse, err error) {
+	err = b.Client.Authenticate(request.Application)
+	if err != nil {
+		return
+	}
+
+	// The CIBA flow requires the authentication of the client, otherwise anyone who knows a client_id could
+	// send authentication requests to the owners
+	client, err := authenticateSecret(b.Clients, request.Application)
+	if err != nil {
+		return
+	}
+
+	request.Mode = client.BackchannelMode
+	if request.Mode == "" {
+		request.Mode = model.PollDelivery
+	}
+
+	if !request.Mode.IsValid() {
+		err = fmt.Errorf("%w: unsupported token delivery mode '%s'", model.UnauthorizedClient, request.Mode)
+		return
+	}
+
+	if request.Mode != model.PollDelivery {
+		if client.BackchannelEndpoint == "" {
+			err = fmt.Errorf("%w: client without notification endpoint", model.UnauthorizedClient)
+			return
+		}
+
+		if request.ClientNotificationToken == "" {
+			err = fmt.Errorf("%w: missing client_notification_token", model.InvalidRequest)
+			return
+		}
+
+		request.NotificationEndpoint = client.BackchannelEndpoint
+	}
+
+	if request.LoginHint == "" {
+		err = fmt.Errorf("%w: missing login_hint", model.InvalidRequest)
+		return
+	}
+
+	if len([]rune(request.BindingMessage)) > MaximumBindingMessageLength {
+		err = fmt.Errorf("%w: binding_message is too long", model.InvalidBindingMessage)
+		return
+	}
+
+	_, err = b.Owners.Obtain(request.LoginHint)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		err = fmt.Errorf("%w: unknown login_hint", model.UnknownUserId)
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	request.Scope, err = b.granter().grant(request.Application.Id, request.LoginHint, request.Scope)
+	if err != nil {
+		return
+	}
+
+	request.AuthReqId, err = generateDeviceCode()
+	if err != nil {
+		return
+	}
+
+	lifeTime, interval := b.lifeTime(), b.interval()
+
+	request.Status = model.BackchannelPending
+	request.ExpiresAt = time.Now().Add(lifeTime)
+	request.Interval = interval
+
+	if err = b.BackchannelStorage.Create(request.AuthReqId, request); err != nil {
+		return
+	}
+
+	if err = b.Notify(request); err != nil {
+		return
+	}
+
+	res = model.BackchannelResponse{
+		AuthReqId: request.AuthReqId,
+		ExpiresIn: int64(lifeTime / time.Second),
+	}
+
+	if request.Mode != model.PushDelivery {
+		res.Interval = int64(interval / time.Second)
+	}
+
+	return
+}
+
+// CompleteBackchannel authenticates the owner and approves or denies the authentication request, then
+// the client is notified in ping mode or receives the token in push mode
+func (b BackchannelAuthenticationGrant) CompleteBackchannel(authReqId string, owner model.Owner, approved bool) error {
+	err := b.Owner.Authenticate(owner)
+	if err != nil {
+		return err
+	}
+
+	request, err := b.obtain(authReqId)
+	if errors.Is(err, model.InvalidGrant) {
+		return fmt.Errorf("%w: invalid auth_req_id", model.InvalidRequest)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if request.LoginHint != owner.Id {
+		return fmt.Errorf("%w: the request belongs to another owner", model.AccessDenied)
+	}
+
+	status := model.BackchannelDenied
+	if approved {
+		status = model.BackchannelApproved
+	}
+
+	// The push mode requests keep the decision until the result is delivered, so the owner can retry the delivery
+	redelivery := request.Mode == model.PushDelivery && request.Status == status
+
+	if (request.Status != model.BackchannelPending && !redelivery) || time.Now().After(request.ExpiresAt) {
+		return fmt.Errorf("%w: auth_req_id was already completed or has expired", model.InvalidRequest)
+	}
+
+	// The decision is saved before notifying the client, so a failed notification does not lose it
+	if !redelivery {
+		request.Status = status
+
+		if err = b.BackchannelStorage.Update(authReqId, request); err != nil {
+			return err
+		}
+	}
+
+	switch request.Mode {
+	case model.PingDelivery:
+		return b.NotifyClient(request.NotificationEndpoint, request.ClientNotificationToken, model.Map{
+			"auth_req_id": authReqId,
+		})
+
+	case model.PushDelivery:
+		return b.push(request)
+	}
+
+	return nil
+}
+
+// push delivers the result of the authentication request to the client endpoint, the request is removed only
+// after the delivery and the tokens that could not be delivered are revoked
+func (b BackchannelAuthenticationGrant) push(request model.BackchannelAuthorization) error {
+	payload := model.Map{"auth_req_id": request.AuthReqId}
+
+	var tokenId string
+
+	if request.Status == model.BackchannelApproved {
+		tkn, id, err := b.issueToken(request, model.Session{})
+		if err != nil {
+			return err
+		}
+
+		tokenId = id
+
+		payload["access_token"] = tkn.AccessToken
+		payload["token_type"] = tkn.Type
+		payload["scope"] = tkn.Scope
+	} else {
+		payload["error"] = model.AccessDenied.Error()
+		payload["error_description"] = "the owner denied the request"
+	}
+
+	err := b.NotifyClient(request.NotificationEndpoint, request.ClientNotificationToken, payload)
+	if err != nil {
+		if tokenId != "" {
+			_ = b.SessionStorage.Delete(tokenId)
+		}
+
+		return err
+	}
+
+	return b.BackchannelStorage.Delete(request.AuthReqId)
+}
+
+// ExchangeCode handles the token requests of the poll and ping modes (grant_type=urn:openid:params:grant-type:ciba)
+//
+// While the owner does not approve the request returns model.AuthorizationPending, if the client polls too
+// fast returns model.SlowDown and increases the interval in 5 seconds
+func (b BackchannelAuthenticationGrant) ExchangeCode(exchange model.Exchange) (tkn model.Token, err error) {
+	if exchange.GrantType != model.CIBAGrantType {
+		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
+		return
+	}
+
+	err = b.Client.Authenticate(exchange.Application)
+	if err != nil {
+		return
+	}
+
+	if _, err = authenticateSecret(b.Clients, exchange.Application); err != nil {
+		return
+	}
+
+	request, err := b.obtain(exchange.AuthReqId)
+	if err != nil {
+		return
+	}
+
+	if request.Application.Id != exchange.Application.Id {
+		err = fmt.Errorf("%w: auth_req_id was issued to another client", model.InvalidGrant)
+		return
+	}
+
+	if request.Mode == model.PushDelivery {
+		err = fmt.Errorf("%w: the tokens are pushed to the client", model.UnauthorizedClient)
+		return
+	}
+
+	now := time.Now()
+
+	if now.After(request.ExpiresAt) {
+		_ = b.BackchannelStorage.Delete(request.AuthReqId)
+		err = fmt.Errorf("%w: auth_req_id has expired", model.ExpiredToken)
+		return
+	}
+
+	switch request.Status {
+	case model.BackchannelDenied:
+		_ = b.BackchannelStorage.Delete(request.AuthReqId)
+		err = fmt.Errorf("%w: the owner denied the request", model.AccessDenied)
+		return
+
+	case model.BackchannelPending:
+		err = fmt.Errorf("%w: the owner has not approved the request", model.AuthorizationPending)
+
+		// The ping clients are notified, so only the polling requests of the poll mode are limited
+		if request.Mode != model.PollDelivery {
+			return
+		}
+
+		// The polling state is saved apart from the authentication request, so the polling requests cannot
+		// overwrite the decision of the owner
+		slow, pollErr := b.BackchannelStorage.Poll(request.AuthReqId, model.Poll{
+			At:        now,
+			Interval:  request.Interval,
+			SlowDown:  5 * time.Second,
+			ExpiresAt: request.ExpiresAt,
+		})
+
+		switch {
+		case pollErr != nil:
+			err = pollErr
+		case slow:
+			err = fmt.Errorf("%w: polling too fast", model.SlowDown)
+		}
+
+		return
+	}
+
+	// The approved request is consumed, so the token is issued only once
+	if _, err = b.BackchannelStorage.Consume(request.AuthReqId); err != nil {
+		return tkn, fmt.Errorf("%w: invalid auth_req_id", model.InvalidGrant)
+	}
+
+	tkn, _, err = b.issueToken(request, exchange.Session)
+	return
+}
+
+// issueToken generates the token of an approved authentication request, saves its session and returns
+// the token and its id
+func (b BackchannelAuthenticationGrant) issueToken(request model.BackchannelAuthorization, session model.Session) (tkn model.Token, tokenId string, err error) {
+	scope, err := b.ParseScope(request.Scope)
+	if err != nil {
+		return
+	}
+
+	err = enforcePolicy(b.Policy, model.PolicyRequest{
+		Grant:     model.CIBAGrantType,
+		Owner:     request.LoginHint,
+		Client:    request.Application.Id,
+		IP:        session.IP,
+		UserAgent: session.UserAgent,
+	}, scope, model.InsufficientUserAuthentication)
+	if err != nil {
+		return
+	}
+
+	token := model.JWT{
+		Scope: scope,
+		StandardClaims: model.StandardClaims{
+			Id:       uuid.New().String(),
+			Issuer:   b.Issuer,
+			Subject:  request.LoginHint,
+			Audience: request.Application.Id,
+			IssuedAt: time.Now().Unix(),
+		},
+	}
+
+	if err = enrichClaims(b.AccessClaims, request.Application.Id, &token); err != nil {
+		return
+	}
+
+	token.Subject, err = resolveSubject(b.Subjects, request.Application.Id, request.LoginHint)
+	if err != nil {
+		return
+	}
+
+	tkn, err = b.GenerateToken(token)
+	if err != nil {
+		return
+	}
+
+	session.Owner = model.Owner{Id: request.LoginHint}
+
+	err = b.SessionStorage.Create(token.Id, session)
+	return tkn, token.Id, err
+}
+
+// granter returns the scopeGranter that applies the scope rules of the clients and the entitlements of the owners
+func (b BackchannelAuthenticationGrant) granter() scopeGranter {
+	return scopeGranter{ScopeParser: b.ScopeParser, Clients: b.Clients, Entitler: b.Entitler, ScopePolicy: b.ScopePolicy}
+}
+
+// obtain search a model.BackchannelAuthorization by auth_req_id
+func (b BackchannelAuthenticationGrant) obtain(authReqId string) (model.BackchannelAuthorization, error) {
+	i, err := b.BackchannelStorage.Obtain(authReqId)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return model.BackchannelAuthorization{}, fmt.Errorf("%w: invalid auth_req_id", model.InvalidGrant)
+	}
+
+	if err != nil {
+		return model.BackchannelAuthorization{}, err
+	}
+
+	return i.(model.BackchannelAuthorization), nil
+}
+
+// lifeTime returns the configured life time of the authentication requests or DefaultBackchannelLifeTime
+func (b BackchannelAuthenticationGrant) lifeTime() time.Duration {
+	if b.LifeTime <= 0 {
+		return DefaultBackchannelLifeTime
+	}
+
+	return b.LifeTime
+}
+
+// interval returns the configured polling interval or DefaultPollingInterval
+func (b BackchannelAuthenticationGrant) interval() time.Duration {
+	if b.Interval <= 0 {
+		return DefaultPollingInterval
+	}
+
+	return b.Interval
+}
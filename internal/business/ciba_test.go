@@ -0,0 +1,570 @@
+package business
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestBackchannelAuthenticationGrant_ExchangeCode checks the poll mode of the CIBA flow before and
+// after the owner approves the authentication request
+func TestBackchannelAuthenticationGrant_ExchangeCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners := repository.NewMockStorage(map[string]interface{}{
+		"contacto@yael-castro.com": model.Owner{
+			Id:       "contacto@yael-castro.com",
+			Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+		},
+	})
+
+	clients := repository.MockClientFinder{"call-center": {Secret: "call-center"}}
+	notifier := &MemoryNotifier{}
+
+	grant := BackchannelAuthenticationGrant{
+		Interval:           time.Hour,
+		ScopeParser:        NewScopeParser(),
+		TokenGenerator:     generator,
+		Owner:              OwnerAuthenticator{Storage: owners},
+		Client:             ClientAuthenticator{Finder: clients},
+		Clients:            clients,
+		Owners:             owners,
+		Notifier:           notifier,
+		BackchannelStorage: repository.NewMockStorage(nil),
+		SessionStorage:     repository.NewMockStorage(nil),
+	}
+
+	res, err := grant.AuthorizeBackchannel(model.BackchannelAuthorization{
+		Application:    model.Application{Id: "call-center", Secret: "call-center"},
+		LoginHint:      "contacto@yael-castro.com",
+		BindingMessage: "W4SCT",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := notifier.Requests(); len(requests) != 1 || requests[0].AuthReqId != res.AuthReqId {
+		t.Fatalf("expected notification of the request %s got %+v", res.AuthReqId, requests)
+	}
+
+	exchange := model.Exchange{
+		GrantType:   model.CIBAGrantType,
+		Application: model.Application{Id: "call-center", Secret: "call-center"},
+		AuthReqId:   res.AuthReqId,
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	tdt := []struct {
+		// approve indicates if the owner approves the request before polling
+		approve     bool
+		secret      string
+		expectedErr error
+	}{
+		// The client is not authenticated
+		{secret: "invalid", expectedErr: model.InvalidClient},
+		// The owner has not approved the request
+		{expectedErr: model.AuthorizationPending},
+		// The client polls too fast
+		{expectedErr: model.SlowDown},
+		// The owner approved the request
+		{approve: true},
+		// The auth_req_id was already used
+		{expectedErr: model.InvalidGrant},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if v.approve {
+				err := grant.CompleteBackchannel(res.AuthReqId, owner, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			exchange := exchange
+			if v.secret != "" {
+				exchange.Application.Secret = v.secret
+			}
+
+			token, err := grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			t.Logf("%+v", token)
+		})
+	}
+}
+
+// TestBackchannelAuthenticationGrant_AuthorizeBackchannel checks the validation of the authentication requests
+// and the delivery of the token in push mode
+func TestBackchannelAuthenticationGrant_AuthorizeBackchannel(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := make(chan model.Map, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer notification-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		payload := model.Map{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		pushed <- payload
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	owners := repository.NewMockStorage(map[string]interface{}{
+		"contacto@yael-castro.com": model.Owner{
+			Id:       "contacto@yael-castro.com",
+			Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+		},
+	})
+
+	clients := repository.MockClientFinder{
+		"call-center": {Secret: "call-center"},
+		// Public client, it cannot use the CIBA flow
+		"public": {},
+		"push": {
+			Secret:              "push",
+			BackchannelMode:     model.PushDelivery,
+			BackchannelEndpoint: server.URL,
+		},
+	}
+
+	grant := BackchannelAuthenticationGrant{
+		ScopeParser:        NewScopeParser(),
+		TokenGenerator:     generator,
+		Owner:              OwnerAuthenticator{Storage: owners},
+		Client:             ClientAuthenticator{Finder: clients},
+		Clients:            clients,
+		Owners:             owners,
+		Notifier:           &MemoryNotifier{},
+		ClientNotifier:     HTTPClientNotifier{Client: server.Client()},
+		BackchannelStorage: repository.NewMockStorage(nil),
+		SessionStorage:     repository.NewMockStorage(nil),
+	}
+
+	tdt := []struct {
+		input       model.BackchannelAuthorization
+		expectedErr error
+	}{
+		// Push mode
+		{
+			input: model.BackchannelAuthorization{
+				Application:             model.Application{Id: "push", Secret: "push"},
+				LoginHint:               "contacto@yael-castro.com",
+				ClientNotificationToken: "notification-token",
+			},
+		},
+		// Push mode without client_notification_token
+		{
+			input: model.BackchannelAuthorization{
+				Application: model.Application{Id: "push", Secret: "push"},
+				LoginHint:   "contacto@yael-castro.com",
+			},
+			expectedErr: model.InvalidRequest,
+		},
+		// Invalid client secret
+		{
+			input: model.BackchannelAuthorization{
+				Application: model.Application{Id: "call-center", Secret: "push"},
+				LoginHint:   "contacto@yael-castro.com",
+			},
+			expectedErr: model.InvalidClient,
+		},
+		// The public clients cannot authenticate
+		{
+			input: model.BackchannelAuthorization{
+				Application: model.Application{Id: "public"},
+				LoginHint:   "contacto@yael-castro.com",
+			},
+			expectedErr: model.InvalidClient,
+		},
+		// Unknown owner
+		{
+			input: model.BackchannelAuthorization{
+				Application: model.Application{Id: "call-center", Secret: "call-center"},
+				LoginHint:   "unknown@yael-castro.com",
+			},
+			expectedErr: model.UnknownUserId,
+		},
+		// Binding message too long
+		{
+			input: model.BackchannelAuthorization{
+				Application:    model.Application{Id: "call-center", Secret: "call-center"},
+				LoginHint:      "contacto@yael-castro.com",
+				BindingMessage: "this binding message is too long to be displayed on the device of the owner",
+			},
+			expectedErr: model.InvalidBindingMessage,
+		},
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			res, err := grant.AuthorizeBackchannel(v.input)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			err = grant.CompleteBackchannel(res.AuthReqId, owner, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload := <-pushed
+
+			if payload["auth_req_id"] != res.AuthReqId || payload["access_token"] == nil {
+				t.Fatalf("unexpected push payload %+v", payload)
+			}
+		})
+	}
+}
+
+// TestBackchannelAuthenticationGrant_AuthorizeBackchannel_Scope checks that the scope of the authentication request
+// is narrowed to the scope allowed for the client and entitled to the owner identified by the login_hint
+func TestBackchannelAuthenticationGrant_AuthorizeBackchannel_Scope(t *testing.T) {
+	owners := repository.NewMockStorage(map[string]interface{}{
+		"alice": model.Owner{Id: "alice"},
+		"bob":   model.Owner{Id: "bob"},
+	})
+
+	clients := repository.MockClientFinder{
+		"call-center": {Secret: "call-center", AllowedScopes: model.Mask{"orders": 0x03}},
+	}
+
+	tdt := []struct {
+		policy      ScopePolicy
+		owner       string
+		requested   string
+		output      string
+		expectedErr error
+	}{
+		// The scope is allowed and entitled
+		{
+			owner:     "bob",
+			requested: "orders:3",
+			output:    "orders:3",
+		},
+		// The owner is not entitled to the scope
+		{
+			owner:       "alice",
+			requested:   "orders:3",
+			expectedErr: model.InvalidScope,
+		},
+		// The scope is not allowed for the client
+		{
+			owner:       "bob",
+			requested:   "users:1",
+			expectedErr: model.InvalidScope,
+		},
+		// The scope is narrowed to the allowed and entitled scope
+		{
+			policy:    NarrowScope,
+			owner:     "alice",
+			requested: "orders:f users:1",
+			output:    "orders:1",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			grant := BackchannelAuthenticationGrant{
+				ScopeParser:        NewScopeParser(),
+				Client:             ClientAuthenticator{Finder: clients},
+				Clients:            clients,
+				Owners:             owners,
+				Notifier:           &MemoryNotifier{},
+				BackchannelStorage: repository.NewMockStorage(nil),
+				Entitler:           newTestAccessControl(),
+				ScopePolicy:        v.policy,
+			}
+
+			res, err := grant.AuthorizeBackchannel(model.BackchannelAuthorization{
+				Application: model.Application{Id: "call-center", Secret: "call-center"},
+				LoginHint:   v.owner,
+				Scope:       v.requested,
+			})
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			request, err := grant.obtain(res.AuthReqId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if request.Scope != v.output {
+				t.Fatalf(`expected scope "%s" got "%s"`, v.output, request.Scope)
+			}
+		})
+	}
+}
+
+// TestBackchannelAuthenticationGrant_CompleteBackchannel checks the decisions of the owner in poll mode: the denied
+// and expired requests are rejected and the polling requests do not overwrite the approval
+func TestBackchannelAuthenticationGrant_CompleteBackchannel(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners := repository.NewMockStorage(map[string]interface{}{
+		"contacto@yael-castro.com": model.Owner{
+			Id:       "contacto@yael-castro.com",
+			Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+		},
+	})
+
+	clients := repository.MockClientFinder{"call-center": {Secret: "call-center"}}
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	tdt := []struct {
+		// approve indicates if the owner approves (true) or denies (false) the request
+		approve bool
+		// expired indicates if the request expires before the owner decides
+		expired bool
+		// polls number of concurrent polling requests made while the owner decides
+		polls               int
+		expectedCompleteErr error
+		expectedExchangeErr error
+	}{
+		// The owner approves the request while the client polls
+		{
+			approve: true,
+			polls:   50,
+		},
+		// The owner denies the request
+		{
+			expectedExchangeErr: model.AccessDenied,
+		},
+		// The request expired before the owner decides
+		{
+			approve:             true,
+			expired:             true,
+			expectedCompleteErr: model.InvalidRequest,
+			expectedExchangeErr: model.ExpiredToken,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			requests := repository.NewMockStorage(nil)
+
+			grant := BackchannelAuthenticationGrant{
+				ScopeParser:        NewScopeParser(),
+				TokenGenerator:     generator,
+				Owner:              OwnerAuthenticator{Storage: owners},
+				Client:             ClientAuthenticator{Finder: clients},
+				Clients:            clients,
+				Owners:             owners,
+				Notifier:           &MemoryNotifier{},
+				BackchannelStorage: requests,
+				SessionStorage:     repository.NewMockStorage(nil),
+			}
+
+			res, err := grant.AuthorizeBackchannel(model.BackchannelAuthorization{
+				Application: model.Application{Id: "call-center", Secret: "call-center"},
+				LoginHint:   "contacto@yael-castro.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v.expired {
+				request, _ := grant.obtain(res.AuthReqId)
+				request.ExpiresAt = time.Now().Add(-time.Second)
+
+				_ = requests.Update(request.AuthReqId, request)
+			}
+
+			exchange := model.Exchange{
+				GrantType:   model.CIBAGrantType,
+				Application: model.Application{Id: "call-center", Secret: "call-center"},
+				AuthReqId:   res.AuthReqId,
+			}
+
+			var wg sync.WaitGroup
+
+			for n := 0; n < v.polls; n++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					_, _ = grant.ExchangeCode(exchange)
+				}()
+			}
+
+			err = grant.CompleteBackchannel(res.AuthReqId, owner, v.approve)
+			if !errors.Is(err, v.expectedCompleteErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedCompleteErr, err)
+			}
+
+			wg.Wait()
+
+			_, err = grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedExchangeErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedExchangeErr, err)
+			}
+
+			// The denied and expired requests are removed
+			if v.expectedExchangeErr != nil {
+				_, err = grant.ExchangeCode(exchange)
+				if !errors.Is(err, model.InvalidGrant) {
+					t.Fatalf(`expected error "%v" got "%v"`, model.InvalidGrant, err)
+				}
+			}
+		})
+	}
+}
+
+// TestBackchannelAuthenticationGrant_CompleteBackchannel_Push checks that the decision of the owner is kept if the
+// result cannot be pushed to the client, the undelivered token is revoked and the delivery can be retried
+func TestBackchannelAuthenticationGrant_CompleteBackchannel_Push(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var available int32
+
+	pushed := make(chan model.Map, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		payload := model.Map{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		pushed <- payload
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	owners := repository.NewMockStorage(map[string]interface{}{
+		"contacto@yael-castro.com": model.Owner{
+			Id:       "contacto@yael-castro.com",
+			Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+		},
+	})
+
+	clients := repository.MockClientFinder{
+		"push": {
+			Secret:              "push",
+			BackchannelMode:     model.PushDelivery,
+			BackchannelEndpoint: server.URL,
+		},
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	grant := BackchannelAuthenticationGrant{
+		ScopeParser:        NewScopeParser(),
+		TokenGenerator:     generator,
+		Owner:              OwnerAuthenticator{Storage: owners},
+		Client:             ClientAuthenticator{Finder: clients},
+		Clients:            clients,
+		Owners:             owners,
+		Notifier:           &MemoryNotifier{},
+		ClientNotifier:     HTTPClientNotifier{Client: server.Client()},
+		BackchannelStorage: repository.NewMockStorage(nil),
+		SessionStorage:     sessions,
+	}
+
+	res, err := grant.AuthorizeBackchannel(model.BackchannelAuthorization{
+		Application:             model.Application{Id: "push", Secret: "push"},
+		LoginHint:               "contacto@yael-castro.com",
+		ClientNotificationToken: "notification-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	// The client endpoint is not available
+	if err = grant.CompleteBackchannel(res.AuthReqId, owner, true); err == nil {
+		t.Fatal("expected error pushing the token")
+	}
+
+	if sessions.Len() != 0 {
+		t.Fatalf("the undelivered token was not revoked %+v", *sessions)
+	}
+
+	request, err := grant.obtain(res.AuthReqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Status != model.BackchannelApproved {
+		t.Fatalf(`expected status "%s" got "%s"`, model.BackchannelApproved, request.Status)
+	}
+
+	// The owner cannot change the decision
+	if err = grant.CompleteBackchannel(res.AuthReqId, owner, false); !errors.Is(err, model.InvalidRequest) {
+		t.Fatalf(`expected error "%v" got "%v"`, model.InvalidRequest, err)
+	}
+
+	atomic.StoreInt32(&available, 1)
+
+	// The delivery is retried
+	if err = grant.CompleteBackchannel(res.AuthReqId, owner, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload := <-pushed; payload["auth_req_id"] != res.AuthReqId || payload["access_token"] == nil {
+		t.Fatalf("unexpected push payload %+v", payload)
+	}
+
+	if sessions.Len() != 1 {
+		t.Fatalf("expected the session of the delivered token got %+v", *sessions)
+	}
+
+	if _, err = grant.obtain(res.AuthReqId); !errors.Is(err, model.InvalidGrant) {
+		t.Fatalf(`expected error "%v" got "%v"`, model.InvalidGrant, err)
+	}
+}
@@ -0,0 +1,226 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// DefaultWebhookTimeout maximum time to wait for the response of a claims webhook
+const DefaultWebhookTimeout = 2 * time.Second
+
+// ClaimsEnricher obtains additional claims of the tokens before they are generated
+type ClaimsEnricher interface {
+	// EnrichClaims returns the claims to be added to the token issued to the client
+	EnrichClaims(clientId string, claims model.JWT) (model.Map, error)
+}
+
+// _ "implement" constraints for the ClaimsEnricher implementations
+var (
+	_ ClaimsEnricher = ClaimsEnrichers{}
+	_ ClaimsEnricher = StaticEnricher{}
+	_ ClaimsEnricher = ProfileEnricher{}
+	_ ClaimsEnricher = ClientEnricher{}
+	_ ClaimsEnricher = WebhookEnricher{}
+)
+
+// ClaimsEnrichers applies every ClaimsEnricher in order, the claims of the first enrichers take precedence
+type ClaimsEnrichers []ClaimsEnricher
+
+// EnrichClaims merges the claims returned by the enrichers
+func (c ClaimsEnrichers) EnrichClaims(clientId string, claims model.JWT) (model.Map, error) {
+	enriched := model.Map{}
+
+	for _, enricher := range c {
+		m, err := enricher.EnrichClaims(clientId, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		mergeClaims(enriched, m)
+	}
+
+	return enriched, nil
+}
+
+// StaticEnricher adds fixed claims to the tokens of every client and of specific clients
+type StaticEnricher struct {
+	// Claims added to the tokens of every client
+	Claims model.Map
+	// Clients claims added to the tokens of each client indexed by client id, they take precedence over Claims
+	Clients map[string]model.Map
+}
+
+// EnrichClaims returns the claims of the client and the common claims
+func (s StaticEnricher) EnrichClaims(clientId string, _ model.JWT) (model.Map, error) {
+	enriched := model.Map{}
+
+	mergeClaims(enriched, s.Clients[clientId])
+	mergeClaims(enriched, s.Claims)
+
+	return enriched, nil
+}
+
+// ProfileEnricher adds the claims of the profile of the owner identified by the "sub" claim
+type ProfileEnricher struct {
+	// Profiles store of the profiles (model.Map) indexed by owner id
+	Profiles repository.Storage
+	// Claims names of the claims of the profile added to the tokens, every claim is added if it is empty
+	Claims []string
+}
+
+// EnrichClaims returns the selected claims of the profile, the owners without profile do not have claims
+func (p ProfileEnricher) EnrichClaims(_ string, claims model.JWT) (model.Map, error) {
+	i, err := p.Profiles.Obtain(claims.Subject)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return model.Map{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile := i.(model.Map)
+	if len(p.Claims) == 0 {
+		return profile, nil
+	}
+
+	enriched := model.Map{}
+
+	for _, claim := range p.Claims {
+		if v, ok := profile[claim]; ok {
+			enriched[claim] = v
+		}
+	}
+
+	return enriched, nil
+}
+
+// ClientEnricher adds the metadata of the client as claims
+type ClientEnricher struct {
+	// Clients finder of the clients
+	Clients repository.Finder
+	// Claims names of the claims indexed by metadata field (e.g. {"tenant": "tid"}),
+	// every field is added with its own name if it is nil
+	Claims map[string]string
+}
+
+// EnrichClaims returns the selected metadata of the client
+func (c ClientEnricher) EnrichClaims(clientId string, _ model.JWT) (model.Map, error) {
+	client, err := findClient(c.Clients, clientId)
+	if err != nil {
+		return nil, err
+	}
+
+	enriched := model.Map{}
+
+	for field, v := range client.Metadata {
+		claim := field
+
+		if c.Claims != nil {
+			var ok bool
+			if claim, ok = c.Claims[field]; !ok {
+				continue
+			}
+		}
+
+		enriched[claim] = v
+	}
+
+	return enriched, nil
+}
+
+// WebhookEnricher obtains the claims from an HTTP endpoint
+//
+// The endpoint receives a POST request with the JSON object {"client_id": "...", "claims": {...}}
+// and must respond with a JSON object that contains the claims to be added
+type WebhookEnricher struct {
+	// URL of the endpoint
+	URL string
+	// Client used to send the requests (http.DefaultClient by default)
+	Client *http.Client
+	// Timeout maximum time to wait for the response (DefaultWebhookTimeout by default)
+	Timeout time.Duration
+}
+
+// EnrichClaims sends the claims of the token to the endpoint and returns the claims of the response
+func (w WebhookEnricher) EnrichClaims(clientId string, claims model.JWT) (model.Map, error) {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	body, err := json.Marshal(model.Map{"client_id": clientId, "claims": claims})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf(`claims webhook "%s": %w`, w.URL, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf(`unexpected status "%d" of the claims webhook "%s"`, res.StatusCode, w.URL)
+	}
+
+	enriched := model.Map{}
+
+	if err = json.NewDecoder(res.Body).Decode(&enriched); err != nil {
+		return nil, fmt.Errorf(`malformed response of the claims webhook "%s": %w`, w.URL, err)
+	}
+
+	return enriched, nil
+}
+
+// enrichClaims adds the claims obtained by the ClaimsEnricher (if it is defined) to the additional claims of the token
+func enrichClaims(enricher ClaimsEnricher, clientId string, claims *model.JWT) error {
+	if enricher == nil {
+		return nil
+	}
+
+	enriched, err := enricher.EnrichClaims(clientId, *claims)
+	if err != nil {
+		return err
+	}
+
+	if claims.Claims == nil {
+		claims.Claims = model.Map{}
+	}
+
+	mergeClaims(claims.Claims, enriched)
+	return nil
+}
+
+// mergeClaims adds the claims that are not defined yet
+func mergeClaims(claims, other model.Map) {
+	for k, v := range other {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+}
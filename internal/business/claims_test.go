@@ -0,0 +1,278 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestClaimsEnrichers_EnrichClaims checks the claims obtained from the static configuration, the owner profile,
+// the client metadata and the webhooks
+func TestClaimsEnrichers_EnrichClaims(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			ClientId string    `json:"client_id"`
+			Claims   model.Map `json:"claims"`
+		}{}
+
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(model.Map{"department": "sales", "requested_by": request.ClientId, "sub": request.Claims["sub"]})
+	}))
+	defer server.Close()
+
+	profiles := repository.NewMockStorage(map[string]interface{}{
+		"alice": model.Map{"name": "Alice", "email": "alice@example.com", "phone": "555"},
+	})
+
+	clients := repository.MockClientFinder{
+		"mobile": {Metadata: map[string]string{"tenant": "acme", "internal": "x"}},
+	}
+
+	tdt := []struct {
+		enricher  ClaimsEnricher
+		owner     string
+		output    model.Map
+		expectErr bool
+	}{
+		// Static claims of every client and of the client
+		{
+			enricher: StaticEnricher{
+				Claims:  model.Map{"tier": "free", "region": "mx"},
+				Clients: map[string]model.Map{"mobile": {"tier": "gold"}},
+			},
+			output: model.Map{"tier": "gold", "region": "mx"},
+		},
+		// Selected claims of the profile
+		{
+			enricher: ProfileEnricher{Profiles: profiles, Claims: []string{"name", "email"}},
+			owner:    "alice",
+			output:   model.Map{"name": "Alice", "email": "alice@example.com"},
+		},
+		// Owner without profile
+		{
+			enricher: ProfileEnricher{Profiles: profiles},
+			owner:    "bob",
+			output:   model.Map{},
+		},
+		// Renamed metadata of the client
+		{
+			enricher: ClientEnricher{Clients: clients, Claims: map[string]string{"tenant": "tid"}},
+			output:   model.Map{"tid": "acme"},
+		},
+		// Claims of the webhook
+		{
+			enricher: WebhookEnricher{URL: server.URL},
+			owner:    "alice",
+			output:   model.Map{"department": "sales", "requested_by": "mobile", "sub": "alice"},
+		},
+		// The webhook does not respond in time
+		{
+			enricher:  WebhookEnricher{URL: server.URL + "/slow", Timeout: 50 * time.Millisecond},
+			expectErr: true,
+		},
+		// The webhook fails
+		{
+			enricher:  WebhookEnricher{URL: server.URL + "/error"},
+			expectErr: true,
+		},
+		// The first enrichers take precedence
+		{
+			enricher: ClaimsEnrichers{
+				StaticEnricher{Claims: model.Map{"name": "Static"}},
+				ProfileEnricher{Profiles: profiles, Claims: []string{"name", "phone"}},
+			},
+			owner:  "alice",
+			output: model.Map{"name": "Static", "phone": "555"},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			claims := model.JWT{StandardClaims: model.StandardClaims{Subject: v.owner}}
+
+			output, err := v.enricher.EnrichClaims("mobile", claims)
+			if (err != nil) != v.expectErr {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if !reflect.DeepEqual(v.output, output) {
+				t.Fatalf(`expected claims "%+v" got "%+v"`, v.output, output)
+			}
+		})
+	}
+}
+
+// TestAuthorizationCodeGrant_ExchangeCode_Claims checks that the enrichers of the access tokens and of the ID tokens
+// are applied separately and that they can not replace the registered claims
+func TestAuthorizationCodeGrant_ExchangeCode_Claims(t *testing.T) {
+	generator := JWTGenerator{}
+
+	if err := generator.SetPrivateKey([]byte(privateKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	grant := AuthorizationCodeGrant{
+		Issuer:         "https://auth.example.com",
+		ScopeParser:    NewScopeParser(),
+		CodeGenerator:  GenerateRandomCode,
+		TokenGenerator: generator,
+		Client: ClientAuthenticator{
+			Finder: repository.MockClientFinder{
+				"mobile": {AllowedOrigins: []string{"http://localhost/callback"}},
+			},
+		},
+		Owner: OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		AccessClaims:   StaticEnricher{Claims: model.Map{"tier": "gold", "sub": "attacker"}},
+		IDClaims:       StaticEnricher{Claims: model.Map{"name": "Yael", "nonce": "replaced"}},
+		CodeStorage:    repository.NewMockStorage(nil),
+		SessionStorage: repository.NewMockStorage(nil),
+	}
+
+	redirectURL, _ := url.Parse("http://localhost/callback")
+	application := model.Application{Id: "mobile", RedirectURL: redirectURL}
+
+	code, err := grant.Authorize(model.Authorization{
+		Application:  application,
+		ResponseType: "code",
+		State:        "AAA",
+		Scope:        "openid orders:1",
+		Nonce:        "n-0S6_WzA2Mj",
+		BasicAuth:    model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := grant.ExchangeCode(model.Exchange{
+		GrantType:         "authorization_code",
+		Application:       application,
+		AuthorizationCode: code,
+		State:             "AAA",
+		RedirectURL:       redirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(token string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+
+		_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+			return generator.publicKey(), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return claims
+	}
+
+	access := parse(tkn.AccessToken)
+	if access["tier"] != "gold" || access["sub"] != "contacto@yael-castro.com" || access["name"] != nil {
+		t.Fatalf(`unexpected access token claims "%v"`, access)
+	}
+
+	if tkn.IdToken == "" {
+		t.Fatal("missing id_token")
+	}
+
+	id := parse(tkn.IdToken)
+	if id["name"] != "Yael" || id["nonce"] != "n-0S6_WzA2Mj" || id["aud"] != "mobile" || id["tier"] != nil || id["scp"] != nil {
+		t.Fatalf(`unexpected id token claims "%v"`, id)
+	}
+}
+
+// TestJWTGenerator_GenerateToken_Claims checks that the bit masks keep their precision when the token has
+// additional claims, the masks greater than 2^53 cannot be represented as float64
+func TestJWTGenerator_GenerateToken_Claims(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdt := []struct {
+		scope  model.Mask
+		claims model.Map
+	}{
+		{
+			scope:  model.Mask{"admin": 0xffffffffffffffff},
+			claims: model.Map{"tier": "gold"},
+		},
+		{
+			scope:  model.Mask{"orders": 1<<53 + 1, "users": 0x8000000000000001},
+			claims: model.Map{"tenant": "acme"},
+		},
+		{
+			scope: model.Mask{"admin": 0xffffffffffffffff},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			claims := model.JWT{
+				Scope:          v.scope,
+				StandardClaims: model.StandardClaims{Subject: "contacto@yael-castro.com"},
+			}
+
+			if err := enrichClaims(StaticEnricher{Claims: v.claims}, "mobile", &claims); err != nil {
+				t.Fatal(err)
+			}
+
+			tkn, err := generator.GenerateToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := generator.ParseToken(tkn.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mask, err := newMask(parsed.Scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(mask, v.scope) {
+				t.Fatalf(`expected scope "%v" got "%v"`, v.scope, mask)
+			}
+
+			for k, expected := range v.claims {
+				if parsed.Claims[k] != expected {
+					t.Fatalf(`expected claim "%s" to be "%v" got "%v"`, k, expected, parsed.Claims[k])
+				}
+			}
+		})
+	}
+}
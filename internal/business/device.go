@@ -0,0 +1,404 @@
+package business
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// Default values for the Device Authorization Grant
+const (
+	// DefaultDeviceCodeLifeTime life time of the device codes and user codes
+	DefaultDeviceCodeLifeTime = 10 * time.Minute
+	// DefaultPollingInterval minimum amount of time between the polling requests
+	DefaultPollingInterval = 5 * time.Second
+	// DefaultUserCodeAttempts maximum number of user codes that an owner can enter during the life time of the device codes
+	DefaultUserCodeAttempts = 10
+)
+
+// userCodeCharset characters used to generate user codes, it does not contain vowels to avoid
+// the generation of words and is easy to type in devices with limited input (RFC 8628 section 6.1)
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength number of characters of the user codes (entropy of 20^8 ~ 2^34.5)
+const userCodeLength = 8
+
+// DeviceAuthorizer handles the requests of the Device Authorization Grant (RFC 8628)
+type DeviceAuthorizer interface {
+	// AuthorizeDevice receives the device authorization request and returns the device code and user code
+	AuthorizeDevice(model.DeviceAuthorization) (model.DeviceCodeResponse, error)
+	// VerifyUserCode receives the user code entered by the owner, the owner credentials and if the owner
+	// approves (true) or denies (false) the request
+	VerifyUserCode(model.UserCode, model.Owner, bool) error
+}
+
+// DeviceGrant defines the interface related to the device authorization grant
+type DeviceGrant interface {
+	DeviceAuthorizer
+	CodeExchanger
+}
+
+// _ "implement" constraint for DeviceAuthorizationGrant
+var _ DeviceGrant = DeviceAuthorizationGrant{}
+
+// DeviceAuthorizationGrant made the validations that correspond to the Device Authorization Grant flow (RFC 8628)
+type DeviceAuthorizationGrant struct {
+	// Issuer identifier of the authorization server used as "iss" claim of the generated tokens
+	Issuer string
+	// VerificationURI end-user verification URI where the owner enters the user code
+	VerificationURI string
+	// LifeTime of the device codes and user codes (DefaultDeviceCodeLifeTime by default)
+	LifeTime time.Duration
+	// Interval minimum amount of time between polling requests (DefaultPollingInterval by default)
+	Interval time.Duration
+	// ScopeParser parses a scope from string
+	ScopeParser
+	TokenGenerator
+	Owner  Authenticator
+	Client Authenticator
+	// DeviceStorage store for pending device authorizations indexed by device code
+	DeviceStorage repository.DeviceStorer
+	// UserCodeStorage store for device codes indexed by user code, each user code is consumed by the owner
+	UserCodeStorage repository.ConsumerStorage
+	// Attempts counter of the user codes entered by each owner, limits the guessing of user codes (Optional)
+	Attempts repository.Counter
+	// MaxAttempts maximum number of user codes that an owner can enter during the life time of the device codes
+	// (DefaultUserCodeAttempts by default)
+	MaxAttempts int
+	// SessionStorage store for the sessions of the issued tokens
+	SessionStorage repository.Storage
+	// Policy decides if the token can be issued (Optional)
+	Policy Policy
+	// AccessClaims adds claims to the access tokens (Optional)
+	AccessClaims ClaimsEnricher
+	// Clients finder of the clients used to obtain their allowed and default scopes (Optional)
+	Clients repository.Finder
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or not entitled to the owner are rejected or removed
+	ScopePolicy ScopePolicy
+	// Subjects obtains the subject identifiers sent to the pairwise clients (Optional)
+	Subjects SubjectResolver
+}
+
+// AuthorizeDevice identifies the client, validates the scope and saves the pending device authorization
+// using a random device code and a random user code
+func (d DeviceAuthorizationGrant) AuthorizeDevice(device model.DeviceAuthorization) (res model.DeviceCodeResponse, err error) {
+	err = d.Client.Authenticate(device.Application)
+	if err != nil {
+		return
+	}
+
+	// The scope is narrowed to the scope allowed for the client, the owner is not known yet
+	device.Scope, err = d.granter().grant(device.Application.Id, "", device.Scope)
+	if err != nil {
+		return
+	}
+
+	lifeTime, interval := d.lifeTime(), d.interval()
+
+	device.DeviceCode, err = generateDeviceCode()
+	if err != nil {
+		return
+	}
+
+	device.UserCode, err = generateUserCode()
+	if err != nil {
+		return
+	}
+
+	device.Status = model.DevicePending
+	device.ExpiresAt = time.Now().Add(lifeTime)
+	device.Interval = interval
+
+	if err = d.DeviceStorage.Create(device.DeviceCode, device); err != nil {
+		return
+	}
+
+	if err = d.UserCodeStorage.Create(string(device.UserCode), device.DeviceCode); err != nil {
+		return
+	}
+
+	userCode := device.UserCode[:userCodeLength/2] + "-" + device.UserCode[userCodeLength/2:]
+
+	res = model.DeviceCodeResponse{
+		DeviceCode:              device.DeviceCode,
+		UserCode:                userCode,
+		VerificationURI:         d.VerificationURI,
+		VerificationURIComplete: d.VerificationURI + "?" + url.Values{"user_code": {string(userCode)}}.Encode(),
+		ExpiresIn:               int64(lifeTime / time.Second),
+		Interval:                int64(interval / time.Second),
+	}
+
+	return
+}
+
+// VerifyUserCode authenticates the owner and approves or denies the device authorization related to the user code
+func (d DeviceAuthorizationGrant) VerifyUserCode(userCode model.UserCode, owner model.Owner, approved bool) error {
+	err := d.Owner.Authenticate(owner)
+	if err != nil {
+		return err
+	}
+
+	if err = d.countAttempt(owner.Id); err != nil {
+		return err
+	}
+
+	// The user code is consumed, so only one decision of the owner is saved
+	device, err := d.consumeUserCode(userCode.Normalize())
+	if err != nil {
+		return err
+	}
+
+	if device.Status != model.DevicePending {
+		return fmt.Errorf("%w: user code was already used", model.InvalidRequest)
+	}
+
+	device.Owner = model.Owner{Id: owner.Id}
+	device.Status = model.DeviceDenied
+
+	if approved {
+		device.Status = model.DeviceApproved
+	}
+
+	return d.DeviceStorage.Update(device.DeviceCode, device)
+}
+
+// ExchangeCode handles the polling requests of the device (grant_type=urn:ietf:params:oauth:grant-type:device_code)
+//
+// While the owner does not approve the request returns model.AuthorizationPending, if the device polls too
+// fast returns model.SlowDown and increases the interval in 5 seconds as RFC 8628 (section 3.5) defines
+func (d DeviceAuthorizationGrant) ExchangeCode(exchange model.Exchange) (tkn model.Token, err error) {
+	if exchange.GrantType != model.DeviceCodeGrantType {
+		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
+		return
+	}
+
+	err = d.Client.Authenticate(exchange.Application)
+	if err != nil {
+		return
+	}
+
+	device, err := d.obtain(exchange.DeviceCode)
+	if err != nil {
+		return
+	}
+
+	if device.Application.Id != exchange.Application.Id {
+		err = fmt.Errorf("%w: device_code was issued to another client", model.InvalidGrant)
+		return
+	}
+
+	now := time.Now()
+
+	if now.After(device.ExpiresAt) {
+		_ = d.DeviceStorage.Delete(device.DeviceCode)
+		err = fmt.Errorf("%w: device_code has expired", model.ExpiredToken)
+		return
+	}
+
+	switch device.Status {
+	case model.DeviceDenied:
+		_ = d.DeviceStorage.Delete(device.DeviceCode)
+		err = fmt.Errorf("%w: the owner denied the request", model.AccessDenied)
+		return
+
+	case model.DevicePending:
+		// The polling state is saved apart from the device authorization, so the polling requests cannot
+		// overwrite the approval of the owner
+		slow, pollErr := d.DeviceStorage.Poll(device.DeviceCode, model.Poll{
+			At:        now,
+			Interval:  device.Interval,
+			SlowDown:  5 * time.Second,
+			ExpiresAt: device.ExpiresAt,
+		})
+
+		switch {
+		case pollErr != nil:
+			err = pollErr
+		case slow:
+			err = fmt.Errorf("%w: polling too fast", model.SlowDown)
+		default:
+			err = fmt.Errorf("%w: the owner has not approved the request", model.AuthorizationPending)
+		}
+
+		return
+	}
+
+	// The approved request is consumed, so the token is issued only once
+	if _, err = d.DeviceStorage.Consume(device.DeviceCode); err != nil {
+		return tkn, fmt.Errorf("%w: invalid device_code", model.InvalidGrant)
+	}
+
+	// The owner is only known after the approval, so the entitlements are applied when the token is issued
+	granted, err := d.granter().grant(device.Application.Id, device.Owner.Id, device.Scope)
+	if err != nil {
+		return
+	}
+
+	scope, err := d.ParseScope(granted)
+	if err != nil {
+		return
+	}
+
+	err = enforcePolicy(d.Policy, model.PolicyRequest{
+		Grant:     model.DeviceCodeGrantType,
+		Owner:     device.Owner.Id,
+		Client:    device.Application.Id,
+		IP:        exchange.Session.IP,
+		UserAgent: exchange.Session.UserAgent,
+	}, scope, model.InsufficientUserAuthentication)
+	if err != nil {
+		return
+	}
+
+	token := model.JWT{
+		Scope: scope,
+		StandardClaims: model.StandardClaims{
+			Id:       uuid.New().String(),
+			Issuer:   d.Issuer,
+			Subject:  device.Owner.Id,
+			Audience: device.Application.Id,
+			IssuedAt: now.Unix(),
+		},
+	}
+
+	if err = enrichClaims(d.AccessClaims, device.Application.Id, &token); err != nil {
+		return
+	}
+
+	token.Subject, err = resolveSubject(d.Subjects, device.Application.Id, device.Owner.Id)
+	if err != nil {
+		return
+	}
+
+	tkn, err = d.GenerateToken(token)
+	if err != nil {
+		return
+	}
+
+	exchange.Session.Owner = device.Owner
+
+	err = d.SessionStorage.Create(token.Id, exchange.Session)
+	return
+}
+
+// granter returns the scopeGranter that applies the scope rules of the clients and the entitlements of the owners
+func (d DeviceAuthorizationGrant) granter() scopeGranter {
+	return scopeGranter{ScopeParser: d.ScopeParser, Clients: d.Clients, Entitler: d.Entitler, ScopePolicy: d.ScopePolicy}
+}
+
+// obtain search a model.DeviceAuthorization by device code
+func (d DeviceAuthorizationGrant) obtain(deviceCode string) (model.DeviceAuthorization, error) {
+	i, err := d.DeviceStorage.Obtain(deviceCode)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return model.DeviceAuthorization{}, fmt.Errorf("%w: invalid device_code", model.InvalidGrant)
+	}
+
+	if err != nil {
+		return model.DeviceAuthorization{}, err
+	}
+
+	return i.(model.DeviceAuthorization), nil
+}
+
+// consumeUserCode consumes the user code and search its model.DeviceAuthorization
+func (d DeviceAuthorizationGrant) consumeUserCode(userCode model.UserCode) (model.DeviceAuthorization, error) {
+	i, err := d.UserCodeStorage.Consume(string(userCode))
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return model.DeviceAuthorization{}, fmt.Errorf("%w: invalid user code", model.InvalidRequest)
+	}
+
+	if err != nil {
+		return model.DeviceAuthorization{}, err
+	}
+
+	device, err := d.obtain(i.(string))
+	if err != nil {
+		return model.DeviceAuthorization{}, fmt.Errorf("%w: invalid user code", model.InvalidRequest)
+	}
+
+	if time.Now().After(device.ExpiresAt) {
+		return model.DeviceAuthorization{}, fmt.Errorf("%w: user code has expired", model.InvalidRequest)
+	}
+
+	return device, nil
+}
+
+// countAttempt counts the user code entered by the owner, the user codes are rejected when the owner
+// exceeds the maximum number of attempts during the life time of the device codes
+func (d DeviceAuthorizationGrant) countAttempt(ownerId string) error {
+	if d.Attempts == nil {
+		return nil
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultUserCodeAttempts
+	}
+
+	count, err := d.Attempts.Increment("device:"+ownerId, d.lifeTime())
+	if err != nil {
+		return err
+	}
+
+	if count > int64(maxAttempts) {
+		return fmt.Errorf("%w: too many user codes were entered, try again later", model.AccessDenied)
+	}
+
+	return nil
+}
+
+// lifeTime returns the configured life time of the device codes or DefaultDeviceCodeLifeTime
+func (d DeviceAuthorizationGrant) lifeTime() time.Duration {
+	if d.LifeTime <= 0 || d.LifeTime > repository.MaximumDeviceCodeLifeTime {
+		return DefaultDeviceCodeLifeTime
+	}
+
+	return d.LifeTime
+}
+
+// interval returns the configured polling interval or DefaultPollingInterval
+func (d DeviceAuthorizationGrant) interval() time.Duration {
+	if d.Interval <= 0 {
+		return DefaultPollingInterval
+	}
+
+	return d.Interval
+}
+
+// generateDeviceCode generates a random device code of 256 bits encoded in base64 (URL)
+func generateDeviceCode() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generateUserCode generates a random user code using the userCodeCharset
+func generateUserCode() (model.UserCode, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeCharset)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeCharset[n.Int64()]
+	}
+
+	return model.UserCode(code), nil
+}
@@ -0,0 +1,353 @@
+package business
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestDeviceAuthorizationGrant_ExchangeCode checks the polling of the token endpoint in the
+// Device Authorization Grant (RFC 8628) before and after the owner approves the device
+func TestDeviceAuthorizationGrant_ExchangeCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := DeviceAuthorizationGrant{
+		VerificationURI: "http://localhost:8080/go-auth/v1/device",
+		Interval:        time.Hour,
+		ScopeParser:     NewScopeParser(),
+		TokenGenerator:  generator,
+		Client: ClientAuthenticator{
+			Finder: repository.MockClientFinder{"tv": {}},
+		},
+		Owner: OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		DeviceStorage:   repository.NewMockStorage(nil),
+		UserCodeStorage: repository.NewMockStorage(nil),
+		SessionStorage:  repository.NewMockStorage(nil),
+	}
+
+	res, err := grant.AuthorizeDevice(model.DeviceAuthorization{
+		Application: model.Application{Id: "tv"},
+		Scope:       "read:ff",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("%+v", res)
+
+	exchange := model.Exchange{
+		GrantType:   model.DeviceCodeGrantType,
+		Application: model.Application{Id: "tv"},
+		DeviceCode:  res.DeviceCode,
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	tdt := []struct {
+		// approve indicates if the owner approves the device before polling
+		approve     bool
+		expectedErr error
+	}{
+		// The owner has not approved the device
+		{expectedErr: model.AuthorizationPending},
+		// The device polls too fast
+		{expectedErr: model.SlowDown},
+		// The owner approved the device
+		{approve: true},
+		// The device code was already used
+		{expectedErr: model.InvalidGrant},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if v.approve {
+				// The user code is typed in lower case by the owner
+				err := grant.VerifyUserCode(model.UserCode(strings.ToLower(string(res.UserCode))), owner, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			token, err := grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			t.Logf("%+v", token)
+		})
+	}
+}
+
+// TestDeviceAuthorizationGrant_AuthorizeDevice_Scope checks that the scope of the device is narrowed to the scope
+// allowed for the client and that the default scope of the client is used if the device does not request a scope
+func TestDeviceAuthorizationGrant_AuthorizeDevice_Scope(t *testing.T) {
+	clients := repository.MockClientFinder{
+		"tv": {AllowedScopes: model.Mask{"read": 0x0f}, DefaultScope: "read:1"},
+	}
+
+	tdt := []struct {
+		policy      ScopePolicy
+		requested   string
+		output      string
+		expectedErr error
+	}{
+		// The default scope of the client is used
+		{output: "read:1"},
+		// The scope is allowed
+		{
+			requested: "read:3",
+			output:    "read:3",
+		},
+		// The scope is not allowed for the client
+		{
+			requested:   "admin:ffffffffffffffff",
+			expectedErr: model.InvalidScope,
+		},
+		// The scope is narrowed to the allowed scope
+		{
+			policy:    NarrowScope,
+			requested: "read:ff admin:ffffffffffffffff",
+			output:    "read:f",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			grant := DeviceAuthorizationGrant{
+				VerificationURI: "http://localhost:8080/go-auth/v1/device",
+				ScopeParser:     NewScopeParser(),
+				Client:          ClientAuthenticator{Finder: clients},
+				Clients:         clients,
+				ScopePolicy:     v.policy,
+				DeviceStorage:   repository.NewMockStorage(nil),
+				UserCodeStorage: repository.NewMockStorage(nil),
+			}
+
+			res, err := grant.AuthorizeDevice(model.DeviceAuthorization{
+				Application: model.Application{Id: "tv"},
+				Scope:       v.requested,
+			})
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			device, err := grant.obtain(res.DeviceCode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if device.Scope != v.output {
+				t.Fatalf(`expected scope "%s" got "%s"`, v.output, device.Scope)
+			}
+		})
+	}
+}
+
+// TestDeviceAuthorizationGrant_VerifyUserCode checks the decisions of the owner: the denied and expired requests
+// are rejected, each user code is used once and the polling requests do not overwrite the approval
+func TestDeviceAuthorizationGrant_VerifyUserCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	tdt := []struct {
+		// approve indicates if the owner approves (true) or denies (false) the request
+		approve bool
+		// expired indicates if the request expires before the owner decides
+		expired bool
+		// polls number of concurrent polling requests made while the owner decides
+		polls               int
+		expectedVerifyErr   error
+		expectedExchangeErr error
+	}{
+		// The owner approves the request while the device polls
+		{
+			approve: true,
+			polls:   50,
+		},
+		// The owner denies the request
+		{
+			expectedExchangeErr: model.AccessDenied,
+		},
+		// The request expired before the owner decides
+		{
+			approve:             true,
+			expired:             true,
+			expectedVerifyErr:   model.InvalidRequest,
+			expectedExchangeErr: model.ExpiredToken,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			devices := repository.NewMockStorage(nil)
+
+			grant := DeviceAuthorizationGrant{
+				VerificationURI: "http://localhost:8080/go-auth/v1/device",
+				ScopeParser:     NewScopeParser(),
+				TokenGenerator:  generator,
+				Client: ClientAuthenticator{
+					Finder: repository.MockClientFinder{"tv": {}},
+				},
+				Owner: OwnerAuthenticator{
+					Storage: repository.NewMockStorage(map[string]interface{}{
+						"contacto@yael-castro.com": model.Owner{
+							Id:       "contacto@yael-castro.com",
+							Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+						},
+					}),
+				},
+				DeviceStorage:   devices,
+				UserCodeStorage: repository.NewMockStorage(nil),
+				SessionStorage:  repository.NewMockStorage(nil),
+			}
+
+			res, err := grant.AuthorizeDevice(model.DeviceAuthorization{Application: model.Application{Id: "tv"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v.expired {
+				device, _ := grant.obtain(res.DeviceCode)
+				device.ExpiresAt = time.Now().Add(-time.Second)
+
+				_ = devices.Update(device.DeviceCode, device)
+			}
+
+			exchange := model.Exchange{
+				GrantType:   model.DeviceCodeGrantType,
+				Application: model.Application{Id: "tv"},
+				DeviceCode:  res.DeviceCode,
+			}
+
+			var wg sync.WaitGroup
+
+			for n := 0; n < v.polls; n++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					_, _ = grant.ExchangeCode(exchange)
+				}()
+			}
+
+			err = grant.VerifyUserCode(res.UserCode, owner, v.approve)
+			if !errors.Is(err, v.expectedVerifyErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedVerifyErr, err)
+			}
+
+			wg.Wait()
+
+			// The user code was consumed
+			if err == nil {
+				err = grant.VerifyUserCode(res.UserCode, owner, !v.approve)
+				if !errors.Is(err, model.InvalidRequest) {
+					t.Fatalf(`expected error "%v" got "%v"`, model.InvalidRequest, err)
+				}
+			}
+
+			_, err = grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedExchangeErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedExchangeErr, err)
+			}
+
+			// The denied and expired requests are removed
+			if v.expectedExchangeErr != nil {
+				_, err = grant.ExchangeCode(exchange)
+				if !errors.Is(err, model.InvalidGrant) {
+					t.Fatalf(`expected error "%v" got "%v"`, model.InvalidGrant, err)
+				}
+			}
+		})
+	}
+}
+
+// TestDeviceAuthorizationGrant_VerifyUserCode_Attempts checks that an owner cannot guess user codes, the user codes
+// are rejected after the maximum number of attempts even if they are valid
+func TestDeviceAuthorizationGrant_VerifyUserCode_Attempts(t *testing.T) {
+	grant := DeviceAuthorizationGrant{
+		VerificationURI: "http://localhost:8080/go-auth/v1/device",
+		ScopeParser:     NewScopeParser(),
+		Client: ClientAuthenticator{
+			Finder: repository.MockClientFinder{"tv": {}},
+		},
+		Owner: OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		DeviceStorage:   repository.NewMockStorage(nil),
+		UserCodeStorage: repository.NewMockStorage(nil),
+		Attempts:        repository.NewMockStorage(nil),
+		MaxAttempts:     2,
+	}
+
+	res, err := grant.AuthorizeDevice(model.DeviceAuthorization{Application: model.Application{Id: "tv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := model.Owner{Id: "contacto@yael-castro.com", Password: "yael.castro"}
+
+	tdt := []struct {
+		userCode    model.UserCode
+		expectedErr error
+	}{
+		{
+			userCode:    "BCDF-GHJK",
+			expectedErr: model.InvalidRequest,
+		},
+		{
+			userCode:    "BCDF-GHJL",
+			expectedErr: model.InvalidRequest,
+		},
+		// The valid user code is rejected after the maximum number of attempts
+		{
+			userCode:    res.UserCode,
+			expectedErr: model.AccessDenied,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := grant.VerifyUserCode(v.userCode, owner, true)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+		})
+	}
+}
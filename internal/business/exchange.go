@@ -0,0 +1,305 @@
+package business
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// _ "implement" constraint for TokenExchangeGrant
+var _ CodeExchanger = TokenExchangeGrant{}
+
+// TokenExchangeGrant made the validations that correspond to the Token Exchange grant (RFC 8693)
+//
+// Only the tokens issued by the authorization server are accepted as subject_token and actor_token,
+// the issued token is down-scoped to the requested scope and audience and carries the "act" claim
+// that identifies the acting party
+type TokenExchangeGrant struct {
+	// Issuer identifier of the authorization server used as "iss" claim of the generated tokens
+	Issuer string
+	// Client authenticates the client that made the request
+	Client Authenticator
+	// Clients finder of the clients used to authenticate them and to obtain the audiences allowed for each client,
+	// only the clients with secret can exchange tokens
+	Clients repository.Finder
+	// ScopeParser parses a scope from string
+	ScopeParser
+	// Tokens resolves the subject and actor tokens, the JWTs, the PASETO tokens, the encrypted tokens and
+	// the opaque tokens are accepted
+	Tokens TokenResolver
+	TokenGenerator
+	// SessionStorage store for the sessions of the issued tokens
+	SessionStorage repository.Storage
+	// Policy decides if the token can be issued (Optional)
+	Policy Policy
+	// AccessClaims adds claims to the access tokens (Optional)
+	AccessClaims ClaimsEnricher
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or not entitled to the owner are rejected or removed
+	ScopePolicy ScopePolicy
+	// Subjects obtains the subject identifiers sent to the pairwise clients (Optional)
+	Subjects SubjectResolver
+}
+
+// ExchangeCode exchanges the subject_token for a new token (grant_type=urn:ietf:params:oauth:grant-type:token-exchange)
+func (t TokenExchangeGrant) ExchangeCode(exchange model.Exchange) (tkn model.Token, err error) {
+	if exchange.GrantType != model.TokenExchangeGrantType {
+		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
+		return
+	}
+
+	err = t.Client.Authenticate(exchange.Application)
+	if err != nil {
+		return
+	}
+
+	// The token exchange is restricted to the confidential clients, so a leaked subject_token cannot be
+	// exchanged knowing only a client_id
+	client, err := authenticateSecret(t.Clients, exchange.Application)
+	if err != nil {
+		return
+	}
+
+	if len(client.ExchangeAudiences) == 0 {
+		err = fmt.Errorf("%w: client is not allowed to exchange tokens", model.UnauthorizedClient)
+		return
+	}
+
+	audience, err := exchangeAudience(exchange.Audience, exchange.Resources)
+	if err != nil {
+		return
+	}
+
+	if audience == "" {
+		audience = client.Id
+	}
+
+	if !client.CanExchangeFor(audience) {
+		err = fmt.Errorf("%w: client is not allowed to exchange tokens for audience '%s'", model.InvalidTarget, audience)
+		return
+	}
+
+	tokenType, err := issuedTokenType(exchange.RequestedTokenType)
+	if err != nil {
+		return
+	}
+
+	// The "sub" of the tokens could be a pairwise subject identifier, so the owners are obtained from the sessions
+	subject, owner, err := t.parseToken("subject_token", exchange.SubjectToken, exchange.SubjectTokenType)
+	if err != nil {
+		return
+	}
+
+	actor := &model.Actor{Subject: client.Id}
+
+	if exchange.ActorToken != "" {
+		var actorOwner string
+
+		_, actorOwner, err = t.parseToken("actor_token", exchange.ActorToken, exchange.ActorTokenType)
+		if err != nil {
+			return
+		}
+
+		actor.Subject, err = resolveSubject(t.Subjects, exchange.Application.Id, actorOwner)
+		if err != nil {
+			return
+		}
+	} else if exchange.ActorTokenType != "" {
+		err = fmt.Errorf("%w: actor_token_type without actor_token", model.InvalidRequest)
+		return
+	}
+
+	// The prior actors of the subject token are kept as the delegation chain
+	actor.Actor = subject.Actor
+
+	scope, err := t.downScope(exchange.Scope, subject.Scope)
+	if err != nil {
+		return
+	}
+
+	// The exchanged scope is narrowed to the scope allowed for the client and entitled to the owner
+	if mask, ok := scope.(model.Mask); ok {
+		scope, err = t.granter().narrow(client, owner, mask)
+		if err != nil {
+			return
+		}
+	}
+
+	err = enforcePolicy(t.Policy, model.PolicyRequest{
+		Grant:     model.TokenExchangeGrantType,
+		Owner:     owner,
+		Client:    exchange.Application.Id,
+		IP:        exchange.Session.IP,
+		UserAgent: exchange.Session.UserAgent,
+	}, scope, model.InsufficientUserAuthentication)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	token := model.JWT{
+		Scope: scope,
+		Actor: actor,
+		StandardClaims: model.StandardClaims{
+			Id:       uuid.New().String(),
+			Issuer:   t.Issuer,
+			Subject:  owner,
+			Audience: audience,
+			IssuedAt: now.Unix(),
+			// The exchanged token can not outlive the subject token
+			ExpiresAt: subject.ExpiresAt,
+		},
+	}
+
+	if err = enrichClaims(t.AccessClaims, exchange.Application.Id, &token); err != nil {
+		return
+	}
+
+	token.Subject, err = resolveSubject(t.Subjects, exchange.Application.Id, owner)
+	if err != nil {
+		return
+	}
+
+	tkn, err = t.GenerateToken(token)
+	if err != nil {
+		return
+	}
+
+	// The format of the token depends on the generator of the audience (e.g. opaque or PASETO tokens)
+	if tokenType == model.JWTTokenType && !isJWT(tkn.AccessToken) {
+		err = fmt.Errorf("%w: the tokens for audience '%s' are not JWTs", model.InvalidRequest, audience)
+		return
+	}
+
+	tkn.IssuedTokenType = tokenType
+
+	exchange.Session.Owner = model.Owner{Id: owner}
+	if subject.ExpiresAt != 0 {
+		exchange.Session.Expiration = time.Unix(subject.ExpiresAt, 0).Sub(now)
+	}
+
+	err = t.SessionStorage.Create(token.Id, exchange.Session)
+	return
+}
+
+// granter returns the scopeGranter that applies the scope rules of the clients and the entitlements of the owners
+func (t TokenExchangeGrant) granter() scopeGranter {
+	return scopeGranter{ScopeParser: t.ScopeParser, Clients: t.Clients, Entitler: t.Entitler, ScopePolicy: t.ScopePolicy}
+}
+
+// parseToken verifies that the token is active and was issued by the authorization server, returns the claims of
+// the token and the id of the owner saved in the session
+func (t TokenExchangeGrant) parseToken(parameter, token, tokenType string) (model.JWT, string, error) {
+	if token == "" {
+		return model.JWT{}, "", fmt.Errorf("%w: missing %s", model.InvalidRequest, parameter)
+	}
+
+	if tokenType != model.AccessTokenType && tokenType != model.JWTTokenType {
+		return model.JWT{}, "", fmt.Errorf("%w: unsupported %s_type '%s'", model.InvalidRequest, parameter, tokenType)
+	}
+
+	claims, owner, err := t.Tokens.ResolveToken(token)
+	if err != nil {
+		return model.JWT{}, "", err
+	}
+
+	// The invalid, expired and revoked tokens are not active
+	if claims == nil {
+		return model.JWT{}, "", fmt.Errorf("%w: %s is not active", model.InvalidGrant, parameter)
+	}
+
+	if claims.Issuer != t.Issuer {
+		return model.JWT{}, "", fmt.Errorf("%w: %s was not issued by this server", model.InvalidGrant, parameter)
+	}
+
+	// The tokens without session cannot be revoked
+	if claims.Id == "" {
+		return model.JWT{}, "", fmt.Errorf("%w: %s without jti", model.InvalidGrant, parameter)
+	}
+
+	// The "sub" claim is only used if the session does not save the owner
+	if owner == "" {
+		owner = claims.Subject
+	}
+
+	return *claims, owner, nil
+}
+
+// downScope validates that the requested scope is contained in the scope of the subject token,
+// if no scope is requested the scope of the subject token is used
+func (t TokenExchangeGrant) downScope(requested string, subjectScope interface{}) (interface{}, error) {
+	granted, err := newMask(subjectScope)
+	if err != nil {
+		return nil, err
+	}
+
+	if requested == "" {
+		if len(granted) == 0 {
+			return nil, nil
+		}
+
+		return granted, nil
+	}
+
+	i, err := t.ParseScope(requested)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := newMask(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSubset(scope, granted) {
+		return nil, fmt.Errorf("%w: requested scope exceeds the scope of the subject_token", model.InvalidScope)
+	}
+
+	return scope, nil
+}
+
+// issuedTokenType validates the requested_token_type and returns the issued_token_type, the "jwt" type is only
+// issued if the generated token is a JWT
+func issuedTokenType(requested string) (string, error) {
+	switch requested {
+	case "", model.AccessTokenType:
+		return model.AccessTokenType, nil
+	case model.JWTTokenType:
+		return model.JWTTokenType, nil
+	}
+
+	return "", fmt.Errorf("%w: unsupported requested_token_type '%s'", model.InvalidRequest, requested)
+}
+
+// isJWT indicates if the token is a signed or an encrypted JWT
+func isJWT(token string) bool {
+	if strings.HasPrefix(token, PasetoV4Public) {
+		return false
+	}
+
+	return strings.Count(token, ".") == 2 || isEncrypted(token)
+}
+
+// exchangeAudience returns the audience of the exchanged token indicated by the "audience" or the "resource"
+// parameter, both parameters must indicate the same target if they are received (RFC 8693 section 2.1)
+func exchangeAudience(audience string, resources []string) (string, error) {
+	switch {
+	case len(resources) > 1:
+		return "", fmt.Errorf("%w: only one resource can be requested per token", model.InvalidTarget)
+
+	case len(resources) == 1:
+		if audience != "" && audience != resources[0] {
+			return "", fmt.Errorf("%w: audience '%s' and resource '%s' indicate different targets", model.InvalidTarget, audience, resources[0])
+		}
+
+		return resources[0], nil
+	}
+
+	return audience, nil
+}
@@ -0,0 +1,450 @@
+package business
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestTokenExchangeGrant_ExchangeCode checks the validation of the subject_token, the down-scoping
+// and the audience policy of the Token Exchange grant (RFC 8693)
+func TestTokenExchangeGrant_ExchangeCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	clients := repository.MockClientFinder{
+		"gateway": {Secret: "gateway", ExchangeAudiences: []string{"orders"}},
+		"mobile":  {Secret: "mobile"},
+		// Public client, it cannot authenticate
+		"spa": {ExchangeAudiences: []string{"orders"}},
+	}
+
+	grant := TokenExchangeGrant{
+		Issuer:         "go-test",
+		Client:         ClientAuthenticator{Finder: clients},
+		Clients:        clients,
+		ScopeParser:    NewScopeParser(),
+		Tokens:         Introspector{TokenParser: generator, SessionStorage: sessions},
+		TokenGenerator: generator,
+		SessionStorage: sessions,
+	}
+
+	// issue generates a token of the owner and saves its session
+	issue := func(issuer string, revoked bool) string {
+		claims := model.JWT{
+			Scope: model.Mask{"read": 0xff, "write": 0x0f},
+			StandardClaims: model.StandardClaims{
+				Id:       uuid.New().String(),
+				Issuer:   issuer,
+				Subject:  "contacto@yael-castro.com",
+				Audience: "mobile",
+			},
+		}
+
+		token, err := generator.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !revoked {
+			_ = sessions.Create(claims.Id, model.Session{Owner: model.Owner{Id: claims.Subject}})
+		}
+
+		return token.AccessToken
+	}
+
+	subjectToken := issue("go-test", false)
+
+	// The opaque tokens are resolved from their sessions
+	opaqueClaims := model.JWT{
+		Scope: model.Mask{"read": 0xff},
+		StandardClaims: model.StandardClaims{
+			Id:      uuid.New().String(),
+			Issuer:  "go-test",
+			Subject: "contacto@yael-castro.com",
+		},
+	}
+
+	opaqueToken, err := OpaqueGenerator{SessionStorage: sessions}.GenerateToken(opaqueClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = sessions.Create(opaqueClaims.Id, model.Session{Owner: model.Owner{Id: opaqueClaims.Subject}})
+
+	tdt := []struct {
+		input            model.Exchange
+		expectedActor    string
+		expectedAudience string
+		expectedErr      error
+	}{
+		// Down-scoped token for an allowed audience
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Scope:       "read:0f",
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedActor:    "gateway",
+			expectedAudience: "orders",
+		},
+		// The actor token identifies the acting party
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					ActorToken:       issue("go-test", false),
+					ActorTokenType:   model.JWTTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedActor:    "contacto@yael-castro.com",
+			expectedAudience: "orders",
+		},
+		// Opaque subject token
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Scope:       "read:0f",
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     opaqueToken.AccessToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedActor:    "gateway",
+			expectedAudience: "orders",
+		},
+		// The resource indicates the audience
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Resources:   []string{"orders"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+				},
+			},
+			expectedActor:    "gateway",
+			expectedAudience: "orders",
+		},
+		// The client is not allowed to exchange tokens for the resource
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Resources:   []string{"payments"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+				},
+			},
+			expectedErr: model.InvalidTarget,
+		},
+		// The audience and the resource indicate different targets
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Resources:   []string{"orders"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "payments",
+				},
+			},
+			expectedErr: model.InvalidTarget,
+		},
+		// Only one resource per token
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Resources:   []string{"orders", "payments"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+				},
+			},
+			expectedErr: model.InvalidTarget,
+		},
+		// Unknown opaque subject token
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     "unknown",
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidGrant,
+		},
+		// The requested scope exceeds the scope of the subject token
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				Scope:       "read:1ff",
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidScope,
+		},
+		// The client is not allowed to exchange tokens for the audience
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "payments",
+				},
+			},
+			expectedErr: model.InvalidTarget,
+		},
+		// The client is not allowed to exchange tokens
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "mobile", Secret: "mobile"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+				},
+			},
+			expectedErr: model.UnauthorizedClient,
+		},
+		// The subject token was issued by another server
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     issue("another", false),
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidGrant,
+		},
+		// The session of the subject token was revoked
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     issue("go-test", true),
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidGrant,
+		},
+		// Invalid client secret
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "mobile"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidClient,
+		},
+		// The public clients cannot exchange tokens
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "spa"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: model.AccessTokenType,
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidClient,
+		},
+		// Unsupported subject_token_type
+		{
+			input: model.Exchange{
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:     subjectToken,
+					SubjectTokenType: "urn:ietf:params:oauth:token-type:saml2",
+					Audience:         "orders",
+				},
+			},
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			v.input.GrantType = model.TokenExchangeGrantType
+
+			token, err := grant.ExchangeCode(v.input)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			claims, err := generator.ParseToken(token.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Actor == nil || claims.Actor.Subject != v.expectedActor {
+				t.Fatalf(`expected actor "%s" got "%+v"`, v.expectedActor, claims.Actor)
+			}
+
+			if claims.Audience != v.expectedAudience {
+				t.Fatalf(`expected audience "%s" got "%s"`, v.expectedAudience, claims.Audience)
+			}
+
+			if token.IssuedTokenType != model.AccessTokenType {
+				t.Fatalf(`expected issued_token_type "%s" got "%s"`, model.AccessTokenType, token.IssuedTokenType)
+			}
+
+			t.Logf("%+v", claims)
+		})
+	}
+}
+
+// TestTokenExchangeGrant_ExchangeCode_IssuedTokenType checks that the issued_token_type corresponds to the format
+// of the generated token
+func TestTokenExchangeGrant_ExchangeCode_IssuedTokenType(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paseto := PasetoGenerator{}
+
+	err = paseto.SetPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	clients := repository.MockClientFinder{
+		"gateway": {Secret: "gateway", ExchangeAudiences: []string{"orders"}},
+	}
+
+	claims := model.JWT{
+		Scope: model.Mask{"read": 0xff},
+		StandardClaims: model.StandardClaims{
+			Id:      uuid.New().String(),
+			Issuer:  "go-test",
+			Subject: "contacto@yael-castro.com",
+		},
+	}
+
+	subjectToken, err := generator.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = sessions.Create(claims.Id, model.Session{Owner: model.Owner{Id: claims.Subject}})
+
+	tdt := []struct {
+		generator               TokenGenerator
+		requestedTokenType      string
+		expectedIssuedTokenType string
+		expectedErr             error
+	}{
+		{
+			generator:               generator,
+			expectedIssuedTokenType: model.AccessTokenType,
+		},
+		{
+			generator:               generator,
+			requestedTokenType:      model.JWTTokenType,
+			expectedIssuedTokenType: model.JWTTokenType,
+		},
+		{
+			generator:               OpaqueGenerator{SessionStorage: sessions},
+			expectedIssuedTokenType: model.AccessTokenType,
+		},
+		// The opaque tokens are not JWTs
+		{
+			generator:          OpaqueGenerator{SessionStorage: sessions},
+			requestedTokenType: model.JWTTokenType,
+			expectedErr:        model.InvalidRequest,
+		},
+		// The PASETO tokens are not JWTs
+		{
+			generator:          paseto,
+			requestedTokenType: model.JWTTokenType,
+			expectedErr:        model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			grant := TokenExchangeGrant{
+				Issuer:         "go-test",
+				Client:         ClientAuthenticator{Finder: clients},
+				Clients:        clients,
+				ScopeParser:    NewScopeParser(),
+				Tokens:         Introspector{TokenParser: generator, SessionStorage: sessions},
+				TokenGenerator: v.generator,
+				SessionStorage: sessions,
+			}
+
+			token, err := grant.ExchangeCode(model.Exchange{
+				GrantType:   model.TokenExchangeGrantType,
+				Application: model.Application{Id: "gateway", Secret: "gateway"},
+				TokenExchange: model.TokenExchange{
+					SubjectToken:       subjectToken.AccessToken,
+					SubjectTokenType:   model.AccessTokenType,
+					RequestedTokenType: v.requestedTokenType,
+					Audience:           "orders",
+				},
+			})
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if token.IssuedTokenType != v.expectedIssuedTokenType {
+				t.Fatalf(`expected issued_token_type "%s" got "%s"`, v.expectedIssuedTokenType, token.IssuedTokenType)
+			}
+		})
+	}
+}
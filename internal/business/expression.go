@@ -0,0 +1,620 @@
+package business
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Expression boolean expression of the policy language evaluated over the facts of a model.PolicyRequest
+//
+// Identifiers: owner, client, grant, user_agent (strings), scope (mask), ip, hour (0-23) and weekday (e.g. "monday")
+//
+// Operators: ==, !=, <, <=, >, >=, in, not in, matches (glob), contains (substring), has (scope), and, or, not
+//
+// Example:
+//
+//	client == "mobile" and not owner matches "*@example.com"
+//	ip not in ["10.0.0.0/8", "192.168.1.10"] or hour < 8 or hour >= 20
+//	scope has "admin:1" and weekday in ["saturday", "sunday"]
+type Expression struct {
+	source string
+	root   expressionNode
+}
+
+// CompileExpression parses the source of an Expression, the scopes compared with the "has" operator are parsed
+// with the ScopeParser (NewScopeParser() by default)
+func CompileExpression(source string, parser ScopeParser) (Expression, error) {
+	if parser == nil {
+		parser = NewScopeParser()
+	}
+
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return Expression{}, fmt.Errorf(`expression "%s": %w`, source, err)
+	}
+
+	p := &expressionParser{tokens: tokens, scopes: parser}
+
+	root, err := p.parseOr()
+	if err == nil && p.position < len(p.tokens) {
+		err = fmt.Errorf(`unexpected "%s"`, p.tokens[p.position].text)
+	}
+
+	if err != nil {
+		return Expression{}, fmt.Errorf(`expression "%s": %w`, source, err)
+	}
+
+	return Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the Expression
+func (e Expression) String() string {
+	return e.source
+}
+
+// Evaluate evaluates the Expression using the facts of the request
+func (e Expression) Evaluate(request model.PolicyRequest) (bool, error) {
+	if e.root == nil {
+		return false, fmt.Errorf("empty expression")
+	}
+
+	value, err := e.root.evaluate(request)
+	if err != nil {
+		return false, fmt.Errorf(`expression "%s": %w`, e.source, err)
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf(`expression "%s" is not a condition`, e.source)
+	}
+
+	return b, nil
+}
+
+// expressionIdentifiers facts of the model.PolicyRequest that can be used in the expressions
+var expressionIdentifiers = map[string]func(model.PolicyRequest) interface{}{
+	"owner":      func(r model.PolicyRequest) interface{} { return r.Owner },
+	"client":     func(r model.PolicyRequest) interface{} { return r.Client },
+	"grant":      func(r model.PolicyRequest) interface{} { return r.Grant },
+	"user_agent": func(r model.PolicyRequest) interface{} { return r.UserAgent },
+	"scope":      func(r model.PolicyRequest) interface{} { return r.Scope },
+	"ip":         func(r model.PolicyRequest) interface{} { return r.IP },
+	"hour":       func(r model.PolicyRequest) interface{} { return float64(r.Time.Hour()) },
+	"weekday":    func(r model.PolicyRequest) interface{} { return strings.ToLower(r.Time.Weekday().String()) },
+}
+
+// expressionToken lexical token of an Expression
+type expressionToken struct {
+	// kind one of "identifier", "string", "number" or "symbol"
+	kind string
+	text string
+}
+
+// tokenizeExpression splits the source of an Expression in tokens
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	var tokens []expressionToken
+
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+
+			str, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("malformed string %s", string(runes[i:j+1]))
+			}
+
+			tokens = append(tokens, expressionToken{kind: "string", text: str})
+			i = j + 1
+
+		case unicode.IsDigit(r):
+			j := i
+			for ; j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.'); j++ {
+			}
+
+			tokens = append(tokens, expressionToken{kind: "number", text: string(runes[i:j])})
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for ; j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_'); j++ {
+			}
+
+			tokens = append(tokens, expressionToken{kind: "identifier", text: string(runes[i:j])})
+			i = j
+
+		case strings.ContainsRune("=!<>", r):
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, expressionToken{kind: "symbol", text: string(runes[i : i+2])})
+				i += 2
+				continue
+			}
+
+			if r == '=' || r == '!' {
+				return nil, fmt.Errorf(`unexpected "%c"`, r)
+			}
+
+			tokens = append(tokens, expressionToken{kind: "symbol", text: string(r)})
+			i++
+
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, expressionToken{kind: "symbol", text: string(r)})
+			i++
+
+		default:
+			return nil, fmt.Errorf(`unexpected "%c"`, r)
+		}
+	}
+
+	return tokens, nil
+}
+
+// expressionParser recursive descent parser of the expressions
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | comparison
+//	comparison = operand [ operator operand ]
+//	operand    = identifier | string | number | "true" | "false" | list | "(" or ")"
+//	list       = "[" [ operand { "," operand } ] "]"
+type expressionParser struct {
+	tokens   []expressionToken
+	position int
+	scopes   ScopeParser
+}
+
+// peek returns the current token without consuming it, the kind is empty at the end of the expression
+func (p *expressionParser) peek() expressionToken {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+
+	return expressionToken{}
+}
+
+// accept consumes the current token if it is an identifier or a symbol with the text
+func (p *expressionParser) accept(text string) bool {
+	if t := p.peek(); t.text == text && (t.kind == "identifier" || t.kind == "symbol") {
+		p.position++
+		return true
+	}
+
+	return false
+}
+
+func (p *expressionParser) parseOr() (expressionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalNode{or: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expressionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+	if p.accept("not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{operand: operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (expressionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := p.peek().text
+
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=", "in", "matches", "contains", "has":
+		p.position++
+
+	case "not":
+		// "not in" is the only operator that starts with "not"
+		if p.position+1 >= len(p.tokens) || p.tokens[p.position+1].text != "in" {
+			return left, nil
+		}
+
+		p.position += 2
+		operator = "not in"
+
+	default:
+		return left, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	node := comparisonNode{operator: operator, left: left, right: right}
+
+	// The scopes are parsed once, so the malformed scopes are reported when the policy is loaded
+	if operator == "has" {
+		literal, ok := right.(literalNode)
+		if !ok {
+			return nil, fmt.Errorf(`the operator "has" requires a scope string`)
+		}
+
+		scope, ok := literal.value.(string)
+		if !ok {
+			return nil, fmt.Errorf(`the operator "has" requires a scope string`)
+		}
+
+		i, err := p.scopes.ParseScope(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if node.mask, err = newMask(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func (p *expressionParser) parseOperand() (expressionNode, error) {
+	t := p.peek()
+	if t.kind == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	p.position++
+
+	switch t.kind {
+	case "string":
+		return literalNode{value: t.text}, nil
+
+	case "number":
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`malformed number "%s"`, t.text)
+		}
+
+		return literalNode{value: number}, nil
+
+	case "identifier":
+		switch t.text {
+		case "true", "false":
+			return literalNode{value: t.text == "true"}, nil
+		}
+
+		fact, ok := expressionIdentifiers[t.text]
+		if !ok {
+			return nil, fmt.Errorf(`unknown identifier "%s"`, t.text)
+		}
+
+		return identifierNode{name: t.text, fact: fact}, nil
+	}
+
+	switch t.text {
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.accept(")") {
+			return nil, fmt.Errorf(`missing ")"`)
+		}
+
+		return node, nil
+
+	case "[":
+		list := listNode{}
+
+		if p.accept("]") {
+			return list, nil
+		}
+
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+
+			list.items = append(list.items, item)
+
+			if p.accept("]") {
+				return list, nil
+			}
+
+			if !p.accept(",") {
+				return nil, fmt.Errorf(`missing "]"`)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf(`unexpected "%s"`, t.text)
+}
+
+// expressionNode node of the syntax tree of an Expression
+type expressionNode interface {
+	evaluate(model.PolicyRequest) (interface{}, error)
+}
+
+// literalNode string, number or boolean
+type literalNode struct {
+	value interface{}
+}
+
+func (l literalNode) evaluate(model.PolicyRequest) (interface{}, error) {
+	return l.value, nil
+}
+
+// identifierNode fact of the request
+type identifierNode struct {
+	name string
+	fact func(model.PolicyRequest) interface{}
+}
+
+func (i identifierNode) evaluate(request model.PolicyRequest) (interface{}, error) {
+	return i.fact(request), nil
+}
+
+// listNode list of operands
+type listNode struct {
+	items []expressionNode
+}
+
+func (l listNode) evaluate(request model.PolicyRequest) (interface{}, error) {
+	values := make([]interface{}, 0, len(l.items))
+
+	for _, item := range l.items {
+		value, err := item.evaluate(request)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// notNode negation of a condition
+type notNode struct {
+	operand expressionNode
+}
+
+func (n notNode) evaluate(request model.PolicyRequest) (interface{}, error) {
+	b, err := evaluateCondition(n.operand, request)
+	return !b, err
+}
+
+// logicalNode "and" or "or" of two conditions, the right condition is evaluated only if it is required
+type logicalNode struct {
+	or          bool
+	left, right expressionNode
+}
+
+func (l logicalNode) evaluate(request model.PolicyRequest) (interface{}, error) {
+	b, err := evaluateCondition(l.left, request)
+	if err != nil || b == l.or {
+		return b, err
+	}
+
+	return evaluateCondition(l.right, request)
+}
+
+// comparisonNode comparison of two operands
+type comparisonNode struct {
+	operator    string
+	left, right expressionNode
+	// mask parsed scope of the "has" operator
+	mask model.Mask
+}
+
+func (c comparisonNode) evaluate(request model.PolicyRequest) (interface{}, error) {
+	left, err := c.left.evaluate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := c.right.evaluate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.operator {
+	case "==":
+		return equalValues(left, right), nil
+
+	case "!=":
+		return !equalValues(left, right), nil
+
+	case "<", "<=", ">", ">=":
+		l, lok := left.(float64)
+		r, rok := right.(float64)
+		if !lok || !rok {
+			return nil, fmt.Errorf(`the operator "%s" requires numbers`, c.operator)
+		}
+
+		switch c.operator {
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		}
+
+		return l >= r, nil
+
+	case "in", "not in":
+		found, err := containsValue(right, left)
+		if err != nil {
+			return nil, err
+		}
+
+		return found == (c.operator == "in"), nil
+
+	case "matches":
+		str, pattern, err := stringOperands(c.operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		matched, err := path.Match(pattern, str)
+		if err != nil {
+			return nil, fmt.Errorf(`malformed pattern "%s"`, pattern)
+		}
+
+		return matched, nil
+
+	case "contains":
+		str, substr, err := stringOperands(c.operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		return strings.Contains(str, substr), nil
+
+	case "has":
+		mask, ok := left.(model.Mask)
+		if !ok {
+			return nil, fmt.Errorf(`the operator "has" requires the scope`)
+		}
+
+		return len(c.mask) > 0 && isSubset(c.mask, mask), nil
+	}
+
+	return nil, fmt.Errorf(`unknown operator "%s"`, c.operator)
+}
+
+// evaluateCondition evaluates a node that must return a boolean
+func evaluateCondition(node expressionNode, request model.PolicyRequest) (bool, error) {
+	value, err := node.evaluate(request)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not a condition", value)
+	}
+
+	return b, nil
+}
+
+// equalValues compares two values, the ip addresses are compared as strings
+func equalValues(left, right interface{}) bool {
+	if ip, ok := left.(model.IP); ok {
+		left = ip.String()
+	}
+
+	if ip, ok := right.(model.IP); ok {
+		right = ip.String()
+	}
+
+	switch left.(type) {
+	case string, float64, bool:
+		return left == right
+	}
+
+	return false
+}
+
+// containsValue indicates if the list contains the value, the ip addresses are searched in the CIDR blocks
+// of the list (e.g. ip in ["10.0.0.0/8"])
+func containsValue(list, value interface{}) (bool, error) {
+	items, ok := list.([]interface{})
+	if !ok {
+		return false, fmt.Errorf(`the operator "in" requires a list`)
+	}
+
+	if ip, ok := value.(model.IP); ok {
+		blocks := make([]string, 0, len(items))
+
+		for _, item := range items {
+			block, ok := item.(string)
+			if !ok {
+				return false, fmt.Errorf("%v is not a CIDR block", item)
+			}
+
+			blocks = append(blocks, block)
+		}
+
+		networks, err := model.ParseNetworks(blocks...)
+		if err != nil {
+			return false, err
+		}
+
+		return networks.Contains(ip), nil
+	}
+
+	for _, item := range items {
+		if equalValues(value, item) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// stringOperands validates that both operands are strings
+func stringOperands(operator string, left, right interface{}) (string, string, error) {
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if !lok || !rok {
+		return "", "", fmt.Errorf(`the operator "%s" requires strings`, operator)
+	}
+
+	return l, r, nil
+}
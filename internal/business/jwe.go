@@ -0,0 +1,231 @@
+package business
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Key management algorithms supported to encrypt the tokens (RFC 7518 section 4)
+const (
+	// RSAOAEP256 the content encryption key is encrypted with RSAES OAEP using SHA-256
+	RSAOAEP256 = "RSA-OAEP-256"
+	// ECDHES the content encryption key is agreed using Elliptic Curve Diffie-Hellman Ephemeral Static
+	ECDHES = "ECDH-ES"
+)
+
+// ContentEncryption content encryption algorithm of the encrypted tokens (RFC 7518 section 5.3)
+const ContentEncryption = "A256GCM"
+
+// contentKeySize size in bytes of the A256GCM keys
+const contentKeySize = 32
+
+// TokenEncrypter encrypts the signed tokens
+type TokenEncrypter interface {
+	// Encrypt returns the token encrypted as nested JWT
+	Encrypt(string) (string, error)
+}
+
+// _ "implement" constraint for JWEEncrypter
+var _ TokenEncrypter = JWEEncrypter{}
+
+// JWEEncrypter encrypts the signed tokens as nested JWTs (JWE compact serialization, RFC 7516) using
+// the public key of the recipient, only the holder of the private key can read the claims
+type JWEEncrypter struct {
+	// Algorithm key management algorithm (RSAOAEP256 or ECDHES)
+	Algorithm string
+	// Key public key of the recipient
+	Key       model.JWK
+	publicKey interface{}
+}
+
+// NewJWEEncrypter builds a JWEEncrypter for the public key, if the algorithm is empty the "alg" of the key is used
+// or it is selected by the type of the key: RSAOAEP256 for RSA keys and ECDHES for EC keys
+func NewJWEEncrypter(key model.JWK, algorithm string) (JWEEncrypter, error) {
+	if algorithm == "" {
+		algorithm = key.Algorithm
+	}
+
+	if algorithm == "" {
+		switch key.KeyType {
+		case "RSA":
+			algorithm = RSAOAEP256
+		case "EC":
+			algorithm = ECDHES
+		}
+	}
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return JWEEncrypter{}, err
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm != RSAOAEP256 {
+			return JWEEncrypter{}, fmt.Errorf(`the encryption algorithm "%s" cannot be used with RSA keys`, algorithm)
+		}
+
+	case *ecdsa.PublicKey:
+		if algorithm != ECDHES {
+			return JWEEncrypter{}, fmt.Errorf(`the encryption algorithm "%s" cannot be used with EC keys`, algorithm)
+		}
+
+	default:
+		return JWEEncrypter{}, fmt.Errorf(`unsupported encryption key of type "%s"`, key.KeyType)
+	}
+
+	return JWEEncrypter{Algorithm: algorithm, Key: key, publicKey: publicKey}, nil
+}
+
+// Encrypt encrypts the signed token with A256GCM, the "cty" header indicates that the content is a JWT
+func (e JWEEncrypter) Encrypt(token string) (string, error) {
+	header := model.Map{
+		"alg": e.Algorithm,
+		"enc": ContentEncryption,
+		"cty": "JWT",
+	}
+
+	if e.Key.KeyId != "" {
+		header["kid"] = e.Key.KeyId
+	}
+
+	var cek, encryptedKey []byte
+
+	switch publicKey := e.publicKey.(type) {
+	case *rsa.PublicKey:
+		cek = make([]byte, contentKeySize)
+		if _, err := rand.Read(cek); err != nil {
+			return "", err
+		}
+
+		var err error
+
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, cek, nil)
+		if err != nil {
+			return "", err
+		}
+
+	case *ecdsa.PublicKey:
+		ephemeral, err := ecdsa.GenerateKey(publicKey.Curve, rand.Reader)
+		if err != nil {
+			return "", err
+		}
+
+		epk, err := model.NewJWK(&ephemeral.PublicKey)
+		if err != nil {
+			return "", err
+		}
+
+		// Direct key agreement: the agreed key is the content encryption key and the encrypted key is empty
+		header["epk"] = epk
+		cek = agreeKey(ephemeral, publicKey)
+
+	default:
+		return "", fmt.Errorf(`missing encryption key of "%s"`, e.Key.KeyId)
+	}
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(b)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	// The protected header is the additional authenticated data (RFC 7516 section 5.1)
+	sealed := gcm.Seal(nil, iv, []byte(token), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// encryptionKey search in the key set a key that can be used with the key management algorithm
+func encryptionKey(set model.JWKSet, algorithm string) (model.JWK, bool) {
+	keyType := map[string]string{RSAOAEP256: "RSA", ECDHES: "EC"}[algorithm]
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "enc" {
+			continue
+		}
+
+		if key.Algorithm != "" && key.Algorithm != algorithm {
+			continue
+		}
+
+		if key.KeyType == keyType {
+			return key, true
+		}
+	}
+
+	return model.JWK{}, false
+}
+
+// agreeKey derives the content encryption key from the shared secret of the ECDH-ES key agreement using
+// the Concat KDF without party information (RFC 7518 section 4.6.2)
+func agreeKey(private *ecdsa.PrivateKey, public *ecdsa.PublicKey) []byte {
+	return concatKDF(sharedSecret(private, public), ContentEncryption, nil, nil, contentKeySize*8)
+}
+
+// sharedSecret returns the x coordinate of the ECDH shared point padded to the size of the curve
+func sharedSecret(private *ecdsa.PrivateKey, public *ecdsa.PublicKey) []byte {
+	size := (private.Curve.Params().BitSize + 7) / 8
+
+	x, _ := private.Curve.ScalarMult(public.X, public.Y, private.D.Bytes())
+	return x.FillBytes(make([]byte, size))
+}
+
+// concatKDF derives a key of keyBits bits from the shared secret using the Concat KDF with SHA-256
+// (NIST SP 800-56A section 5.8.1), each round hashes round || Z || OtherInfo, where OtherInfo is
+// AlgorithmID || PartyUInfo || PartyVInfo || SuppPubInfo
+func concatKDF(z []byte, algorithm string, partyU, partyV []byte, keyBits int) []byte {
+	var key []byte
+
+	for round := uint32(1); len(key)*8 < keyBits; round++ {
+		hash := sha256.New()
+
+		_ = binary.Write(hash, binary.BigEndian, round)
+		hash.Write(z)
+
+		for _, info := range [][]byte{[]byte(algorithm), partyU, partyV} {
+			_ = binary.Write(hash, binary.BigEndian, uint32(len(info)))
+			hash.Write(info)
+		}
+
+		_ = binary.Write(hash, binary.BigEndian, uint32(keyBits))
+
+		key = hash.Sum(key)
+	}
+
+	return key[:keyBits/8]
+}
@@ -0,0 +1,290 @@
+package business
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// decryptToken decrypts a nested JWT using the private key of the recipient (RFC 7516 section 5.2)
+func decryptToken(token string, privateKey interface{}) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", errors.New("malformed JWE")
+	}
+
+	segments := make([][]byte, len(parts))
+
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", err
+		}
+
+		segments[i] = b
+	}
+
+	header := struct {
+		Algorithm string    `json:"alg"`
+		Encoding  string    `json:"enc"`
+		Content   string    `json:"cty"`
+		EPK       model.JWK `json:"epk"`
+	}{}
+
+	if err := json.Unmarshal(segments[0], &header); err != nil {
+		return "", err
+	}
+
+	if header.Encoding != ContentEncryption || header.Content != "JWT" {
+		return "", errors.New("unexpected header")
+	}
+
+	var cek []byte
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		var err error
+
+		cek, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, segments[1], nil)
+		if err != nil {
+			return "", err
+		}
+
+	case *ecdsa.PrivateKey:
+		epk, err := header.EPK.PublicKey()
+		if err != nil {
+			return "", err
+		}
+
+		// The key derivation is verified with the known-answer vector in TestConcatKDF
+		cek = concatKDF(sharedSecret(key, epk.(*ecdsa.PublicKey)), header.Encoding, nil, nil, contentKeySize*8)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := gcm.Open(nil, segments[2], append(segments[3], segments[4]...), []byte(parts[0]))
+	return string(plaintext), err
+}
+
+// TestConcatKDF checks the ECDH-ES key agreement with the example of RFC 7518 appendix C
+func TestConcatKDF(t *testing.T) {
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return new(big.Int).SetBytes(b)
+	}
+
+	// ecKey decodes an EC private key of the P-256 curve from its JWK parameters
+	ecKey := func(x, y, d string) *ecdsa.PrivateKey {
+		return &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(x), Y: decode(y)},
+			D:         decode(d),
+		}
+	}
+
+	alice := ecKey(
+		"gI0GAILBdu7T53akrFmMyGcsF3n5dO7MmwNBHKW5SV0",
+		"SLW_xSffzlPWrHEVI30DHM_4egVwt3NQqeUD7nMFpps",
+		"0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo",
+	)
+
+	bob := ecKey(
+		"weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ",
+		"e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck",
+		"VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw",
+	)
+
+	expectedZ := []byte{
+		158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196,
+	}
+
+	z := sharedSecret(alice, &bob.PublicKey)
+	if !bytes.Equal(z, expectedZ) {
+		t.Fatalf(`expected shared secret "%v" got "%v"`, expectedZ, z)
+	}
+
+	if other := sharedSecret(bob, &alice.PublicKey); !bytes.Equal(other, expectedZ) {
+		t.Fatalf(`expected shared secret "%v" got "%v"`, expectedZ, other)
+	}
+
+	const expectedKey = "VqqN6vgjbSBcIijNcacQGg"
+
+	key := concatKDF(z, "A128GCM", []byte("Alice"), []byte("Bob"), 128)
+	if encoded := base64.RawURLEncoding.EncodeToString(key); encoded != expectedKey {
+		t.Fatalf(`expected key "%s" got "%s"`, expectedKey, encoded)
+	}
+}
+
+// TestJWEEncrypter_Encrypt checks that the signed tokens are encrypted with the public key of the resource
+// and that the ID tokens are encrypted for the clients that registered id_token_encrypted_response_alg
+func TestJWEEncrypter_Encrypt(t *testing.T) {
+	signer := JWTGenerator{}
+
+	err := signer.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaJWK, _ := model.NewJWK(&rsaKey.PublicKey)
+	ecJWK, _ := model.NewJWK(&ecKey.PublicKey)
+
+	rsaJWK.KeyId, ecJWK.KeyId = "rsa-1", "ec-1"
+
+	grant := AuthorizationCodeGrant{
+		Clients: repository.MockClientFinder{
+			"rsa": {IDTokenEncryptedResponseAlg: RSAOAEP256, Keys: model.JWKSet{Keys: []model.JWK{ecJWK, rsaJWK}}},
+			"ec":  {IDTokenEncryptedResponseAlg: ECDHES, Keys: model.JWKSet{Keys: []model.JWK{rsaJWK, ecJWK}}},
+			// The client registered the algorithm without a key
+			"keyless": {IDTokenEncryptedResponseAlg: ECDHES},
+			"plain":   {},
+		},
+	}
+
+	tdt := []struct {
+		key         model.JWK
+		algorithm   string
+		client      string
+		privateKey  interface{}
+		expectedErr bool
+	}{
+		{
+			key:        rsaJWK,
+			privateKey: rsaKey,
+		},
+		{
+			key:        ecJWK,
+			algorithm:  ECDHES,
+			privateKey: ecKey,
+		},
+		// The algorithm does not match the key type
+		{
+			key:         rsaJWK,
+			algorithm:   ECDHES,
+			expectedErr: true,
+		},
+		{
+			client:     "rsa",
+			privateKey: rsaKey,
+		},
+		{
+			client:     "ec",
+			privateKey: ecKey,
+		},
+		{
+			client:      "keyless",
+			expectedErr: true,
+		},
+		// The ID tokens of the client are only signed
+		{
+			client: "plain",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			generator := signer
+
+			if v.client == "" {
+				encrypter, err := NewJWEEncrypter(v.key, v.algorithm)
+				if (err != nil) != v.expectedErr {
+					t.Fatalf(`unexpected error "%v"`, err)
+				}
+
+				if err != nil {
+					t.Skip(err)
+				}
+
+				generator.Encrypter = encrypter
+			}
+
+			tkn, err := generator.GenerateToken(model.JWT{
+				Claims: model.Map{"account_number": "0123456789"},
+				StandardClaims: model.StandardClaims{
+					Subject:  "Go",
+					Audience: "https://ledger.example.com",
+					IssuedAt: time.Now().Unix(),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := tkn.AccessToken
+
+			if v.client != "" {
+				token, err = grant.encryptIdToken(v.client, token)
+				if (err != nil) != v.expectedErr {
+					t.Fatalf(`unexpected error "%v"`, err)
+				}
+
+				if err != nil {
+					t.Skip(err)
+				}
+			}
+
+			if v.privateKey == nil {
+				if token != tkn.AccessToken {
+					t.Fatal("the token must not be encrypted")
+				}
+
+				return
+			}
+
+			if len(strings.Split(token, ".")) != 5 {
+				t.Fatalf(`the token "%s" is not encrypted`, token)
+			}
+
+			signed, err := decryptToken(token, v.privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := signer.ParseToken(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Claims["account_number"] != "0123456789" {
+				t.Fatalf(`unexpected claims "%v"`, claims.Claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package business
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Notifier reaches the device of the owner to ask for the approval of a CIBA authentication request
+type Notifier interface {
+	// Notify sends the authentication request to the device of the owner identified by the login hint
+	Notify(model.BackchannelAuthorization) error
+}
+
+// _ "implement" constraint for LogNotifier and MemoryNotifier
+var (
+	_ Notifier = LogNotifier{}
+	_ Notifier = (*MemoryNotifier)(nil)
+)
+
+// LogNotifier writes the authentication requests in a log instead of reaching the device of the owner,
+// useful in development environments
+type LogNotifier struct {
+	// Logger destination of the notifications (log.Default() by default)
+	Logger *log.Logger
+}
+
+// Notify writes the authentication request in the log
+func (l LogNotifier) Notify(request model.BackchannelAuthorization) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf(`backchannel authentication requested by "%s" for "%s" (auth_req_id: %s, binding_message: "%s")`,
+		request.Application.Id, request.LoginHint, request.AuthReqId, request.BindingMessage)
+
+	return nil
+}
+
+// MemoryNotifier keeps the authentication requests in memory, useful in tests
+type MemoryNotifier struct {
+	mutex    sync.Mutex
+	requests []model.BackchannelAuthorization
+}
+
+// Notify saves the authentication request
+func (m *MemoryNotifier) Notify(request model.BackchannelAuthorization) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests = append(m.requests, request)
+	return nil
+}
+
+// Requests returns the authentication requests received
+func (m *MemoryNotifier) Requests() []model.BackchannelAuthorization {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]model.BackchannelAuthorization(nil), m.requests...)
+}
+
+// ClientNotifier sends the notifications of the ping and push modes to the clients of the CIBA flow
+type ClientNotifier interface {
+	// NotifyClient sends the payload to the client endpoint using the client notification token as bearer token
+	NotifyClient(endpoint, token string, payload model.Map) error
+}
+
+const (
+	// DefaultNotificationTimeout time limit of each notification sent to the clients
+	DefaultNotificationTimeout = 10 * time.Second
+	// DefaultNotificationBackoff time waited before sending again a failed notification
+	DefaultNotificationBackoff = time.Second
+)
+
+// _ "implement" constraint for HTTPClientNotifier
+var _ ClientNotifier = HTTPClientNotifier{}
+
+// HTTPClientNotifier sends the notifications to the clients via HTTP POST requests
+type HTTPClientNotifier struct {
+	// Client used to send the requests (client with DefaultNotificationTimeout by default)
+	Client *http.Client
+	// Retries number of times that a notification is sent again if the client endpoint is not available,
+	// the notifications rejected by the client (4xx status) are not sent again
+	Retries int
+	// Backoff time waited before the first retry, it is doubled in each retry (DefaultNotificationBackoff by default)
+	Backoff time.Duration
+}
+
+// NotifyClient sends the payload serialized as json to the client endpoint
+func (h HTTPClientNotifier) NotifyClient(endpoint, token string, payload model.Map) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := h.Backoff
+	if backoff <= 0 {
+		backoff = DefaultNotificationBackoff
+	}
+
+	for retry := 0; ; retry++ {
+		var temporary bool
+
+		temporary, err = h.notify(endpoint, token, body)
+		if err == nil || !temporary || retry >= h.Retries {
+			return err
+		}
+
+		time.Sleep(backoff << retry)
+	}
+}
+
+// notify sends a single notification, the errors are temporary if the client endpoint could not be reached
+// or it was not available
+func (h HTTPClientNotifier) notify(endpoint, token string, body []byte) (temporary bool, err error) {
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultNotificationTimeout}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		temporary = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return temporary, fmt.Errorf(`unexpected status "%d" notifying the client endpoint "%s"`, res.StatusCode, endpoint)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,76 @@
+package business
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestHTTPClientNotifier_NotifyClient checks that the notifications are sent again only if the client endpoint
+// is not available
+func TestHTTPClientNotifier_NotifyClient(t *testing.T) {
+	tdt := []struct {
+		// statuses responses of the client endpoint in order, the last one is repeated
+		statuses         []int
+		retries          int
+		expectedRequests int32
+		expectedErr      bool
+	}{
+		{
+			statuses:         []int{http.StatusNoContent},
+			expectedRequests: 1,
+		},
+		// The client endpoint is available after two retries
+		{
+			statuses:         []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusNoContent},
+			retries:          2,
+			expectedRequests: 3,
+		},
+		// The retries are exhausted
+		{
+			statuses:         []int{http.StatusServiceUnavailable},
+			retries:          2,
+			expectedRequests: 3,
+			expectedErr:      true,
+		},
+		// The notification is rejected by the client
+		{
+			statuses:         []int{http.StatusUnauthorized},
+			retries:          2,
+			expectedRequests: 1,
+			expectedErr:      true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			var requests int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&requests, 1))
+				if n > len(v.statuses) {
+					n = len(v.statuses)
+				}
+
+				w.WriteHeader(v.statuses[n-1])
+			}))
+			defer server.Close()
+
+			notifier := HTTPClientNotifier{Client: server.Client(), Retries: v.retries, Backoff: time.Millisecond}
+
+			err := notifier.NotifyClient(server.URL, "notification-token", model.Map{"auth_req_id": "1"})
+			if (err != nil) != v.expectedErr {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if requests != v.expectedRequests {
+				t.Fatalf("expected %d requests got %d", v.expectedRequests, requests)
+			}
+		})
+	}
+}
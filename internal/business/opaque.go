@@ -0,0 +1,324 @@
+package business
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// DefaultOpaqueLifeTime life time of the opaque tokens whose claims do not define the expiration
+const DefaultOpaqueLifeTime = time.Hour
+
+// opaqueTokenLength number of random bytes of the opaque tokens
+const opaqueTokenLength = 32
+
+// _ "implement" constraint for OpaqueGenerator
+var _ TokenGenerator = OpaqueGenerator{}
+
+// OpaqueGenerator generates random opaque tokens, the claims of each token are saved in the SessionStorage
+// indexed by the hash of the token, so the tokens can only be read using the introspection
+type OpaqueGenerator struct {
+	// SessionStorage store for the claims of the tokens
+	SessionStorage repository.Storage
+	// LifeTime of the tokens whose claims do not define the expiration (DefaultOpaqueLifeTime by default)
+	LifeTime time.Duration
+}
+
+// GenerateToken generates an opaque token that references the model.JWT received as parameter
+func (o OpaqueGenerator) GenerateToken(i interface{}) (model.Token, error) {
+	claims := i.(model.JWT)
+
+	if claims.ExpiresAt == 0 {
+		lifeTime := o.LifeTime
+		if lifeTime <= 0 {
+			lifeTime = DefaultOpaqueLifeTime
+		}
+
+		issuedAt := time.Now()
+		if claims.IssuedAt != 0 {
+			issuedAt = time.Unix(claims.IssuedAt, 0)
+		}
+
+		claims.ExpiresAt = issuedAt.Add(lifeTime).Unix()
+	}
+
+	b := make([]byte, opaqueTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return model.Token{}, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := saveClaims(o.SessionStorage, token, claims)
+	if err != nil {
+		return model.Token{}, err
+	}
+
+	return model.Token{
+		Type:        "Bearer",
+		AccessToken: token,
+		Scope:       claims.Scope,
+		ExpiresIn:   int64(time.Until(time.Unix(claims.ExpiresAt, 0)) / time.Second),
+	}, nil
+}
+
+// opaqueSessionId returns the id of the session that contains the claims of the opaque or encrypted token,
+// the token itself is never saved
+func opaqueSessionId(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "opaque:" + hex.EncodeToString(hash[:])
+}
+
+// saveClaims saves the claims of the token in the session indexed by the hash of the token
+func saveClaims(storage repository.Storage, token string, claims model.JWT) error {
+	session := model.Session{
+		Owner:   model.Owner{Id: claims.Subject},
+		TokenId: claims.Id,
+		Claims:  &claims,
+	}
+
+	if claims.ExpiresAt != 0 {
+		session.Expiration = time.Until(time.Unix(claims.ExpiresAt, 0))
+	}
+
+	return storage.Create(opaqueSessionId(token), session)
+}
+
+// isEncrypted indicates if the token is a JWE in compact serialization, it contains five segments separated by dots
+func isEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// tokenKeyId returns the "kid" header of the JWT without verifying the token
+func tokenKeyId(token string) string {
+	b, err := base64.RawURLEncoding.DecodeString(token[:strings.Index(token, ".")])
+	if err != nil {
+		return ""
+	}
+
+	header := struct {
+		KeyId string `json:"kid"`
+	}{}
+
+	_ = json.Unmarshal(b, &header)
+	return header.KeyId
+}
+
+// TokenIntrospector returns the state of the tokens issued by the authorization server (RFC 7662)
+type TokenIntrospector interface {
+	// Introspect returns the introspection response of the token to the authenticated client
+	Introspect(model.Application, string) (model.Map, error)
+}
+
+// TokenResolver resolves the tokens issued by the authorization server in any of their formats
+type TokenResolver interface {
+	// ResolveToken returns the claims of an active token and the id of its owner, the claims are nil
+	// if the token is not active
+	ResolveToken(string) (*model.JWT, string, error)
+}
+
+// _ "implement" constraint for Introspector
+var (
+	_ TokenIntrospector = Introspector{}
+	_ TokenResolver     = Introspector{}
+)
+
+// Introspector resolves the JWTs, the PASETO tokens, the encrypted tokens and the opaque tokens, a token is active
+// while its session exists and it has not expired, the tokens without "jti" are never active
+//
+// The encrypted tokens can only be decrypted by their recipients, so they are resolved using the claims saved when
+// they were generated (see ResourceGenerator.SessionStorage)
+type Introspector struct {
+	// Clients finder of the clients, only the clients with secret can introspect tokens
+	Clients repository.Finder
+	// TokenParser parses the JWTs signed with the default key of the authorization server
+	TokenParser
+	// Keys parsers of the JWTs signed with the keys of the resources indexed by key id, the JWTs whose "kid"
+	// is not in Keys are parsed by the TokenParser (Optional)
+	Keys map[string]TokenParser
+	// Paseto parses the PASETO tokens issued by the authorization server (Optional)
+	Paseto TokenParser
+	// SessionStorage store for the sessions of the tokens and the claims of the opaque tokens
+	SessionStorage repository.Storage
+}
+
+// Introspect authenticates the client and returns the claims of the token, the invalid, expired or revoked
+// tokens are reported as {"active": false}
+//
+// The pairwise subject identifiers are resolved to the owner id saved in the session of the token, except for
+// the pairwise clients, so they cannot correlate the owners of other sectors
+func (in Introspector) Introspect(application model.Application, token string) (model.Map, error) {
+	client, err := authenticateSecret(in.Clients, application)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("%w: missing token", model.InvalidRequest)
+	}
+
+	claims, owner, err := in.ResolveToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims == nil {
+		return model.Map{"active": false}, nil
+	}
+
+	if owner != "" && client.SubjectType != model.PairwiseSubject {
+		claims.Subject = owner
+	}
+
+	return introspectionResponse(*claims)
+}
+
+// ResolveToken returns the claims of an active token and the id of its owner (if the session saves it), the claims
+// are nil if the token is not active
+func (in Introspector) ResolveToken(token string) (*model.JWT, string, error) {
+	var claims model.JWT
+
+	// The PASETO tokens start with their header, the JWTs contain three segments separated by dots, the encrypted
+	// tokens contain five segments and the opaque tokens do not contain dots
+	switch {
+	case strings.HasPrefix(token, PasetoV4Public):
+		if in.Paseto == nil {
+			return nil, "", nil
+		}
+
+		parsed, err := in.Paseto.ParseToken(token)
+		if err != nil {
+			return nil, "", nil
+		}
+
+		claims = parsed
+
+	case strings.Count(token, ".") == 2:
+		parser := in.parser(token)
+		if parser == nil {
+			return nil, "", nil
+		}
+
+		parsed, err := parser.ParseToken(token)
+		if err != nil {
+			return nil, "", nil
+		}
+
+		claims = parsed
+
+	default:
+		saved, err := in.savedClaims(token)
+		if saved == nil || err != nil {
+			return nil, "", err
+		}
+
+		claims = *saved
+	}
+
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, "", nil
+	}
+
+	// Only the access tokens have "jti", so the ID tokens and the JARM responses signed with the same key are not active
+	if claims.Id == "" {
+		return nil, "", nil
+	}
+
+	// The revoked tokens do not have session
+	i, err := in.SessionStorage.Obtain(claims.Id)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return nil, "", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, _ := i.(model.Session)
+	return &claims, session.Owner.Id, nil
+}
+
+// parser returns the parser of the key identified by the "kid" header of the JWT
+func (in Introspector) parser(token string) TokenParser {
+	if parser, ok := in.Keys[tokenKeyId(token)]; ok {
+		return parser
+	}
+
+	return in.TokenParser
+}
+
+// savedClaims returns the claims saved for the opaque or encrypted token, nil is returned if the token is unknown
+func (in Introspector) savedClaims(token string) (*model.JWT, error) {
+	i, err := in.SessionStorage.Obtain(opaqueSessionId(token))
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	session, _ := i.(model.Session)
+	return session.Claims, nil
+}
+
+// introspectionResponse builds the introspection response of an active token (RFC 7662 section 2.2)
+func introspectionResponse(claims model.JWT) (model.Map, error) {
+	response := model.Map{}
+
+	for k, v := range claims.Claims {
+		response[k] = v
+	}
+
+	response["active"] = true
+	response["token_type"] = "Bearer"
+
+	if claims.Scope != nil {
+		mask, err := newMask(claims.Scope)
+		if err != nil {
+			return nil, err
+		}
+
+		response["scope"] = formatMask(mask)
+	}
+
+	optional := model.Map{
+		"iss": claims.Issuer,
+		"sub": claims.Subject,
+		"aud": claims.Audience,
+		"jti": claims.Id,
+	}
+
+	for k, v := range optional {
+		if v != "" {
+			response[k] = v
+		}
+	}
+
+	numeric := map[string]int64{"exp": claims.ExpiresAt, "iat": claims.IssuedAt, "nbf": claims.NotBefore}
+
+	for k, v := range numeric {
+		if v != 0 {
+			response[k] = v
+		}
+	}
+
+	if claims.Actor != nil {
+		response["act"] = claims.Actor
+	}
+
+	if len(claims.AuthorizationDetails) > 0 {
+		response["authorization_details"] = claims.AuthorizationDetails
+	}
+
+	return response, nil
+}
@@ -0,0 +1,390 @@
+package business
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestIntrospector_Introspect checks that the opaque tokens and the JWTs are resolved while their sessions exist
+// and that only the confidential clients can introspect tokens
+func TestIntrospector_Introspect(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	opaque := OpaqueGenerator{SessionStorage: sessions}
+
+	clients := repository.MockClientFinder{
+		"reports": {Id: "reports", Secret: "secret"},
+		"mobile":  {Id: "mobile"},
+	}
+
+	claims := func(jti string, expiresAt int64) model.JWT {
+		return model.JWT{
+			Scope:  model.Mask{"orders": 0x01},
+			Claims: model.Map{"tier": "gold"},
+			StandardClaims: model.StandardClaims{
+				Id:        jti,
+				Subject:   "alice",
+				Audience:  "https://reports.example.com",
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: expiresAt,
+			},
+		}
+	}
+
+	issue := func(generator TokenGenerator, claims model.JWT) string {
+		if err := sessions.Create(claims.Id, model.Session{TokenId: claims.Id}); err != nil {
+			t.Fatal(err)
+		}
+
+		tkn, err := generator.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tkn.AccessToken
+	}
+
+	opaqueToken := issue(opaque, claims("opaque", 0))
+	jwtToken := issue(generator, claims("jwt", time.Now().Add(time.Hour).Unix()))
+	expiredToken := issue(opaque, claims("expired", time.Now().Add(-time.Minute).Unix()))
+	revokedToken := issue(opaque, claims("revoked", 0))
+
+	_ = sessions.Delete("revoked")
+
+	// The ID tokens and the JARM responses are signed with the same key but they are not access tokens
+	idToken, err := generator.GenerateToken(claims("", time.Now().Add(time.Hour).Unix()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jarmResponse, err := generator.Sign(model.Map{
+		"iss":   "http://localhost:8080",
+		"aud":   "mobile",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"code":  "AAA",
+		"state": "BBB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspector := Introspector{
+		Clients:        clients,
+		TokenParser:    generator,
+		SessionStorage: sessions,
+	}
+
+	tdt := []struct {
+		application    model.Application
+		token          string
+		expectedActive bool
+		expectedErr    error
+	}{
+		{
+			application:    model.Application{Id: "reports", Secret: "secret"},
+			token:          opaqueToken,
+			expectedActive: true,
+		},
+		{
+			application:    model.Application{Id: "reports", Secret: "secret"},
+			token:          jwtToken,
+			expectedActive: true,
+		},
+		// Expired token
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			token:       expiredToken,
+		},
+		// Revoked token
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			token:       revokedToken,
+		},
+		// Unknown token
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			token:       "unknown",
+		},
+		// ID token
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			token:       idToken.AccessToken,
+		},
+		// JARM response
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			token:       jarmResponse,
+		},
+		// Invalid secret
+		{
+			application: model.Application{Id: "reports", Secret: "invalid"},
+			token:       opaqueToken,
+			expectedErr: model.InvalidClient,
+		},
+		// Public client
+		{
+			application: model.Application{Id: "mobile"},
+			token:       opaqueToken,
+			expectedErr: model.InvalidClient,
+		},
+		// Missing token
+		{
+			application: model.Application{Id: "reports", Secret: "secret"},
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			res, err := introspector.Introspect(v.application, v.token)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if res["active"] != v.expectedActive {
+				t.Fatalf(`expected active "%v" got "%v"`, v.expectedActive, res["active"])
+			}
+
+			if !v.expectedActive {
+				if len(res) != 1 {
+					t.Fatalf(`unexpected claims of an inactive token "%v"`, res)
+				}
+
+				return
+			}
+
+			if res["scope"] != "orders:1" || res["sub"] != "alice" || res["tier"] != "gold" {
+				t.Fatalf(`unexpected introspection response "%v"`, res)
+			}
+
+			t.Log(res)
+		})
+	}
+}
+
+// TestIntrospector_Introspect_Resources checks that the JWTs are verified with the key of the resource identified
+// by the "kid" header and that the encrypted tokens are resolved using the claims saved when they were generated
+func TestIntrospector_Introspect_Resources(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ordersKey := JWTGenerator{KeyId: "https://orders.example.com", Algorithm: "HS256"}
+
+	err = ordersKey.SetPrivateKey([]byte("01234567890123456789012345678901"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key that is not registered but uses the "kid" of the orders resource
+	forgedKey := JWTGenerator{KeyId: "https://orders.example.com", Algorithm: "HS256"}
+
+	err = forgedKey.SetPrivateKey([]byte("forged-forged-forged-forged-forg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := model.NewJWK(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ledgerKey := generator
+
+	ledgerKey.Encrypter, err = NewJWEEncrypter(jwk, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	resourceGenerator := ResourceGenerator{
+		Resources: ResourceRegistry{
+			"https://orders.example.com": {KeyId: "https://orders.example.com"},
+			"https://ledger.example.com": {KeyId: "https://ledger.example.com"},
+		},
+		Default: generator,
+		Keys: map[string]TokenGenerator{
+			"https://orders.example.com": ordersKey,
+			"https://ledger.example.com": ledgerKey,
+		},
+		SessionStorage: sessions,
+	}
+
+	introspector := Introspector{
+		Clients:        repository.MockClientFinder{"reports": {Id: "reports", Secret: "secret"}},
+		TokenParser:    generator,
+		Keys:           map[string]TokenParser{"https://orders.example.com": ordersKey},
+		SessionStorage: sessions,
+	}
+
+	issue := func(generator TokenGenerator, audience string) string {
+		claims := model.JWT{
+			Scope: model.Mask{"orders": 0x01},
+			StandardClaims: model.StandardClaims{
+				Id:        audience,
+				Subject:   "alice",
+				Audience:  audience,
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+
+		if err := sessions.Create(claims.Id, model.Session{TokenId: claims.Id}); err != nil {
+			t.Fatal(err)
+		}
+
+		tkn, err := generator.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tkn.AccessToken
+	}
+
+	encryptedToken := issue(resourceGenerator, "https://ledger.example.com")
+
+	if !isEncrypted(encryptedToken) {
+		t.Fatalf(`expected an encrypted token got "%s"`, encryptedToken)
+	}
+
+	tdt := []struct {
+		token          string
+		expectedActive bool
+	}{
+		{
+			token:          issue(resourceGenerator, "https://orders.example.com"),
+			expectedActive: true,
+		},
+		{
+			token:          encryptedToken,
+			expectedActive: true,
+		},
+		// The token is signed with other key
+		{
+			token: issue(forgedKey, "https://payments.example.com"),
+		},
+		// The encrypted token was not generated by the authorization server
+		{
+			token: "eyJhbGciOiJFQ0RILUVTIn0..aXY.Y2lwaGVydGV4dA.dGFn",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			res, err := introspector.Introspect(model.Application{Id: "reports", Secret: "secret"}, v.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res["active"] != v.expectedActive {
+				t.Fatalf(`expected active "%v" got "%v"`, v.expectedActive, res["active"])
+			}
+
+			if v.expectedActive && (res["sub"] != "alice" || res["scope"] != "orders:1") {
+				t.Fatalf(`unexpected introspection response "%v"`, res)
+			}
+		})
+	}
+}
+
+// TestResourceGenerator_GenerateToken_Opaque checks that the opaque tokens are generated for the resources
+// and the clients whose token format is opaque
+func TestResourceGenerator_GenerateToken_Opaque(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	resourceGenerator := ResourceGenerator{
+		Resources: ResourceRegistry{
+			"https://reports.example.com": {Format: model.OpaqueFormat, LifeTime: 5 * time.Minute},
+			"https://orders.example.com":  {},
+		},
+		Default: generator,
+		Opaque:  OpaqueGenerator{SessionStorage: sessions},
+		Clients: repository.MockClientFinder{
+			"backoffice": {Id: "backoffice", TokenFormat: model.OpaqueFormat},
+			"mobile":     {Id: "mobile"},
+		},
+	}
+
+	tdt := []struct {
+		audience       string
+		expectedOpaque bool
+	}{
+		{
+			audience:       "https://reports.example.com",
+			expectedOpaque: true,
+		},
+		{
+			audience: "https://orders.example.com",
+		},
+		{
+			audience:       "backoffice",
+			expectedOpaque: true,
+		},
+		{
+			audience: "mobile",
+		},
+		// The audience is neither a resource nor a client
+		{
+			audience: "unknown",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			tkn, err := resourceGenerator.GenerateToken(model.JWT{
+				StandardClaims: model.StandardClaims{
+					Subject:  "alice",
+					Audience: v.audience,
+					IssuedAt: time.Now().Unix(),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = sessions.Obtain(opaqueSessionId(tkn.AccessToken))
+			if opaque := err == nil; opaque != v.expectedOpaque {
+				t.Fatalf(`expected opaque "%v" got "%v"`, v.expectedOpaque, opaque)
+			}
+
+			if v.expectedOpaque && tkn.ExpiresIn <= 0 {
+				t.Fatalf(`unexpected expires_in "%d"`, tkn.ExpiresIn)
+			}
+		})
+	}
+}
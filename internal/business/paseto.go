@@ -0,0 +1,285 @@
+package business
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// PasetoV4Public header of the PASETO tokens signed with Ed25519 (version 4, purpose public)
+const PasetoV4Public = "v4.public."
+
+// pasetoTimeClaims claims that PASETO defines as ISO 8601 datetimes instead of numeric dates
+var pasetoTimeClaims = []string{"exp", "iat", "nbf"}
+
+// _ "implement" constraint for PasetoGenerator
+var (
+	_ TokenGenerator = PasetoGenerator{}
+	_ TokenParser    = PasetoGenerator{}
+	_ KeyPublisher   = PasetoGenerator{}
+)
+
+// PasetoGenerator generates and parses v4.public PASETO tokens that carry the same claims as model.JWT,
+// the version defines the algorithm (Ed25519) so it cannot be chosen by the token
+type PasetoGenerator struct {
+	// KeyId identifier of the private key sent as "kid" in the footer of the tokens, the JWK Thumbprint of the
+	// public key by default (see SetPrivateKey)
+	KeyId      string
+	privateKey ed25519.PrivateKey
+}
+
+// SetPrivateKey parses the Ed25519 private key in PEM format (PKCS#8), if the KeyId is empty the JWK Thumbprint
+// (RFC 7638) of the public key is used as key id so it remains stable while the key is not rotated
+func (p *PasetoGenerator) SetPrivateKey(privateKey []byte) error {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return fmt.Errorf("the v4.public tokens must be signed with an Ed25519 key, got a key of type %T", key)
+	}
+
+	p.privateKey = edKey
+
+	if p.KeyId != "" {
+		return nil
+	}
+
+	jwk, err := model.NewJWK(edKey.Public())
+	if err != nil {
+		return err
+	}
+
+	p.KeyId, err = jwk.Thumbprint()
+	return err
+}
+
+// GenerateToken generates a v4.public token based on the model.JWT received as parameter
+func (p PasetoGenerator) GenerateToken(i interface{}) (model.Token, error) {
+	claims := i.(model.JWT)
+
+	if p.privateKey == nil {
+		return model.Token{}, errors.New("missing private key of the PASETO generator")
+	}
+
+	payload, err := pasetoPayload(claims)
+	if err != nil {
+		return model.Token{}, err
+	}
+
+	var footer []byte
+
+	if p.KeyId != "" {
+		footer, err = json.Marshal(model.Map{"kid": p.KeyId})
+		if err != nil {
+			return model.Token{}, err
+		}
+	}
+
+	return model.Token{
+		Type:        "Bearer",
+		AccessToken: signPaseto(p.privateKey, payload, footer, nil),
+		Scope:       claims.Scope,
+	}, nil
+}
+
+// ParseToken verifies a v4.public token signed with the private key and returns its claims
+func (p PasetoGenerator) ParseToken(token string) (model.JWT, error) {
+	claims, err := p.parse(token)
+	if err != nil {
+		return model.JWT{}, fmt.Errorf("%w: %s", model.InvalidToken, err.Error())
+	}
+
+	return claims, nil
+}
+
+// parse verifies the signature and the validity of the token
+func (p PasetoGenerator) parse(token string) (claims model.JWT, err error) {
+	if p.privateKey == nil {
+		err = errors.New("missing private key of the PASETO generator")
+		return
+	}
+
+	publicKey, _ := p.privateKey.Public().(ed25519.PublicKey)
+
+	payload, _, err := verifyPaseto(publicKey, token, nil)
+	if err != nil {
+		return
+	}
+
+	claims, err = parsePasetoPayload(payload)
+	if err != nil {
+		return
+	}
+
+	err = claims.Valid()
+	return
+}
+
+// signPaseto signs the payload with the footer and the implicit assertion and returns the v4.public token
+func signPaseto(privateKey ed25519.PrivateKey, payload, footer, implicit []byte) string {
+	signature := ed25519.Sign(privateKey, pae([]byte(PasetoV4Public), payload, footer, implicit))
+
+	token := PasetoV4Public + base64.RawURLEncoding.EncodeToString(append(payload[:len(payload):len(payload)], signature...))
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+
+	return token
+}
+
+// verifyPaseto verifies the signature of the v4.public token with the implicit assertion and returns
+// its payload and footer
+func verifyPaseto(publicKey ed25519.PublicKey, token string, implicit []byte) (payload, footer []byte, err error) {
+	if !strings.HasPrefix(token, PasetoV4Public) {
+		return nil, nil, errors.New("the token is not a v4.public PASETO")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, PasetoV4Public), ".")
+	if len(parts) > 2 {
+		return nil, nil, errors.New("malformed PASETO")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(b) < ed25519.SignatureSize {
+		return nil, nil, errors.New("malformed PASETO payload")
+	}
+
+	if len(parts) == 2 {
+		footer, err = base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, nil, errors.New("malformed PASETO footer")
+		}
+	}
+
+	payload, signature := b[:len(b)-ed25519.SignatureSize], b[len(b)-ed25519.SignatureSize:]
+
+	if !ed25519.Verify(publicKey, pae([]byte(PasetoV4Public), payload, footer, implicit), signature) {
+		return nil, nil, errors.New("invalid PASETO signature")
+	}
+
+	return payload, footer, nil
+}
+
+// JWK returns the Ed25519 public key as model.JWK to be published in the key set, the "alg" is not defined
+// because "v4.public" is not a registered JWA algorithm, the PASETO version already defines the algorithm
+func (p PasetoGenerator) JWK() (model.JWK, bool) {
+	if p.privateKey == nil {
+		return model.JWK{}, false
+	}
+
+	jwk, err := model.NewJWK(p.privateKey.Public())
+	if err != nil {
+		return model.JWK{}, false
+	}
+
+	jwk.KeyId, jwk.Use = p.KeyId, "sig"
+	return jwk, true
+}
+
+// pasetoPayload serializes the claims of the model.JWT, the numeric dates are converted to ISO 8601
+func pasetoPayload(claims model.JWT) ([]byte, error) {
+	m, err := decodeClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, claim := range pasetoTimeClaims {
+		number, ok := m[claim].(json.Number)
+		if !ok {
+			continue
+		}
+
+		seconds, err := number.Int64()
+		if err != nil {
+			return nil, fmt.Errorf(`invalid claim "%s": %w`, claim, err)
+		}
+
+		m[claim] = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	}
+
+	return json.Marshal(m)
+}
+
+// parsePasetoPayload deserializes the claims of a PASETO payload as model.JWT, the ISO 8601 dates are converted
+// to numeric dates
+func parsePasetoPayload(payload []byte) (model.JWT, error) {
+	m := model.Map{}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&m); err != nil {
+		return model.JWT{}, err
+	}
+
+	for _, claim := range pasetoTimeClaims {
+		str, ok := m[claim].(string)
+		if !ok {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return model.JWT{}, fmt.Errorf(`invalid claim "%s": %w`, claim, err)
+		}
+
+		m[claim] = json.Number(strconv.FormatInt(t.Unix(), 10))
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return model.JWT{}, err
+	}
+
+	claims := model.JWT{}
+
+	err = json.Unmarshal(b, &claims)
+	return claims, err
+}
+
+// decodeClaims converts the model.JWT to model.Map keeping the numbers as json.Number
+func decodeClaims(claims model.JWT) (model.Map, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	m := model.Map{}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	err = decoder.Decode(&m)
+	return m, err
+}
+
+// pae Pre-Authentication Encoding of the pieces of the token, each length is encoded as a little-endian
+// 64-bit unsigned integer with the most significant bit cleared
+func pae(pieces ...[]byte) []byte {
+	buffer := &bytes.Buffer{}
+
+	le64 := func(n int) {
+		_ = binary.Write(buffer, binary.LittleEndian, uint64(n)&^(1<<63))
+	}
+
+	le64(len(pieces))
+
+	for _, piece := range pieces {
+		le64(len(piece))
+		buffer.Write(piece)
+	}
+
+	return buffer.Bytes()
+}
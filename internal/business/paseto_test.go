@@ -0,0 +1,263 @@
+package business
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestPAE checks the Pre-Authentication Encoding with the test vectors of the PASETO specification
+func TestPAE(t *testing.T) {
+	tdt := []struct {
+		pieces   [][]byte
+		expected []byte
+	}{
+		{
+			expected: []byte("\x00\x00\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			pieces:   [][]byte{{}},
+			expected: []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			pieces:   [][]byte{[]byte("test")},
+			expected: []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00test"),
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if got := pae(v.pieces...); !bytes.Equal(got, v.expected) {
+				t.Fatalf(`expected "%q" got "%q"`, v.expected, got)
+			}
+		})
+	}
+}
+
+// TestSignPaseto checks the signature and the verification of the v4.public tokens with the test vectors
+// 4-S-1, 4-S-2 and 4-S-3 of the PASETO specification
+func TestSignPaseto(t *testing.T) {
+	secretKey, err := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+		"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := hex.DecodeString("1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		payload = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+		footer  = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	)
+
+	tdt := []struct {
+		footer   string
+		implicit string
+		token    string
+	}{
+		// 4-S-1
+		{
+			token: "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+				"bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+		},
+		// 4-S-2
+		{
+			footer: footer,
+			token: "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+				"v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw" +
+				".eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		},
+		// 4-S-3
+		{
+			footer:   footer,
+			implicit: `{"test-vector":"4-S-3"}`,
+			token: "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+				"NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ" +
+				".eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			token := signPaseto(secretKey, []byte(payload), []byte(v.footer), []byte(v.implicit))
+			if token != v.token {
+				t.Fatalf(`expected token "%s" got "%s"`, v.token, token)
+			}
+
+			gotPayload, gotFooter, err := verifyPaseto(publicKey, v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(gotPayload) != payload || string(gotFooter) != v.footer {
+				t.Fatalf(`unexpected payload "%s" and footer "%s"`, gotPayload, gotFooter)
+			}
+
+			// The implicit assertion is part of the signature
+			if _, _, err = verifyPaseto(publicKey, v.token, []byte(`{"test-vector":"other"}`)); err == nil {
+				t.Fatal("the token was verified with other implicit assertion")
+			}
+		})
+	}
+}
+
+// TestPasetoGenerator_ParseToken checks that the v4.public tokens carry the claims of model.JWT, that the tampered
+// or expired tokens are rejected and that the introspection resolves them
+func TestPasetoGenerator_ParseToken(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generator := PasetoGenerator{KeyId: "paseto-1"}
+
+	err = generator.SetPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(map[string]interface{}{"session": model.Session{TokenId: "session"}})
+
+	introspector := Introspector{
+		Clients:        repository.MockClientFinder{"billing": {Secret: "secret"}},
+		Paseto:         generator,
+		SessionStorage: sessions,
+	}
+
+	generate := func(expiresAt int64) string {
+		tkn, err := generator.GenerateToken(model.JWT{
+			Scope:  model.Mask{"orders": 0x03},
+			Claims: model.Map{"tier": "gold"},
+			StandardClaims: model.StandardClaims{
+				Id:        "session",
+				Subject:   "alice",
+				Audience:  "https://billing.example.com",
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: expiresAt,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tkn.AccessToken
+	}
+
+	token := generate(time.Now().Add(time.Hour).Unix())
+
+	parts := strings.Split(strings.TrimPrefix(token, PasetoV4Public), ".")
+
+	// Changes the first byte of the payload keeping the signature
+	b, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	b[0] ^= 0xFF
+
+	tampered := PasetoV4Public + base64.RawURLEncoding.EncodeToString(b) + "." + parts[1]
+
+	tdt := []struct {
+		token       string
+		expectedErr error
+	}{
+		{
+			token: token,
+		},
+		// The footer is signed, so the token is invalid without it
+		{
+			token:       PasetoV4Public + parts[0],
+			expectedErr: model.InvalidToken,
+		},
+		{
+			token:       tampered,
+			expectedErr: model.InvalidToken,
+		},
+		// Expired token
+		{
+			token:       generate(time.Now().Add(-time.Minute).Unix()),
+			expectedErr: model.InvalidToken,
+		},
+		// JWT
+		{
+			token:       "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZSJ9.",
+			expectedErr: model.InvalidToken,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			claims, err := generator.ParseToken(v.token)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			res, introspectionErr := introspector.Introspect(model.Application{Id: "billing", Secret: "secret"}, v.token)
+			if introspectionErr != nil {
+				t.Fatal(introspectionErr)
+			}
+
+			if res["active"] != (err == nil) {
+				t.Fatalf(`unexpected introspection response "%v"`, res)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if claims.Subject != "alice" || claims.Claims["tier"] != "gold" || claims.ExpiresAt == 0 {
+				t.Fatalf(`unexpected claims "%+v"`, claims)
+			}
+
+			mask, err := newMask(claims.Scope)
+			if err != nil || mask["orders"] != 0x03 {
+				t.Fatalf(`unexpected scope "%v"`, claims.Scope)
+			}
+
+			if res["scope"] != "orders:3" || res["sub"] != "alice" {
+				t.Fatalf(`unexpected introspection response "%v"`, res)
+			}
+		})
+	}
+
+	// "v4.public" is not a JWA algorithm, so the key is published without "alg"
+	jwk, ok := generator.JWK()
+	if !ok || jwk.KeyType != "OKP" || jwk.KeyId != "paseto-1" || jwk.Algorithm != "" {
+		t.Fatalf(`unexpected key "%+v"`, jwk)
+	}
+
+	// The key id is the JWK Thumbprint by default, so it does not change while the key is the same
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		defaultKeyId := PasetoGenerator{}
+
+		err = defaultKeyId.SetPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if defaultKeyId.KeyId != thumbprint {
+			t.Fatalf(`expected key id "%s" got "%s"`, thumbprint, defaultKeyId.KeyId)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package business
 import (
 	"errors"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/yael-castro/goauth/internal/model"
 	"github.com/yael-castro/goauth/internal/repository"
-	"path"
 	"time"
 )
 
+// IDTokenLifeTime life time of the ID tokens
+const IDTokenLifeTime = time.Hour
+
 // Authorizer handles authorization requests for any flow of the OAuth 2.0 protocol
 type Authorizer interface {
 	// Authorize receives an authorization request and returns the redirect uri
@@ -28,22 +31,75 @@ type CodeGrant interface {
 	CodeExchanger
 }
 
+// _ "implement" constraint for GrantSwitch
+var _ CodeExchanger = GrantSwitch{}
+
+// GrantSwitch dispatches the token requests to the CodeExchanger registered for the grant_type
+type GrantSwitch map[string]CodeExchanger
+
+// ExchangeCode delegates the model.Exchange to the CodeExchanger of the grant_type
+func (g GrantSwitch) ExchangeCode(exchange model.Exchange) (model.Token, error) {
+	exchanger, ok := g[exchange.GrantType]
+	if !ok {
+		return model.Token{}, fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
+	}
+
+	return exchanger.ExchangeCode(exchange)
+}
+
 // _ "implement" constraints for ProofKeyCodeExchange
 var _ CodeGrant = (*AuthorizationCodeGrant)(nil)
 
 // AuthorizationCodeGrant made the validations that correspond to the Authorization Code Grant flow
 type AuthorizationCodeGrant struct {
+	// Issuer identifier of the authorization server used as "iss" claim of the generated tokens
+	Issuer string
+	// OAuth21 enables the OAuth 2.1 requirements: PKCE for all clients with the S256 method only
+	//
+	// Note: the exact redirect uri matching is enabled through the Client authenticator
+	OAuth21 bool
 	// PKCE must be an implementation of the Proof Key for Code Exchange extension
 	PKCE CodeChallengeValidator
 	// ScopeParser parses a scope from string
 	ScopeParser
+	// Resources protected resources that can be indicated in the requests (RFC 8707)
+	//
+	// If it is nil the requests with resource indicators are rejected
+	Resources ResourceRegistry
+	// Clients finder of the clients used to obtain their allowed and default scopes (Optional)
+	Clients repository.Finder
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or the owner are rejected or removed
+	ScopePolicy ScopePolicy
+	// Policy decides if the owner can authorize the client (Optional)
+	Policy Policy
+	// AccessClaims adds claims to the access tokens (Optional)
+	AccessClaims ClaimsEnricher
+	// IDClaims adds claims to the ID tokens issued when the "openid" scope is requested (Optional)
+	IDClaims ClaimsEnricher
+	// IDTokenGenerator generates the ID tokens, they are always JWTs even if the access tokens are opaque
+	// (TokenGenerator by default)
+	IDTokenGenerator TokenGenerator
+	// Subjects obtains the subject identifiers sent to the pairwise clients (Optional)
+	//
+	// If it is nil the owner id is sent to every client
+	Subjects SubjectResolver
+	// DetailRegistry types of authorization_details accepted in the requests (RFC 9396)
+	//
+	// If it is nil the requests with authorization_details are rejected
+	DetailRegistry DetailRegistry
 	// CodeGenerator generate a code to save and validate the requests
 	CodeGenerator
 	TokenGenerator
 	Owner  Authenticator
 	Client Authenticator
-	// CodeStorage store for all exchange codes generated
-	CodeStorage repository.Storage
+	// CodeStorage store for all exchange codes generated, each code can be consumed only once
+	CodeStorage repository.ConsumerStorage
+	// UsedCodeStorage store for the tombstones of consumed codes (Optional)
+	//
+	// If it is defined, a second redemption of a code revokes the session issued with the code
+	UsedCodeStorage repository.Storage
 	// SessionStorage store for all exchange codes
 	SessionStorage repository.Storage
 }
@@ -52,42 +108,60 @@ type AuthorizationCodeGrant struct {
 // then if the record exists, the model.State, model.CodeChallenge
 func (c AuthorizationCodeGrant) ExchangeCode(exchange model.Exchange) (tkn model.Token, err error) {
 	if exchange.GrantType != "authorization_code" {
-		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedResponseType, exchange.GrantType)
+		err = fmt.Errorf("%w: grant_type '%s' is not supported", model.UnsupportedGrantType, exchange.GrantType)
 		return
 	}
 
 	err = c.Client.Authenticate(exchange.Application)
 	if err != nil {
+		// The client is known but the redirect uri does not match to the registered ones
+		if errors.Is(err, model.InvalidRequest) {
+			err = fmt.Errorf("%w: %s", model.InvalidGrant, err.Error())
+		}
+
 		return
 	}
 
-	i, err := c.CodeStorage.Obtain(string(exchange.AuthorizationCode))
+	// The code is consumed even if the exchange fails, so it can not be used twice
+	i, err := c.CodeStorage.Consume(string(exchange.AuthorizationCode))
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		err = c.revokeReplayedCode(exchange.AuthorizationCode)
+	}
+
 	if err != nil {
 		return
 	}
 
 	authorization := i.(model.Authorization)
 
+	tokenId := uuid.New().String()
+
+	if c.UsedCodeStorage != nil {
+		err = c.UsedCodeStorage.Create(string(exchange.AuthorizationCode), tokenId)
+		if err != nil {
+			return
+		}
+	}
+
 	if authorization.State != exchange.State {
-		return model.Token{}, fmt.Errorf("%w: state does not match", model.AccessDenied)
+		return model.Token{}, fmt.Errorf("%w: state does not match", model.InvalidGrant)
 	}
 
 	if c.PKCE != nil {
-		if !exchange.CodeVerifier.IsValid(authorization.CodeChallenge, authorization.CodeChallengeMethod) {
-			return model.Token{}, fmt.Errorf("%w: code_verifier does not match to code_challenge", model.AccessDenied)
+		err = c.PKCE.ValidateCodeVerifier(exchange.CodeVerifier, authorization.CodeChallenge, authorization.CodeChallengeMethod)
+		if err != nil {
+			return
 		}
 	}
 
 	if authorization.Application.Id != exchange.Application.Id {
-		return model.Token{}, errors.New("client_id does not match")
+		return model.Token{}, fmt.Errorf("%w: client_id does not match", model.InvalidGrant)
 	}
 
-	// TODO tool to compare the Redirect URLs
-	authorization.RedirectURL.RawQuery = ""
-	exchange.RedirectURL.RawQuery = ""
-
-	if path.Join(authorization.RedirectURL.String()) != path.Join(exchange.RedirectURL.String()) {
-		return model.Token{}, fmt.Errorf("%w: redirect_uri does not match to the first redirect_uri", model.AccessDenied)
+	// The redirect_uri must be identical to the redirect_uri of the authorization request (RFC 6749 section 4.1.3),
+	// the patterns and the loopback ports are only accepted in the authorization request
+	if exchange.RedirectURL == nil || exchange.RedirectURL.String() != authorization.RedirectURL.String() {
+		return model.Token{}, fmt.Errorf("%w: redirect_uri does not match to the first redirect_uri", model.InvalidGrant)
 	}
 
 	scope, err := c.ParseScope(authorization.Scope)
@@ -95,38 +169,212 @@ func (c AuthorizationCodeGrant) ExchangeCode(exchange model.Exchange) (tkn model
 		return
 	}
 
+	// The audience of the token is the indicated resource or the client if no resource is indicated
+	audience := authorization.Application.Id
+
+	resource, err := selectResource(authorization.Resources, exchange.Resources)
+	if err != nil {
+		return
+	}
+
+	if resource != "" {
+		if _, err = c.resolveResources([]string{resource}); err != nil {
+			return
+		}
+
+		audience = resource
+	}
+
+	// The token request may narrow the authorization_details granted in the authorization request
+	details := authorization.AuthorizationDetails
+
+	if len(exchange.AuthorizationDetails) > 0 {
+		if !details.Contains(exchange.AuthorizationDetails) {
+			return model.Token{}, fmt.Errorf("%w: authorization_details exceed the authorized ones", model.InvalidAuthorizationDetails)
+		}
+
+		details = exchange.AuthorizationDetails
+	}
+
 	token := model.JWT{
-		// UserId: authorization.BasicAuth.Id,
-		Scope: scope,
+		Scope:                scope,
+		AuthorizationDetails: details,
 		StandardClaims: model.StandardClaims{
-			Id:       uuid.New().String(),
-			Issuer:   "go-auth",
+			Id:       tokenId,
+			Issuer:   c.Issuer,
 			Subject:  authorization.BasicAuth.Id,
-			Audience: authorization.Application.Id,
+			Audience: audience,
 			IssuedAt: time.Now().Unix(),
 		},
 	}
 
+	if err = enrichClaims(c.AccessClaims, authorization.Application.Id, &token); err != nil {
+		return
+	}
+
+	token.Subject, err = c.subject(authorization.Application.Id, token.Subject)
+	if err != nil {
+		return
+	}
+
 	tkn, err = c.GenerateToken(token)
 	if err != nil {
 		return
 	}
 
+	tkn.AuthorizationDetails = details
+
+	if authorization.OpenID {
+		tkn.IdToken, err = c.generateIdToken(authorization, token.IssuedAt)
+		if err != nil {
+			return
+		}
+	}
+
+	if tkn.ExpiresIn > 0 {
+		exchange.Session.Expiration = time.Duration(tkn.ExpiresIn) * time.Second
+	}
+
+	// The session keeps the owner id to resolve the pairwise subject identifiers internally
+	exchange.Session.Owner = model.Owner{Id: authorization.BasicAuth.Id}
+
 	// TODO check the data saved using the session storage
 	err = c.SessionStorage.Create(token.Id, exchange.Session)
+	return
+}
+
+// generateIdToken generates the ID token that contains the claims about the authentication of the owner,
+// the audience of the ID token is always the client
+func (c AuthorizationCodeGrant) generateIdToken(authorization model.Authorization, issuedAt int64) (string, error) {
+	token := model.JWT{
+		StandardClaims: model.StandardClaims{
+			Issuer:    c.Issuer,
+			Subject:   authorization.BasicAuth.Id,
+			Audience:  authorization.Application.Id,
+			IssuedAt:  issuedAt,
+			ExpiresAt: time.Unix(issuedAt, 0).Add(IDTokenLifeTime).Unix(),
+		},
+	}
+
+	if authorization.Nonce != "" {
+		token.Claims = model.Map{"nonce": authorization.Nonce}
+	}
+
+	if err := enrichClaims(c.IDClaims, authorization.Application.Id, &token); err != nil {
+		return "", err
+	}
+
+	subject, err := c.subject(authorization.Application.Id, token.Subject)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	err = c.CodeStorage.Delete(string(exchange.AuthorizationCode))
-	return
+	token.Subject = subject
+
+	generator := c.IDTokenGenerator
+	if generator == nil {
+		generator = c.TokenGenerator
+	}
+
+	tkn, err := generator.GenerateToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	return c.encryptIdToken(authorization.Application.Id, tkn.AccessToken)
+}
+
+// subject returns the subject identifier of the owner for the client, it is obtained after the claims are enriched
+// so the enrichers always receive the owner id
+func (c AuthorizationCodeGrant) subject(clientId, ownerId string) (string, error) {
+	return resolveSubject(c.Subjects, clientId, ownerId)
+}
+
+// encryptIdToken encrypts the ID token with the public key of the client if the client registered the
+// id_token_encrypted_response_alg, otherwise the ID token is only signed
+func (c AuthorizationCodeGrant) encryptIdToken(clientId, idToken string) (string, error) {
+	if c.Clients == nil {
+		return idToken, nil
+	}
+
+	client, err := findClient(c.Clients, clientId)
+	if err != nil {
+		return "", err
+	}
+
+	if client.IDTokenEncryptedResponseAlg == "" {
+		return idToken, nil
+	}
+
+	key, ok := encryptionKey(client.Keys, client.IDTokenEncryptedResponseAlg)
+	if !ok {
+		return "", fmt.Errorf(`%w: client "%s" does not have a key for "%s"`, model.InvalidClient, clientId, client.IDTokenEncryptedResponseAlg)
+	}
+
+	encrypter, err := NewJWEEncrypter(key, client.IDTokenEncryptedResponseAlg)
+	if err != nil {
+		return "", err
+	}
+
+	return encrypter.Encrypt(idToken)
+}
+
+// grantScope returns the scope granted to the client on behalf of the owner, if the client does not request
+// a scope its default scope is used
+//
+// The granted scope is requested ∩ client-allowed ∩ owner-entitled, the scopes not allowed for the client or
+// not entitled to the owner are handled by the ScopePolicy
+func (c AuthorizationCodeGrant) grantScope(clientId, ownerId, requested string) (string, error) {
+	granter := scopeGranter{
+		ScopeParser: c.ScopeParser,
+		Clients:     c.Clients,
+		Entitler:    c.Entitler,
+		ScopePolicy: c.ScopePolicy,
+	}
+
+	return granter.grant(clientId, ownerId, requested)
+}
+
+// resolveResources resolves the resource indicators using the registered Resources
+func (c AuthorizationCodeGrant) resolveResources(identifiers []string) ([]model.Resource, error) {
+	if c.Resources == nil {
+		return nil, fmt.Errorf("%w: resource indicators are not supported", model.InvalidTarget)
+	}
+
+	return c.Resources.Resolve(identifiers)
+}
+
+// revokeReplayedCode is called when the authorization code does not exist, if the code was already used
+// the session issued with the code is revoked as RFC 6749 (section 4.1.2) recommends
+//
+// Always returns an error of type model.InvalidGrant or a storage error
+func (c AuthorizationCodeGrant) revokeReplayedCode(code model.AuthorizationCode) error {
+	if c.UsedCodeStorage == nil {
+		return fmt.Errorf("%w: invalid authorization code", model.InvalidGrant)
+	}
+
+	i, err := c.UsedCodeStorage.Obtain(string(code))
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return fmt.Errorf("%w: invalid authorization code", model.InvalidGrant)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = c.SessionStorage.Delete(i.(string)); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: authorization code was already used, the issued tokens were revoked", model.InvalidGrant)
 }
 
 // Authorize validate the client model.Client obtained with the received data (model.Authorization)
 //
 // In resume...
 //
-// 1. Identifies the client using the client id and client secret
+// 1. Identifies the client using the client id and client secret, the errors of this step are
+// returned as model.UntrustedRedirect because the redirect uri can not be trusted
 //
 // 2. Validates the received state
 //
@@ -135,16 +383,24 @@ func (c AuthorizationCodeGrant) ExchangeCode(exchange model.Exchange) (tkn model
 // 4. Saves the session of this authorization request using the random code generated by
 // the CodeGenerator
 func (c AuthorizationCodeGrant) Authorize(a model.Authorization) (code model.AuthorizationCode, err error) {
-	if a.ResponseType != "code" {
-		return "", fmt.Errorf(`%w: "%s" is not supported`, model.UnsupportedResponseType, a.ResponseType)
+	if a.RedirectURL == nil {
+		err = fmt.Errorf("%w: missing or malformed redirect_uri", model.InvalidRequest)
+		return "", model.UntrustedRedirect{Err: err}
 	}
 
-	// Cleaning query params
-	a.RedirectURL.RawQuery = ""
-
 	err = c.Client.Authenticate(a.Application)
 	if err != nil {
-		return // model.FailedAuthentication
+		return "", model.UntrustedRedirect{Err: err} // model.FailedAuthentication
+	}
+
+	// From here the redirect uri is trusted so the errors can be sent to the client
+
+	if a.ResponseType != "code" {
+		return "", fmt.Errorf(`%w: "%s" is not supported`, model.UnsupportedResponseType, a.ResponseType)
+	}
+
+	if a.ResponseMode != "" && !a.ResponseMode.IsValid() {
+		return "", fmt.Errorf(`%w: response_mode "%s" is not supported`, model.InvalidRequest, a.ResponseMode)
 	}
 
 	err = c.Owner.Authenticate(a.BasicAuth)
@@ -156,6 +412,17 @@ func (c AuthorizationCodeGrant) Authorize(a model.Authorization) (code model.Aut
 		return "", fmt.Errorf("%w: state is not valid", model.InvalidRequest)
 	}
 
+	// OAuth 2.1 requires PKCE for all clients and forbids the "plain" method
+	if c.OAuth21 {
+		if c.PKCE == nil || a.CodeChallenge == "" {
+			return "", fmt.Errorf("%w: code_challenge is required", model.InvalidRequest)
+		}
+
+		if !a.CodeChallengeMethod.IsS256() {
+			return "", fmt.Errorf(`%w: code_challenge_method must be "S256"`, model.InvalidRequest)
+		}
+	}
+
 	// Proof Key for Code Exchange (Extension)
 	if c.PKCE != nil {
 		if err = c.PKCE.ValidateCodeChallenge(a.CodeChallenge, a.CodeChallengeMethod); err != nil {
@@ -163,11 +430,51 @@ func (c AuthorizationCodeGrant) Authorize(a model.Authorization) (code model.Aut
 		}
 	}
 
-	_, err = c.ParseScope(a.Scope)
+	// The "openid" scope requests an ID token instead of permissions
+	a.Scope, a.OpenID = model.SplitOpenIDScope(a.Scope)
+
+	a.Scope, err = c.grantScope(a.Application.Id, a.BasicAuth.Id, a.Scope)
+	if err != nil {
+		return // Invalid scope
+	}
+
+	scope, err := c.ParseScope(a.Scope)
 	if err != nil {
 		return // Invalid scope
 	}
 
+	err = enforcePolicy(c.Policy, model.PolicyRequest{
+		Grant:     "authorization_code",
+		Owner:     a.BasicAuth.Id,
+		Client:    a.Application.Id,
+		IP:        a.IP,
+		UserAgent: a.UserAgent,
+	}, scope, model.InteractionRequired)
+	if err != nil {
+		return // Access denied or step-up required
+	}
+
+	if len(a.Resources) > 0 {
+		resources, err := c.resolveResources(a.Resources)
+		if err != nil {
+			return "", err // Invalid target
+		}
+
+		if err = c.Resources.ValidateScope(scope, resources); err != nil {
+			return "", err // Invalid scope
+		}
+	}
+
+	if len(a.AuthorizationDetails) > 0 {
+		if c.DetailRegistry == nil {
+			return "", fmt.Errorf("%w: authorization_details are not supported", model.InvalidAuthorizationDetails)
+		}
+
+		if err = c.DetailRegistry.Validate(a.AuthorizationDetails); err != nil {
+			return // Invalid authorization details
+		}
+	}
+
 	code = c.GenerateCode()
 	if err = c.CodeStorage.Create(string(code), a); err != nil {
 		return // Server error
@@ -180,27 +487,87 @@ func (c AuthorizationCodeGrant) Authorize(a model.Authorization) (code model.Aut
 type CodeChallengeValidator interface {
 	// ValidateCodeChallenge validates the code_challenge and code_challenge_method as part of the PKCE extension
 	ValidateCodeChallenge(model.CodeChallenge, model.CodeChallengeMethod) error
+	// ValidateCodeVerifier validates the code_verifier against the code_challenge and code_challenge_method
+	// received in the authorization request
+	ValidateCodeVerifier(model.CodeVerifier, model.CodeChallenge, model.CodeChallengeMethod) error
 }
 
+// _ "implement" constraint for ProofKeyCodeExchange
+var _ CodeChallengeValidator = ProofKeyCodeExchange{}
+
 // ProofKeyCodeExchange is the "Authorization Code Grant" flow with the extension "Proof Key for Code Exchange"
-// for the OAuth 2.0 protocol
-type ProofKeyCodeExchange struct{}
+// for the OAuth 2.0 protocol (RFC 7636)
+type ProofKeyCodeExchange struct {
+	// Required makes the code_challenge mandatory for all clients
+	Required bool
+	// DisablePlain rejects the "plain" code_challenge_method
+	DisablePlain bool
+}
+
+// Methods returns the code_challenge_method values accepted, they are published in the metadata of the
+// authorization server
+func (p ProofKeyCodeExchange) Methods() []string {
+	if p.DisablePlain {
+		return []string{string(model.S256Method)}
+	}
+
+	return []string{string(model.PlainMethod), string(model.S256Method)}
+}
 
 // ValidateCodeChallenge validates the code_challenge and code_challenge_method
+//
+// If the code_challenge_method is missing, "plain" is assumed as RFC 7636 defines
 func (p ProofKeyCodeExchange) ValidateCodeChallenge(challenge model.CodeChallenge, method model.CodeChallengeMethod) error {
+	if challenge == "" && method == "" && !p.Required {
+		return nil
+	}
+
+	if challenge == "" {
+		return fmt.Errorf("%w: code_challenge is required", model.InvalidRequest)
+	}
+
+	if method == "" {
+		method = model.PlainMethod
+	}
+
 	if !method.IsValid() {
-		return fmt.Errorf(`%w: invalid code_challenge_method "%s", must be PLAIN or S256`, model.InvalidRequest, challenge)
+		return fmt.Errorf(`%w: invalid code_challenge_method "%s", must be plain or S256`, model.InvalidRequest, method)
 	}
 
-	// TODO validate correctly the code challenge based on the model.CodeChallengeMethod
-	// BUG: if code_challenge_method is S256 and code_challenge contains the ~ character
-	// it is valid, which should be wrong because when using the S256 method the information
-	// is sent in Base64 (URL) so it should not contain the ~ character at all
-	//
-	// This BUG should not cause any security problems but it does generate conflicts
-	// when validating the code_verifier if the code_challenge contains the ~ character
-	if !challenge.IsValid() {
-		return fmt.Errorf("%w: %s", model.InvalidRequest, "invalid code_challenge")
+	if p.DisablePlain && method.IsPlain() {
+		return fmt.Errorf(`%w: code_challenge_method "plain" is not allowed`, model.InvalidRequest)
+	}
+
+	if !challenge.IsValid(method) {
+		return fmt.Errorf(`%w: invalid code_challenge for the code_challenge_method "%s"`, model.InvalidRequest, method)
+	}
+
+	return nil
+}
+
+// ValidateCodeVerifier validates the code_verifier sent to the token endpoint
+//
+// If the authorization request did not use PKCE the code_verifier must not be sent,
+// this prevents PKCE downgrade attacks
+func (p ProofKeyCodeExchange) ValidateCodeVerifier(verifier model.CodeVerifier, challenge model.CodeChallenge, method model.CodeChallengeMethod) error {
+	if challenge == "" {
+		if verifier != "" {
+			return fmt.Errorf("%w: code_verifier was sent but no code_challenge was received", model.InvalidGrant)
+		}
+
+		return nil
+	}
+
+	if !verifier.IsValid() {
+		return fmt.Errorf("%w: missing or malformed code_verifier", model.InvalidRequest)
+	}
+
+	if method == "" {
+		method = model.PlainMethod
+	}
+
+	if !verifier.Verify(challenge, method) {
+		return fmt.Errorf("%w: code_verifier does not match to code_challenge", model.InvalidGrant)
 	}
 
 	return nil
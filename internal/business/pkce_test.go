@@ -5,6 +5,7 @@ import (
 	"github.com/yael-castro/goauth/internal/model"
 	"github.com/yael-castro/goauth/internal/repository"
 	"net/url"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -68,7 +69,7 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 				},
 				State: "BBB",
 			},
-			expectedErr: model.UnauthorizedClient,
+			expectedErr: model.InvalidClient,
 		},
 		// Test case for invalid redirect url
 		{
@@ -84,7 +85,7 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 				},
 				State: "CCC",
 			},
-			expectedErr: model.UnauthorizedClient,
+			expectedErr: model.InvalidRequest,
 		},
 		// Invalid code_challenge_method
 		{
@@ -101,7 +102,9 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 					Id:       "contacto@yael-castro.com",
 					Password: "yael.castro",
 				},
-				State: "DDD",
+				State:               "DDD",
+				CodeChallenge:       "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890_~BCDEE",
+				CodeChallengeMethod: "S512",
 			},
 			expectedErr: model.InvalidRequest,
 		},
@@ -125,6 +128,33 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 			},
 			expectedErr: model.InvalidRequest,
 		},
+		// Unsupported response_mode
+		{
+			input: model.Authorization{
+				ResponseType: "code",
+				ResponseMode: "web_message",
+				Application: model.Application{
+					Id: "a06a0630-31f5-4cc3-8e47-ea61a60c1199",
+					RedirectURL: func() *url.URL {
+						uri, _ := url.Parse("http://localhost/callback")
+						return uri
+					}(),
+				},
+				State: "FFF",
+			},
+			expectedErr: model.InvalidRequest,
+		},
+		// Missing redirect uri
+		{
+			input: model.Authorization{
+				ResponseType: "code",
+				Application: model.Application{
+					Id: "a06a0630-31f5-4cc3-8e47-ea61a60c1199",
+				},
+				State: "GGG",
+			},
+			expectedErr: model.InvalidRequest,
+		},
 	}
 
 	authorizer := AuthorizationCodeGrant{
@@ -133,25 +163,25 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 			Finder: repository.MockClientFinder{
 				"a06a0630-31f5-4cc3-8e47-ea61a60c1199": {
 					Id:             "a06a0630-31f5-4cc3-8e47-ea61a60c1199",
-					AllowedOrigins: []string{"http://localhost/callback/", "http://localhost:8080/callback/"},
+					AllowedOrigins: []string{"http://localhost/callback", "http://localhost:8080/callback"},
 				},
 				"4cc3-8e47-ea61a60c1199-a06a0630-31f5": {
 					Id:             "4cc3-8e47-ea61a60c1199-a06a0630-31f5",
-					AllowedOrigins: []string{"http://localhost/callback/", "http://localhost:8080/callback/"},
+					AllowedOrigins: []string{"http://localhost/callback", "http://localhost:8080/callback"},
 				},
 			},
 		},
 		CodeGenerator:  GenerateRandomCode,
-		CodeStorage:    &repository.MockStorage{},
-		SessionStorage: &repository.MockStorage{},
+		CodeStorage:    repository.NewMockStorage(nil),
+		SessionStorage: repository.NewMockStorage(nil),
 		ScopeParser:    NewScopeParser(),
 		Owner: OwnerAuthenticator{
-			Storage: &repository.MockStorage{
+			Storage: repository.NewMockStorage(map[string]interface{}{
 				"contacto@yael-castro.com": model.Owner{
 					Id:       "contacto@yael-castro.com",
 					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
 				},
-			},
+			}),
 		},
 	}
 
@@ -163,6 +193,11 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
 			}
 
+			// Errors caused before validating the client must not be sent to the redirect uri
+			if errors.Is(err, model.InvalidClient) && !errors.As(err, &model.UntrustedRedirect{}) {
+				t.Fatalf(`expected error of type "%T" got "%T"`, model.UntrustedRedirect{}, err)
+			}
+
 			if err != nil {
 				t.Skipf("%v => %v", errors.Unwrap(err), err)
 			}
@@ -171,3 +206,338 @@ func TestProofKeyCodeExchange_Authorize(t *testing.T) {
 		})
 	}
 }
+
+// TestProofKeyCodeExchange_ValidateCodeChallenge checks the validation of the code_challenge for each code_challenge_method
+func TestProofKeyCodeExchange_ValidateCodeChallenge(t *testing.T) {
+	tdt := []struct {
+		pkce        ProofKeyCodeExchange
+		challenge   model.CodeChallenge
+		method      model.CodeChallengeMethod
+		expectedErr error
+	}{
+		// PKCE is optional
+		{},
+		// PKCE is required
+		{
+			pkce:        ProofKeyCodeExchange{Required: true},
+			expectedErr: model.InvalidRequest,
+		},
+		// Valid S256 challenge
+		{
+			challenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			method:    "S256",
+		},
+		// S256 challenges never contain the "~" character
+		{
+			challenge:   "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw~cM",
+			method:      "S256",
+			expectedErr: model.InvalidRequest,
+		},
+		// S256 challenges always have 43 characters
+		{
+			challenge:   "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM=",
+			method:      "S256",
+			expectedErr: model.InvalidRequest,
+		},
+		// Missing code_challenge_method is plain
+		{
+			challenge: "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890_~BCDEE",
+		},
+		// Plain method is disabled
+		{
+			pkce:        ProofKeyCodeExchange{DisablePlain: true},
+			challenge:   "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890_~BCDEE",
+			method:      "plain",
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := v.pkce.ValidateCodeChallenge(v.challenge, v.method)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			t.Log(err)
+		})
+	}
+}
+
+// TestProofKeyCodeExchange_Methods checks that the "plain" method is not published when it is disabled
+func TestProofKeyCodeExchange_Methods(t *testing.T) {
+	tdt := []struct {
+		pkce     ProofKeyCodeExchange
+		expected []string
+	}{
+		{
+			expected: []string{"plain", "S256"},
+		},
+		{
+			pkce:     ProofKeyCodeExchange{Required: true, DisablePlain: true},
+			expected: []string{"S256"},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			if methods := v.pkce.Methods(); !reflect.DeepEqual(methods, v.expected) {
+				t.Fatalf(`expected "%v" got "%v"`, v.expected, methods)
+			}
+
+			// The published methods are accepted by the validation
+			for _, method := range v.pkce.Methods() {
+				err := v.pkce.ValidateCodeChallenge("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", model.CodeChallengeMethod(method))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestProofKeyCodeExchange_ValidateCodeVerifier checks the verification of the code_verifier
+// using the examples of the RFC 7636 (Appendix B)
+func TestProofKeyCodeExchange_ValidateCodeVerifier(t *testing.T) {
+	tdt := []struct {
+		verifier    model.CodeVerifier
+		challenge   model.CodeChallenge
+		method      model.CodeChallengeMethod
+		expectedErr error
+	}{
+		// Valid S256 verifier
+		{
+			verifier:  "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			challenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			method:    "S256",
+		},
+		// Invalid S256 verifier
+		{
+			verifier:    "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXK",
+			challenge:   "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			method:      "S256",
+			expectedErr: model.InvalidGrant,
+		},
+		// Valid plain verifier
+		{
+			verifier:  "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			challenge: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+		},
+		// Verifier too short
+		{
+			verifier:    "dBjftJeZ4CVP",
+			challenge:   "dBjftJeZ4CVP",
+			method:      "plain",
+			expectedErr: model.InvalidRequest,
+		},
+		// Verifier without challenge (downgrade attack)
+		{
+			verifier:    "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			expectedErr: model.InvalidGrant,
+		},
+	}
+
+	pkce := ProofKeyCodeExchange{}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := pkce.ValidateCodeVerifier(v.verifier, v.challenge, v.method)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			t.Log(err)
+		})
+	}
+}
+
+// TestAuthorizationCodeGrant_ExchangeCode checks that an authorization code can be exchanged only once
+// and the replay of a code revokes the session issued with it
+func TestAuthorizationCodeGrant_ExchangeCode(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMockStorage(nil)
+
+	grant := AuthorizationCodeGrant{
+		PKCE:           ProofKeyCodeExchange{},
+		ScopeParser:    NewScopeParser(),
+		CodeGenerator:  GenerateRandomCode,
+		TokenGenerator: generator,
+		Client: ClientAuthenticator{
+			Finder: repository.MockClientFinder{
+				"mobile": {AllowedOrigins: []string{"http://localhost/callback"}},
+			},
+		},
+		Owner: OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		CodeStorage:     repository.NewMockStorage(nil),
+		UsedCodeStorage: repository.NewMockStorage(nil),
+		SessionStorage:  sessions,
+	}
+
+	application := model.Application{
+		Id: "mobile",
+		RedirectURL: func() *url.URL {
+			uri, _ := url.Parse("http://localhost/callback")
+			return uri
+		}(),
+	}
+
+	code, err := grant.Authorize(model.Authorization{
+		Application:         application,
+		ResponseType:        "code",
+		State:               "AAA",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+		BasicAuth: model.Owner{
+			Id:       "contacto@yael-castro.com",
+			Password: "yael.castro",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchange := model.Exchange{
+		GrantType:         "authorization_code",
+		Application:       application,
+		AuthorizationCode: code,
+		CodeVerifier:      "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+		State:             "AAA",
+		RedirectURL:       application.RedirectURL,
+	}
+
+	tdt := []struct {
+		expectedErr      error
+		expectedSessions int
+	}{
+		// First redemption
+		{expectedSessions: 1},
+		// Replay of the code
+		{expectedErr: model.InvalidGrant},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			_, err := grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if sessions.Len() != v.expectedSessions {
+				t.Fatalf(`expected %d sessions got %d`, v.expectedSessions, sessions.Len())
+			}
+
+			t.Log(err)
+		})
+	}
+}
+
+// TestAuthorizationCodeGrant_ExchangeCode_RedirectURI checks that the redirect_uri of the token request must be
+// identical to the redirect_uri of the authorization request, even for the loopback redirect uris
+func TestAuthorizationCodeGrant_ExchangeCode_RedirectURI(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := AuthorizationCodeGrant{
+		PKCE:           ProofKeyCodeExchange{},
+		ScopeParser:    NewScopeParser(),
+		CodeGenerator:  GenerateRandomCode,
+		TokenGenerator: generator,
+		Client: ClientAuthenticator{
+			Finder: repository.MockClientFinder{
+				"mobile": {AllowedOrigins: []string{"http://127.0.0.1/callback"}},
+			},
+		},
+		Owner: OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		CodeStorage:     repository.NewMockStorage(nil),
+		UsedCodeStorage: repository.NewMockStorage(nil),
+		SessionStorage:  repository.NewMockStorage(nil),
+	}
+
+	// The port of the loopback redirect uris is ignored in the authorization request
+	redirectURL, _ := url.Parse("http://127.0.0.1:51004/callback")
+
+	tdt := []struct {
+		redirectURI string
+		expectedErr error
+	}{
+		{
+			redirectURI: "http://127.0.0.1:51004/callback",
+		},
+		// Different port
+		{
+			redirectURI: "http://127.0.0.1:51005/callback",
+			expectedErr: model.InvalidGrant,
+		},
+		// Different path
+		{
+			redirectURI: "http://127.0.0.1:51004/callback/",
+			expectedErr: model.InvalidGrant,
+		},
+		// Missing redirect_uri
+		{
+			expectedErr: model.InvalidGrant,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			application := model.Application{Id: "mobile", RedirectURL: redirectURL}
+
+			code, err := grant.Authorize(model.Authorization{
+				Application:         application,
+				ResponseType:        "code",
+				State:               "AAA",
+				CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+				CodeChallengeMethod: "S256",
+				BasicAuth: model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "yael.castro",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exchange := model.Exchange{
+				GrantType:         "authorization_code",
+				Application:       model.Application{Id: "mobile"},
+				AuthorizationCode: code,
+				CodeVerifier:      "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+				State:             "AAA",
+			}
+
+			if v.redirectURI != "" {
+				exchange.RedirectURL, _ = url.Parse(v.redirectURI)
+			}
+
+			_, err = grant.ExchangeCode(exchange)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package business
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Policy decides if a grant can issue a token
+type Policy interface {
+	// Evaluate returns the decision for the facts of the request
+	Evaluate(model.PolicyRequest) (model.Decision, error)
+}
+
+// PolicyRule rule of a RulePolicy that takes the Effect when the condition is true
+type PolicyRule struct {
+	// Name identifier of the rule recorded in the audit log
+	Name string
+	// When condition of the rule
+	When Expression
+	// Effect decision taken when the condition is true
+	Effect model.Decision
+}
+
+// _ "implement" constraint for RulePolicy
+var _ Policy = RulePolicy{}
+
+// RulePolicy evaluates the rules in order, the first rule whose condition is true takes the decision,
+// if no rule matches the Default decision is taken
+//
+// Every decision is recorded by the Auditor
+type RulePolicy struct {
+	// Rules evaluated in order
+	Rules []PolicyRule
+	// Default decision taken when no rule matches (Allow by default)
+	Default model.Decision
+	// Auditor records the decisions (Optional)
+	Auditor
+}
+
+// Evaluate returns the decision of the first rule whose condition is true
+func (p RulePolicy) Evaluate(request model.PolicyRequest) (model.Decision, error) {
+	if request.Time.IsZero() {
+		request.Time = time.Now()
+	}
+
+	decision, rule := p.Default, ""
+
+	for _, r := range p.Rules {
+		matched, err := r.When.Evaluate(request)
+		if err != nil {
+			return model.Deny, fmt.Errorf(`rule "%s": %w`, r.Name, err)
+		}
+
+		if matched {
+			decision, rule = r.Effect, r.Name
+			break
+		}
+	}
+
+	if p.Auditor != nil {
+		err := p.Audit(model.AuditEvent{
+			Time:     request.Time,
+			Action:   "policy",
+			Request:  request,
+			Decision: decision,
+			Rule:     rule,
+		})
+		if err != nil {
+			return model.Deny, err
+		}
+	}
+
+	return decision, nil
+}
+
+// enforcePolicy evaluates the Policy (if it is defined) and converts the denials to model.AccessDenied and
+// the step-up decisions to the stepUp error
+func enforcePolicy(policy Policy, request model.PolicyRequest, scope interface{}, stepUp model.OAuthError) error {
+	if policy == nil {
+		return nil
+	}
+
+	mask, err := newMask(scope)
+	if err != nil {
+		return err
+	}
+
+	request.Scope = mask
+
+	decision, err := policy.Evaluate(request)
+	if err != nil {
+		return err
+	}
+
+	switch decision {
+	case model.Allow:
+		return nil
+
+	case model.StepUp:
+		return fmt.Errorf("%w: a stronger authentication of the owner is required", stepUp)
+	}
+
+	return fmt.Errorf("%w: the request was denied by the authorization policy", model.AccessDenied)
+}
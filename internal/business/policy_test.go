@@ -0,0 +1,144 @@
+package business
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestExpression_Evaluate checks the operators of the policy language
+func TestExpression_Evaluate(t *testing.T) {
+	request := model.PolicyRequest{
+		Grant:     "authorization_code",
+		Owner:     "alice@example.com",
+		Client:    "mobile",
+		Scope:     model.Mask{"orders": 0x03},
+		IP:        model.IP(net.ParseIP("10.1.2.3")),
+		UserAgent: "Mozilla/5.0 (iPhone)",
+		Time:      time.Date(2022, time.May, 7, 21, 30, 0, 0, time.UTC), // Saturday
+	}
+
+	tdt := []struct {
+		expression string
+		output     bool
+		compileErr bool
+	}{
+		{expression: `client == "mobile"`, output: true},
+		{expression: `client != "mobile"`},
+		{expression: `owner matches "*@example.com"`, output: true},
+		{expression: `not owner matches "*@example.com"`},
+		{expression: `user_agent contains "iPhone"`, output: true},
+		{expression: `ip in ["10.0.0.0/8", "192.168.1.10"]`, output: true},
+		{expression: `ip not in ["10.0.0.0/8"]`},
+		{expression: `ip == "10.1.2.3"`, output: true},
+		{expression: `hour < 8 or hour >= 20`, output: true},
+		{expression: `weekday in ["saturday", "sunday"]`, output: true},
+		{expression: `scope has "orders:2"`, output: true},
+		{expression: `scope has "orders:4"`},
+		{expression: `grant == "authorization_code" and (client == "web" or owner == "alice@example.com")`, output: true},
+		{expression: `true and not false`, output: true},
+		// Unknown identifier
+		{expression: `tenant == "a"`, compileErr: true},
+		// Malformed expressions
+		{expression: `client == `, compileErr: true},
+		{expression: `client = "mobile"`, compileErr: true},
+		{expression: `(client == "mobile"`, compileErr: true},
+		{expression: `client == "mobile" owner`, compileErr: true},
+		// Malformed scope
+		{expression: `scope has "orders"`, compileErr: true},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			expression, err := CompileExpression(v.expression, nil)
+			if (err != nil) != v.compileErr {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			output, err := expression.Evaluate(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if output != v.output {
+				t.Fatalf(`expected "%v" got "%v"`, v.output, output)
+			}
+		})
+	}
+}
+
+// TestRulePolicy_Evaluate checks that the first matching rule takes the decision and that every decision is audited
+func TestRulePolicy_Evaluate(t *testing.T) {
+	compile := func(source string) Expression {
+		expression, err := CompileExpression(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return expression
+	}
+
+	auditor := &MemoryAuditor{}
+
+	policy := RulePolicy{
+		Rules: []PolicyRule{
+			{Name: "partner", When: compile(`client == "partner" and not owner matches "*@partner.com"`), Effect: model.Deny},
+			{Name: "office", When: compile(`ip not in ["10.0.0.0/8"]`), Effect: model.Deny},
+			{Name: "admin", When: compile(`scope has "admin:1"`), Effect: model.StepUp},
+		},
+		Auditor: auditor,
+	}
+
+	office := model.IP(net.ParseIP("10.0.0.1"))
+
+	tdt := []struct {
+		request     model.PolicyRequest
+		decision    model.Decision
+		expectedErr error
+	}{
+		{
+			request: model.PolicyRequest{Owner: "bob@partner.com", Client: "partner", IP: office},
+		},
+		{
+			request:     model.PolicyRequest{Owner: "alice@example.com", Client: "partner", IP: office},
+			decision:    model.Deny,
+			expectedErr: model.AccessDenied,
+		},
+		{
+			request:     model.PolicyRequest{Owner: "alice@example.com", Client: "mobile", IP: model.IP(net.ParseIP("203.0.113.1"))},
+			decision:    model.Deny,
+			expectedErr: model.AccessDenied,
+		},
+		{
+			request:     model.PolicyRequest{Owner: "alice@example.com", Client: "mobile", IP: office, Scope: model.Mask{"admin": 0x01}},
+			decision:    model.StepUp,
+			expectedErr: model.InteractionRequired,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := enforcePolicy(policy, v.request, v.request.Scope, model.InteractionRequired)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			events := auditor.Events()
+			if len(events) != i+1 {
+				t.Fatalf("expected %d audit events got %d", i+1, len(events))
+			}
+
+			if event := events[i]; event.Decision != v.decision || event.Request.Owner != v.request.Owner {
+				t.Fatalf(`unexpected audit event "%+v"`, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package business
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// DetailType defines a type of authorization details (RFC 9396 section 2)
+type DetailType interface {
+	// ValidateDetail validates the fields of an element of the authorization_details
+	ValidateDetail(model.AuthorizationDetail) error
+	// DescribeDetail returns a human-readable description of the element to be shown to the owner
+	DescribeDetail(model.AuthorizationDetail) string
+}
+
+// DetailRegistry types of authorization details supported by the authorization server indexed by type identifier
+type DetailRegistry map[string]DetailType
+
+// Validate validates every element of the authorization_details against the registered types
+func (d DetailRegistry) Validate(details model.AuthorizationDetails) error {
+	for _, detail := range details {
+		detailType, ok := d[detail.Type()]
+		if !ok {
+			return fmt.Errorf(`%w: unsupported type "%s"`, model.InvalidAuthorizationDetails, detail.Type())
+		}
+
+		if err := detailType.ValidateDetail(detail); err != nil {
+			return fmt.Errorf(`%w: invalid element of type "%s": %s`, model.InvalidAuthorizationDetails, detail.Type(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Describe returns the descriptions of the elements of the authorization_details to be shown to the owner
+func (d DetailRegistry) Describe(details model.AuthorizationDetails) []string {
+	descriptions := make([]string, 0, len(details))
+
+	for _, detail := range details {
+		if detailType, ok := d[detail.Type()]; ok {
+			descriptions = append(descriptions, detailType.DescribeDetail(detail))
+		}
+	}
+
+	return descriptions
+}
+
+// Types returns the sorted identifiers of the registered types
+func (d DetailRegistry) Types() []string {
+	types := make([]string, 0, len(d))
+	for t := range d {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// _ "implement" constraint for CommonDetailType
+var _ DetailType = CommonDetailType{}
+
+// CommonDetailType type of authorization details that validates the common fields "actions" and "locations"
+// (RFC 9396 section 2.2) and the fields required by the type
+type CommonDetailType struct {
+	// Description human-readable name of the type (e.g. "Payment initiation")
+	Description string
+	// Actions allowed values of the "actions" field, any value is allowed if it is empty
+	Actions []string
+	// Locations allowed values of the "locations" field, any value is allowed if it is empty
+	Locations []string
+	// Required names of the fields required by the type (e.g. "instructedAmount")
+	Required []string
+}
+
+// ValidateDetail validates the common fields and the required fields of the element
+func (c CommonDetailType) ValidateDetail(detail model.AuthorizationDetail) error {
+	if err := validateAllowed(detail, "actions", c.Actions); err != nil {
+		return err
+	}
+
+	if err := validateAllowed(detail, "locations", c.Locations); err != nil {
+		return err
+	}
+
+	for _, field := range c.Required {
+		if _, ok := detail[field]; !ok {
+			return fmt.Errorf(`missing field "%s"`, field)
+		}
+	}
+
+	return nil
+}
+
+// DescribeDetail describes the element using the description of the type, the actions and the locations
+//
+// Example: "Payment initiation: initiate, status at https://example.com/payments"
+func (c CommonDetailType) DescribeDetail(detail model.AuthorizationDetail) string {
+	description := c.Description
+	if description == "" {
+		description = detail.Type()
+	}
+
+	if actions, _ := detail.Strings("actions"); len(actions) > 0 {
+		description += ": " + strings.Join(actions, ", ")
+	}
+
+	if locations, _ := detail.Strings("locations"); len(locations) > 0 {
+		description += " at " + strings.Join(locations, ", ")
+	}
+
+	return description
+}
+
+// validateAllowed validates that the values of an array field are contained in the allowed values
+func validateAllowed(detail model.AuthorizationDetail, field string, allowed []string) error {
+	values, err := detail.Strings(field)
+	if err != nil || len(allowed) == 0 {
+		return err
+	}
+
+	for _, v := range values {
+		if !containsString(allowed, v) {
+			return fmt.Errorf(`value "%s" is not allowed in the field "%s"`, v, field)
+		}
+	}
+
+	return nil
+}
+
+// containsString indicates if the slice contains the string
+func containsString(slice []string, str string) bool {
+	for _, v := range slice {
+		if v == str {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+package business
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestDetailRegistry_Validate checks the validation of the authorization_details against the registered types (RFC 9396)
+func TestDetailRegistry_Validate(t *testing.T) {
+	registry := DetailRegistry{
+		"payment_initiation": CommonDetailType{
+			Description: "Payment initiation",
+			Actions:     []string{"initiate", "status", "cancel"},
+			Required:    []string{"instructedAmount"},
+		},
+	}
+
+	tdt := []struct {
+		input       string
+		expectedErr error
+	}{
+		{
+			input: `[{"type": "payment_initiation", "actions": ["initiate"], "locations": ["https://example.com/payments"],
+				"instructedAmount": {"currency": "EUR", "amount": "500.00"}, "creditorAccount": {"iban": "DE02100100109307118603"}}]`,
+		},
+		// Unsupported type
+		{
+			input:       `[{"type": "account_information"}]`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+		// Action not allowed
+		{
+			input:       `[{"type": "payment_initiation", "actions": ["refund"], "instructedAmount": {}}]`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+		// Missing required field
+		{
+			input:       `[{"type": "payment_initiation", "actions": ["initiate"]}]`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+		// Malformed actions
+		{
+			input:       `[{"type": "payment_initiation", "actions": "initiate", "instructedAmount": {}}]`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+		// Missing type
+		{
+			input:       `[{"actions": ["initiate"]}]`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+		// Not an array
+		{
+			input:       `{"type": "payment_initiation"}`,
+			expectedErr: model.InvalidAuthorizationDetails,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			details, err := model.ParseAuthorizationDetails(v.input)
+			if err == nil {
+				err = registry.Validate(details)
+			}
+
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			t.Log(registry.Describe(details))
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package business
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// RedirectMatcher compares the redirect uris received in the requests against the redirect uris registered
+// by the clients
+//
+// Rules applied in order:
+//
+// 1. Exact string matching
+//
+// 2. Loopback interface redirection for native apps (RFC 8252 section 7.3), any port is accepted
+// if the registered uri uses the "127.0.0.1" or "[::1]" loopback address
+//
+// 3. Private-use URI schemes for native apps (RFC 8252 section 7.1) like "com.example.app:/callback",
+// the scheme is compared in a case-insensitive way
+//
+// 4. Wildcard subdomain patterns like "https://*.example.com/callback" (only if Wildcard is enabled)
+type RedirectMatcher struct {
+	// Wildcard enables the wildcard subdomain patterns
+	Wildcard bool
+}
+
+// Match indicates if the redirect uri matches some of the registered uris
+//
+// The redirect uris with fragment component are always rejected (RFC 6749 section 3.1.2)
+func (m RedirectMatcher) Match(registered []string, redirect string) bool {
+	redirectURL, err := url.Parse(redirect)
+	if err != nil || redirectURL.Fragment != "" || strings.Contains(redirect, "#") {
+		return false
+	}
+
+	for _, uri := range registered {
+		if uri == redirect {
+			return true
+		}
+
+		registeredURL, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case isLoopback(registeredURL):
+			if matchLoopback(registeredURL, redirectURL) {
+				return true
+			}
+
+		case isPrivateUseScheme(registeredURL.Scheme):
+			if strings.EqualFold(registeredURL.Scheme, redirectURL.Scheme) && registeredURL.Opaque == redirectURL.Opaque &&
+				registeredURL.Path == redirectURL.Path && registeredURL.RawQuery == redirectURL.RawQuery {
+				return true
+			}
+
+		case m.Wildcard && strings.HasPrefix(registeredURL.Host, "*."):
+			if matchWildcard(registeredURL, redirectURL) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isLoopback indicates if the uri uses the http scheme and a loopback ip literal as host
+//
+// Note: "localhost" is NOT considered loopback as RFC 8252 (section 8.3) recommends
+func isLoopback(uri *url.URL) bool {
+	if uri.Scheme != "http" {
+		return false
+	}
+
+	ip := net.ParseIP(uri.Hostname())
+	return ip != nil && (ip.Equal(net.IPv4(127, 0, 0, 1)) || ip.Equal(net.IPv6loopback))
+}
+
+// matchLoopback compares two loopback uris ignoring the port
+func matchLoopback(registered, redirect *url.URL) bool {
+	return redirect.Scheme == registered.Scheme &&
+		redirect.Hostname() == registered.Hostname() &&
+		redirect.Path == registered.Path &&
+		redirect.RawQuery == registered.RawQuery
+}
+
+// isPrivateUseScheme indicates if the scheme is a private-use scheme based on a reverse domain name
+// (e.g. "com.example.app")
+func isPrivateUseScheme(scheme string) bool {
+	return strings.Contains(scheme, ".")
+}
+
+// matchWildcard compares the redirect uri against a registered uri that contains a wildcard subdomain,
+// the wildcard only matches a single label and only https uris are accepted
+func matchWildcard(registered, redirect *url.URL) bool {
+	if registered.Scheme != "https" || redirect.Scheme != "https" {
+		return false
+	}
+
+	if redirect.Port() != registered.Port() || redirect.Path != registered.Path || redirect.RawQuery != registered.RawQuery {
+		return false
+	}
+
+	host := strings.ToLower(redirect.Hostname())
+	suffix := strings.ToLower(strings.TrimPrefix(registered.Hostname(), "*"))
+
+	if !strings.HasSuffix(host, suffix) {
+		return false
+	}
+
+	label := strings.TrimSuffix(host, suffix)
+
+	return label != "" && !strings.Contains(label, ".")
+}
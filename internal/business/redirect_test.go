@@ -0,0 +1,99 @@
+package business
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestRedirectMatcher_Match checks the matching rules of redirect uris (exact, loopback, private-use schemes and wildcards)
+func TestRedirectMatcher_Match(t *testing.T) {
+	tdt := []struct {
+		matcher    RedirectMatcher
+		registered []string
+		redirect   string
+		expected   bool
+	}{
+		// Exact matching
+		{
+			registered: []string{"https://goauth.com/callback"},
+			redirect:   "https://goauth.com/callback",
+			expected:   true,
+		},
+		// Path traversal is not normalized
+		{
+			registered: []string{"https://goauth.com/callback"},
+			redirect:   "https://goauth.com/callback/../callback",
+		},
+		// Trailing slash does not match
+		{
+			registered: []string{"https://goauth.com/callback"},
+			redirect:   "https://goauth.com/callback/",
+		},
+		// Fragments are rejected
+		{
+			registered: []string{"https://goauth.com/callback#fragment"},
+			redirect:   "https://goauth.com/callback#fragment",
+		},
+		// Loopback IPv4 with any port
+		{
+			registered: []string{"http://127.0.0.1/callback"},
+			redirect:   "http://127.0.0.1:51004/callback",
+			expected:   true,
+		},
+		// Loopback IPv6 with any port
+		{
+			registered: []string{"http://[::1]/callback"},
+			redirect:   "http://[::1]:8080/callback",
+			expected:   true,
+		},
+		// Loopback with different path
+		{
+			registered: []string{"http://127.0.0.1/callback"},
+			redirect:   "http://127.0.0.1:51004/other",
+		},
+		// localhost is not loopback
+		{
+			registered: []string{"http://localhost/callback"},
+			redirect:   "http://localhost:8080/callback",
+		},
+		// Private-use URI scheme
+		{
+			registered: []string{"com.example.app:/oauth2redirect"},
+			redirect:   "COM.EXAMPLE.APP:/oauth2redirect",
+			expected:   true,
+		},
+		// Wildcard disabled
+		{
+			registered: []string{"https://*.goauth.com/callback"},
+			redirect:   "https://tenant.goauth.com/callback",
+		},
+		// Wildcard enabled
+		{
+			matcher:    RedirectMatcher{Wildcard: true},
+			registered: []string{"https://*.goauth.com/callback"},
+			redirect:   "https://tenant.goauth.com/callback",
+			expected:   true,
+		},
+		// Wildcard matches a single label
+		{
+			matcher:    RedirectMatcher{Wildcard: true},
+			registered: []string{"https://*.goauth.com/callback"},
+			redirect:   "https://evil.tenant.goauth.com/callback",
+		},
+		// Wildcard does not match other domains
+		{
+			matcher:    RedirectMatcher{Wildcard: true},
+			registered: []string{"https://*.goauth.com/callback"},
+			redirect:   "https://tenant.evilgoauth.com/callback",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			got := v.matcher.Match(v.registered, v.redirect)
+			if got != v.expected {
+				t.Fatalf(`expected "%v" got "%v" for "%s" in %v`, v.expected, got, v.redirect, v.registered)
+			}
+		})
+	}
+}
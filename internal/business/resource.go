@@ -0,0 +1,238 @@
+package business
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// ResourceRegistry protected resources known by the authorization server indexed by identifier (RFC 8707)
+type ResourceRegistry map[string]model.Resource
+
+// Resolve validates the resource indicators and returns the registered resources
+//
+// The invalid or unknown resource indicators are rejected with model.InvalidTarget
+func (r ResourceRegistry) Resolve(identifiers []string) ([]model.Resource, error) {
+	resources := make([]model.Resource, 0, len(identifiers))
+
+	for _, identifier := range identifiers {
+		if !model.IsValidResource(identifier) {
+			return nil, fmt.Errorf(`%w: resource "%s" must be an absolute URI without fragment`, model.InvalidTarget, identifier)
+		}
+
+		resource, ok := r[identifier]
+		if !ok {
+			return nil, fmt.Errorf(`%w: unknown resource "%s"`, model.InvalidTarget, identifier)
+		}
+
+		resource.Identifier = identifier
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// ValidateScope validates that the scope can be granted for some of the resources
+func (r ResourceRegistry) ValidateScope(scope interface{}, resources []model.Resource) error {
+	if len(resources) == 0 {
+		return nil
+	}
+
+	mask, err := newMask(scope)
+	if err != nil {
+		return err
+	}
+
+	allowed := model.Mask{}
+
+	for _, resource := range resources {
+		// The resources without scopes accept any scope
+		if resource.Scopes == nil {
+			return nil
+		}
+
+		for k, bits := range resource.Scopes {
+			allowed[k] |= bits
+		}
+	}
+
+	if !isSubset(mask, allowed) {
+		return fmt.Errorf("%w: scope is not allowed for the requested resources", model.InvalidScope)
+	}
+
+	return nil
+}
+
+// _ "implement" constraint for ResourceGenerator
+var _ TokenGenerator = ResourceGenerator{}
+
+// ResourceGenerator generates the tokens with the format, lifetime, scopes and signing key of the resource
+// identified by the "aud" claim, the tokens for audiences that are not registered resources are generated
+// by the Default generator
+//
+// The opaque and the PASETO tokens are generated by the Opaque and the Paseto generators for the resources
+// and the clients whose format is model.OpaqueFormat or model.PasetoFormat
+type ResourceGenerator struct {
+	// Resources registered protected resources
+	Resources ResourceRegistry
+	// Default generator used for the audiences that are not resources and the resources without KeyId
+	Default TokenGenerator
+	// Keys generators indexed by the KeyId of the resources
+	Keys map[string]TokenGenerator
+	// Opaque generator of the opaque tokens (Optional)
+	Opaque TokenGenerator
+	// Paseto generator of the PASETO tokens (Optional)
+	Paseto TokenGenerator
+	// Clients finder of the clients used to select the token format of the audiences that are clients (Optional)
+	Clients repository.Finder
+	// SessionStorage store for the claims of the encrypted tokens, the authorization server cannot decrypt them,
+	// so the claims are saved to introspect them (Optional)
+	SessionStorage repository.Storage
+}
+
+// GenerateToken generates the token of the model.JWT received as parameter
+func (r ResourceGenerator) GenerateToken(i interface{}) (model.Token, error) {
+	claims := i.(model.JWT)
+
+	resource, ok := r.Resources[claims.Audience]
+	if !ok {
+		format, err := r.clientFormat(claims.Audience)
+		if err != nil {
+			return model.Token{}, err
+		}
+
+		if generator, ok := r.formatGenerator(format); ok {
+			return generator.GenerateToken(claims)
+		}
+
+		return r.Default.GenerateToken(claims)
+	}
+
+	generator := r.Default
+
+	switch resource.Format {
+	case "", model.JWTFormat:
+		if resource.KeyId != "" {
+			generator, ok = r.Keys[resource.KeyId]
+			if !ok {
+				return model.Token{}, fmt.Errorf(`missing key "%s" of resource "%s"`, resource.KeyId, claims.Audience)
+			}
+		}
+
+	default:
+		generator, ok = r.formatGenerator(resource.Format)
+		if !ok {
+			return model.Token{}, fmt.Errorf(`unsupported token format "%s" of resource "%s"`, resource.Format, claims.Audience)
+		}
+	}
+
+	// The token only carries the scopes of the resource
+	if resource.Scopes != nil && claims.Scope != nil {
+		mask, err := newMask(claims.Scope)
+		if err != nil {
+			return model.Token{}, err
+		}
+
+		claims.Scope = intersectMask(mask, resource.Scopes)
+	}
+
+	if resource.LifeTime > 0 && claims.ExpiresAt == 0 {
+		issuedAt := time.Now()
+		if claims.IssuedAt != 0 {
+			issuedAt = time.Unix(claims.IssuedAt, 0)
+		}
+
+		claims.ExpiresAt = issuedAt.Add(resource.LifeTime).Unix()
+	}
+
+	tkn, err := generator.GenerateToken(claims)
+	if err != nil {
+		return tkn, err
+	}
+
+	if r.SessionStorage != nil && isEncrypted(tkn.AccessToken) {
+		if err = saveClaims(r.SessionStorage, tkn.AccessToken, claims); err != nil {
+			return model.Token{}, err
+		}
+	}
+
+	if claims.ExpiresAt != 0 {
+		tkn.ExpiresIn = int64(time.Until(time.Unix(claims.ExpiresAt, 0)) / time.Second)
+	}
+
+	return tkn, nil
+}
+
+// formatGenerator returns the generator of the tokens with the opaque or the PASETO format, false is returned if
+// the format is not supported
+func (r ResourceGenerator) formatGenerator(format string) (TokenGenerator, bool) {
+	var generator TokenGenerator
+
+	switch format {
+	case model.OpaqueFormat:
+		generator = r.Opaque
+	case model.PasetoFormat:
+		generator = r.Paseto
+	}
+
+	return generator, generator != nil
+}
+
+// clientFormat returns the token format of the client identified by the audience, the audiences that
+// are not clients use the default format
+func (r ResourceGenerator) clientFormat(audience string) (string, error) {
+	if r.Clients == nil || audience == "" {
+		return "", nil
+	}
+
+	i, err := r.Clients.Find(audience)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return i.(model.Client).TokenFormat, nil
+}
+
+// intersectMask returns the permissions contained in both masks
+func intersectMask(mask, other model.Mask) model.Mask {
+	intersection := model.Mask{}
+
+	for k, bits := range mask {
+		if bits &= other[k]; bits != 0 {
+			intersection[k] = bits
+		}
+	}
+
+	return intersection
+}
+
+// selectResource returns the resource indicator used as audience of a token, the resource requested in the
+// token request must be one of the authorized resources (RFC 8707 section 2.2)
+func selectResource(authorized, requested []string) (string, error) {
+	switch {
+	case len(requested) > 1:
+		return "", fmt.Errorf("%w: only one resource can be requested per token", model.InvalidTarget)
+
+	case len(requested) == 1:
+		if !containsString(authorized, requested[0]) {
+			return "", fmt.Errorf(`%w: resource "%s" was not authorized`, model.InvalidTarget, requested[0])
+		}
+
+		return requested[0], nil
+
+	case len(authorized) == 1:
+		return authorized[0], nil
+
+	case len(authorized) > 1:
+		return "", fmt.Errorf("%w: the resource must be indicated in the token request", model.InvalidTarget)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,228 @@
+package business
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestResourceRegistry_Resolve checks the validation of the resource indicators and of the scopes
+// allowed for the resources (RFC 8707)
+func TestResourceRegistry_Resolve(t *testing.T) {
+	registry := ResourceRegistry{
+		"https://orders.example.com": {Scopes: model.Mask{"orders": 0x0f}},
+		"https://users.example.com":  {Scopes: model.Mask{"users": 0x01}},
+		"urn:example:reports":        {},
+	}
+
+	tdt := []struct {
+		resources   []string
+		scope       model.Mask
+		expectedErr error
+	}{
+		{
+			resources: []string{"https://orders.example.com"},
+			scope:     model.Mask{"orders": 0x03},
+		},
+		// The scope is allowed by one of the resources
+		{
+			resources: []string{"https://orders.example.com", "https://users.example.com"},
+			scope:     model.Mask{"orders": 0x01, "users": 0x01},
+		},
+		// The resource accepts any scope
+		{
+			resources: []string{"urn:example:reports"},
+			scope:     model.Mask{"admin": 0xff},
+		},
+		// The scope is not allowed for the resource
+		{
+			resources:   []string{"https://orders.example.com"},
+			scope:       model.Mask{"orders": 0x10},
+			expectedErr: model.InvalidScope,
+		},
+		// Unknown resource
+		{
+			resources:   []string{"https://payments.example.com"},
+			expectedErr: model.InvalidTarget,
+		},
+		// Relative URI
+		{
+			resources:   []string{"/orders"},
+			expectedErr: model.InvalidTarget,
+		},
+		// URI with fragment
+		{
+			resources:   []string{"https://orders.example.com#orders"},
+			expectedErr: model.InvalidTarget,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			resources, err := registry.Resolve(v.resources)
+			if err == nil {
+				err = registry.ValidateScope(v.scope, resources)
+			}
+
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+		})
+	}
+}
+
+// TestResourceGenerator_GenerateToken checks that the tokens are generated with the scopes, lifetime
+// and signing key of the resource identified by the audience
+func TestResourceGenerator_GenerateToken(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := JWTGenerator{KeyId: "orders"}
+
+	err = key.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := ResourceRegistry{
+		"https://orders.example.com": {
+			Scopes:   model.Mask{"orders": 0x0f},
+			LifeTime: 15 * time.Minute,
+			KeyId:    "orders",
+		},
+		"https://reports.example.com": {Format: "opaque"},
+	}
+
+	resourceGenerator := ResourceGenerator{
+		Resources: resources,
+		Default:   generator,
+		Keys:      map[string]TokenGenerator{"orders": key},
+	}
+
+	tdt := []struct {
+		audience          string
+		expectedScope     model.Mask
+		expectedExpiresIn int64
+		expectedErr       bool
+	}{
+		{
+			audience:          "https://orders.example.com",
+			expectedScope:     model.Mask{"orders": 0x03},
+			expectedExpiresIn: int64(15 * time.Minute / time.Second),
+		},
+		// The audience is not a resource
+		{
+			audience:      "mobile",
+			expectedScope: model.Mask{"orders": 0x03, "users": 0x01},
+		},
+		// Unsupported token format
+		{
+			audience:    "https://reports.example.com",
+			expectedErr: true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			token, err := resourceGenerator.GenerateToken(model.JWT{
+				Scope: model.Mask{"orders": 0x03, "users": 0x01},
+				StandardClaims: model.StandardClaims{
+					Audience: v.audience,
+					IssuedAt: time.Now().Unix(),
+				},
+			})
+			if (err != nil) != v.expectedErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			claims, err := generator.ParseToken(token.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scope, err := newMask(claims.Scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(scope) != len(v.expectedScope) || !isSubset(scope, v.expectedScope) {
+				t.Fatalf("expected scope %v got %v", v.expectedScope, scope)
+			}
+
+			// The rounding of the seconds is tolerated
+			if token.ExpiresIn < v.expectedExpiresIn-1 || token.ExpiresIn > v.expectedExpiresIn {
+				t.Fatalf("expected expires_in %d got %d", v.expectedExpiresIn, token.ExpiresIn)
+			}
+		})
+	}
+}
+
+// TestSelectResource checks that the resource requested in the token request must be one of the resources
+// of the authorization request (RFC 8707 section 2.2)
+func TestSelectResource(t *testing.T) {
+	tdt := []struct {
+		authorized       []string
+		requested        []string
+		expectedResource string
+		expectedErr      error
+	}{
+		{
+			authorized:       []string{"https://orders.example.com", "https://reports.example.com"},
+			requested:        []string{"https://orders.example.com"},
+			expectedResource: "https://orders.example.com",
+		},
+		// The only authorized resource is used by default
+		{
+			authorized:       []string{"https://orders.example.com"},
+			expectedResource: "https://orders.example.com",
+		},
+		// No resources
+		{},
+		// The resource was not authorized
+		{
+			authorized:  []string{"https://orders.example.com"},
+			requested:   []string{"https://reports.example.com"},
+			expectedErr: model.InvalidTarget,
+		},
+		// The authorization request did not indicate resources
+		{
+			requested:   []string{"https://orders.example.com"},
+			expectedErr: model.InvalidTarget,
+		},
+		// The resource must be indicated if several resources were authorized
+		{
+			authorized:  []string{"https://orders.example.com", "https://reports.example.com"},
+			expectedErr: model.InvalidTarget,
+		},
+		// Only one resource per token
+		{
+			authorized:  []string{"https://orders.example.com", "https://reports.example.com"},
+			requested:   []string{"https://orders.example.com", "https://reports.example.com"},
+			expectedErr: model.InvalidTarget,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			resource, err := selectResource(v.authorized, v.requested)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if resource != v.expectedResource {
+				t.Fatalf(`expected resource "%s" got "%s"`, v.expectedResource, resource)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package business
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// Entitler obtains the scope to which an owner is entitled
+type Entitler interface {
+	// Entitle returns the bits of the mask per resource granted to the owner, it is never nil
+	Entitle(ownerId string) (model.Mask, error)
+}
+
+// AccessManager manages the roles, the groups and the assignments of the owners
+type AccessManager interface {
+	// SaveRole creates or replaces a model.Role
+	SaveRole(model.Role) error
+	// ObtainRole search a model.Role by id
+	ObtainRole(string) (model.Role, error)
+	// DeleteRole removes a model.Role by id
+	DeleteRole(string) error
+	// SaveGroup creates or replaces a model.Group, every role of the group must exist
+	SaveGroup(model.Group) error
+	// ObtainGroup search a model.Group by id
+	ObtainGroup(string) (model.Group, error)
+	// DeleteGroup removes a model.Group by id
+	DeleteGroup(string) error
+	// SaveAssignment creates or replaces the model.Assignment of an owner, every role and group must exist
+	SaveAssignment(model.Assignment) error
+	// ObtainAssignment search the model.Assignment of an owner
+	ObtainAssignment(string) (model.Assignment, error)
+	// DeleteAssignment removes the model.Assignment of an owner
+	DeleteAssignment(string) error
+}
+
+// _ "implement" constraints for AccessControl
+var (
+	_ Entitler      = AccessControl{}
+	_ AccessManager = AccessControl{}
+)
+
+// AccessControl derives the scope of the owners from the roles assigned to them directly or through groups
+type AccessControl struct {
+	// Roles store of model.Role indexed by role id
+	Roles repository.UpdaterStorage
+	// Groups store of model.Group indexed by group id
+	Groups repository.UpdaterStorage
+	// Assignments store of model.Assignment indexed by owner id
+	Assignments repository.UpdaterStorage
+}
+
+// Entitle returns the union of the scopes of the roles assigned to the owner and the roles of its groups
+//
+// The owners without assignment are entitled to nothing, the missing roles and groups are ignored
+// because they could be deleted after being assigned
+func (a AccessControl) Entitle(ownerId string) (model.Mask, error) {
+	entitled := model.Mask{}
+
+	assignment, err := a.ObtainAssignment(ownerId)
+	if _, ok := err.(model.NotFound); ok {
+		return entitled, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := assignment.Roles
+
+	for _, groupId := range assignment.Groups {
+		group, err := a.ObtainGroup(groupId)
+		if _, ok := err.(model.NotFound); ok {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, group.Roles...)
+	}
+
+	for _, roleId := range roles {
+		role, err := a.ObtainRole(roleId)
+		if _, ok := err.(model.NotFound); ok {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for k, bits := range role.Scopes {
+			entitled[k] |= bits
+		}
+	}
+
+	return entitled, nil
+}
+
+// SaveRole validates and creates or replaces a model.Role
+func (a AccessControl) SaveRole(role model.Role) error {
+	if role.Id == "" {
+		return fmt.Errorf("%w: missing role id", model.InvalidRequest)
+	}
+
+	return saveRecord(a.Roles, role.Id, role)
+}
+
+// ObtainRole search a model.Role by id, if the role does not exist an error of type model.NotFound is returned
+func (a AccessControl) ObtainRole(roleId string) (model.Role, error) {
+	i, err := obtainRecord(a.Roles, roleId, "role")
+	if err != nil {
+		return model.Role{}, err
+	}
+
+	return i.(model.Role), nil
+}
+
+// DeleteRole removes a model.Role by id
+func (a AccessControl) DeleteRole(roleId string) error {
+	return a.Roles.Delete(roleId)
+}
+
+// SaveGroup validates and creates or replaces a model.Group
+func (a AccessControl) SaveGroup(group model.Group) error {
+	if group.Id == "" {
+		return fmt.Errorf("%w: missing group id", model.InvalidRequest)
+	}
+
+	for _, roleId := range group.Roles {
+		if err := a.validateReference(a.Roles, roleId, "role"); err != nil {
+			return err
+		}
+	}
+
+	return saveRecord(a.Groups, group.Id, group)
+}
+
+// ObtainGroup search a model.Group by id, if the group does not exist an error of type model.NotFound is returned
+func (a AccessControl) ObtainGroup(groupId string) (model.Group, error) {
+	i, err := obtainRecord(a.Groups, groupId, "group")
+	if err != nil {
+		return model.Group{}, err
+	}
+
+	return i.(model.Group), nil
+}
+
+// DeleteGroup removes a model.Group by id
+func (a AccessControl) DeleteGroup(groupId string) error {
+	return a.Groups.Delete(groupId)
+}
+
+// SaveAssignment validates and creates or replaces the model.Assignment of an owner
+func (a AccessControl) SaveAssignment(assignment model.Assignment) error {
+	if assignment.Owner == "" {
+		return fmt.Errorf("%w: missing owner id", model.InvalidRequest)
+	}
+
+	for _, roleId := range assignment.Roles {
+		if err := a.validateReference(a.Roles, roleId, "role"); err != nil {
+			return err
+		}
+	}
+
+	for _, groupId := range assignment.Groups {
+		if err := a.validateReference(a.Groups, groupId, "group"); err != nil {
+			return err
+		}
+	}
+
+	return saveRecord(a.Assignments, assignment.Owner, assignment)
+}
+
+// ObtainAssignment search the model.Assignment of an owner, if it does not exist an error of type model.NotFound is returned
+func (a AccessControl) ObtainAssignment(ownerId string) (model.Assignment, error) {
+	i, err := obtainRecord(a.Assignments, ownerId, "assignment")
+	if err != nil {
+		return model.Assignment{}, err
+	}
+
+	return i.(model.Assignment), nil
+}
+
+// DeleteAssignment removes the model.Assignment of an owner
+func (a AccessControl) DeleteAssignment(ownerId string) error {
+	return a.Assignments.Delete(ownerId)
+}
+
+// validateReference validates that the referenced record exists, the missing records are reported as model.InvalidRequest
+func (AccessControl) validateReference(storage repository.Storage, id, kind string) error {
+	_, err := obtainRecord(storage, id, kind)
+	if _, ok := err.(model.NotFound); ok {
+		return fmt.Errorf(`%w: %s "%s" does not exist`, model.InvalidRequest, kind, id)
+	}
+
+	return err
+}
+
+// saveRecord replaces the record if it exists, otherwise the record is created
+func saveRecord(storage repository.UpdaterStorage, id string, i interface{}) error {
+	err := storage.Update(id, i)
+	if _, ok := err.(model.NotFound); ok {
+		return storage.Create(id, i)
+	}
+
+	return err
+}
+
+// obtainRecord search a record by id, the missing records are reported as model.NotFound
+func obtainRecord(storage repository.Storage, id, kind string) (interface{}, error) {
+	i, err := storage.Obtain(id)
+	if err == redis.Nil {
+		err = model.NotFound(fmt.Sprintf(`%s "%s" does not exist`, kind, id))
+	}
+
+	return i, err
+}
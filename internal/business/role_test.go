@@ -0,0 +1,207 @@
+package business
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// newTestAccessControl builds an AccessControl with the roles "reader", "writer" and "support",
+// the group "staff" and the assignments of the owners "alice", "bob" and "carol"
+func newTestAccessControl() AccessControl {
+	return AccessControl{
+		Roles: repository.NewMockStorage(map[string]interface{}{
+			"reader":  model.Role{Id: "reader", Scopes: model.Mask{"orders": 0x01}},
+			"writer":  model.Role{Id: "writer", Scopes: model.Mask{"orders": 0x02}},
+			"support": model.Role{Id: "support", Scopes: model.Mask{"users": 0x01}},
+		}),
+		Groups: repository.NewMockStorage(map[string]interface{}{
+			"staff": model.Group{Id: "staff", Roles: []string{"writer", "support"}},
+		}),
+		Assignments: repository.NewMockStorage(map[string]interface{}{
+			"alice": model.Assignment{Owner: "alice", Roles: []string{"reader"}},
+			"bob":   model.Assignment{Owner: "bob", Roles: []string{"reader"}, Groups: []string{"staff"}},
+			"carol": model.Assignment{Owner: "carol", Roles: []string{"deleted"}, Groups: []string{"deleted"}},
+		}),
+	}
+}
+
+// TestAccessControl_Entitle checks that the owners are entitled to the union of the scopes of their roles
+// and the roles of their groups
+func TestAccessControl_Entitle(t *testing.T) {
+	access := newTestAccessControl()
+
+	tdt := []struct {
+		owner  string
+		output model.Mask
+	}{
+		// Direct role
+		{
+			owner:  "alice",
+			output: model.Mask{"orders": 0x01},
+		},
+		// Direct role and roles of a group
+		{
+			owner:  "bob",
+			output: model.Mask{"orders": 0x03, "users": 0x01},
+		},
+		// Missing role and group
+		{
+			owner:  "carol",
+			output: model.Mask{},
+		},
+		// Owner without assignment
+		{
+			owner:  "dave",
+			output: model.Mask{},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			entitled, err := access.Entitle(v.owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v.output, entitled) {
+				t.Fatalf(`expected scope "%+v" got "%+v"`, v.output, entitled)
+			}
+		})
+	}
+}
+
+// TestAccessControl_SaveAssignment checks that the assignments can only reference existing roles and groups
+func TestAccessControl_SaveAssignment(t *testing.T) {
+	access := newTestAccessControl()
+
+	tdt := []struct {
+		assignment  model.Assignment
+		expectedErr error
+	}{
+		{
+			assignment: model.Assignment{Owner: "dave", Roles: []string{"reader"}, Groups: []string{"staff"}},
+		},
+		// Replaces the assignment
+		{
+			assignment: model.Assignment{Owner: "alice", Groups: []string{"staff"}},
+		},
+		// Unknown role
+		{
+			assignment:  model.Assignment{Owner: "dave", Roles: []string{"admin"}},
+			expectedErr: model.InvalidRequest,
+		},
+		// Unknown group
+		{
+			assignment:  model.Assignment{Owner: "dave", Groups: []string{"admins"}},
+			expectedErr: model.InvalidRequest,
+		},
+		// Missing owner
+		{
+			assignment:  model.Assignment{Roles: []string{"reader"}},
+			expectedErr: model.InvalidRequest,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			err := access.SaveAssignment(v.assignment)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			assignment, err := access.ObtainAssignment(v.assignment.Owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v.assignment, assignment) {
+				t.Fatalf(`expected assignment "%+v" got "%+v"`, v.assignment, assignment)
+			}
+		})
+	}
+}
+
+// TestAuthorizationCodeGrant_grantScope checks that the granted scope is requested ∩ client-allowed ∩ owner-entitled
+func TestAuthorizationCodeGrant_grantScope(t *testing.T) {
+	clients := repository.MockClientFinder{
+		"mobile": model.Client{
+			Id:            "mobile",
+			AllowedScopes: model.Mask{"orders": 0x03, "users": 0x01},
+		},
+	}
+
+	tdt := []struct {
+		policy      ScopePolicy
+		owner       string
+		requested   string
+		output      string
+		expectedErr error
+	}{
+		// The scope is allowed and entitled
+		{
+			owner:     "bob",
+			requested: "orders:3",
+			output:    "orders:3",
+		},
+		// The owner is not entitled to the scope
+		{
+			owner:       "alice",
+			requested:   "orders:3",
+			expectedErr: model.InvalidScope,
+		},
+		// The scope is narrowed to the entitled scope
+		{
+			policy:    NarrowScope,
+			owner:     "alice",
+			requested: "orders:3 users:1",
+			output:    "orders:1",
+		},
+		// The scope is narrowed to the allowed and entitled scope
+		{
+			policy:    NarrowScope,
+			owner:     "bob",
+			requested: "orders:f users:1",
+			output:    "orders:3 users:1",
+		},
+		// The owner is not entitled to any scope
+		{
+			policy:      NarrowScope,
+			owner:       "dave",
+			requested:   "orders:1",
+			expectedErr: model.InvalidScope,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			grant := AuthorizationCodeGrant{
+				ScopeParser: NewScopeParser(),
+				Clients:     clients,
+				Entitler:    newTestAccessControl(),
+				ScopePolicy: v.policy,
+			}
+
+			scope, err := grant.grantScope("mobile", v.owner, v.requested)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if v.output != scope {
+				t.Fatalf(`expected scope "%s" got "%s"`, v.output, scope)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package business
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,11 +19,55 @@ func NewScopeParser() ScopeParser {
 	return maskParser{}
 }
 
+// NewNamedScopeParser builds a ScopeParser that accepts the named scopes of the registry and the
+// "resource:hexmask" pairs
+func NewNamedScopeParser(registry ScopeRegistry) ScopeParser {
+	return maskParser{Scopes: registry}
+}
+
+// ScopeRegistry named scopes indexed by name
+type ScopeRegistry map[string]model.ScopeDefinition
+
+// Names returns the sorted names of the registered scopes
+func (s ScopeRegistry) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns the descriptions of the named scopes contained in the scope to be shown to the owner,
+// the "resource:hexmask" pairs are described as they are
+func (s ScopeRegistry) Describe(scope string) []string {
+	descriptions := make([]string, 0)
+
+	for _, v := range strings.Fields(scope) {
+		definition, ok := s[v]
+		if !ok || definition.Description == "" {
+			descriptions = append(descriptions, v)
+			continue
+		}
+
+		descriptions = append(descriptions, definition.Description)
+	}
+
+	return descriptions
+}
+
 // maskParser parse scopes (permissions) to a mask
-type maskParser struct{}
+type maskParser struct {
+	// Scopes named scopes accepted by the parser (Optional)
+	Scopes ScopeRegistry
+}
 
-// ParseScope parses a string of hexadecimal values split by spaces to hash map where each key of hash map contains
+// ParseScope parses a string of scopes split by spaces to hash map where each key of hash map contains
 // unsigned integer values of 64 bits to be used as bit masks
+//
+// Each scope is a registered name (e.g. "orders.read") or a pair of resource and hexadecimal value
+// (e.g. "orders:0f"), both forms can be mixed and the bits of the same resource are combined
 func (m maskParser) ParseScope(str string) (interface{}, error) {
 	if str == "" {
 		return nil, nil
@@ -30,20 +77,197 @@ func (m maskParser) ParseScope(str string) (interface{}, error) {
 
 	mask := model.Mask{}
 
-	var err error
-
 	for _, v := range slice {
+		if definition, ok := m.Scopes[v]; ok {
+			mask[definition.Resource] |= definition.Bits
+			continue
+		}
+
 		slice := strings.Split(v, ":")
 
 		if len(slice) != 2 {
+			if m.Scopes != nil && !strings.Contains(v, ":") {
+				return nil, fmt.Errorf(`%w: unknown scope "%s"`, model.InvalidScope, v)
+			}
+
 			return nil, fmt.Errorf("%w: malformed permission requested", model.InvalidScope)
 		}
 
-		mask[slice[0]], err = strconv.ParseUint(slice[1], 16, 64)
+		bits, err := strconv.ParseUint(slice[1], 16, 64)
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", model.InvalidScope, err.Error())
 		}
+
+		mask[slice[0]] |= bits
 	}
 
 	return mask, nil
 }
+
+// ScopePolicy defines how the requested scopes that are not allowed for a client are handled
+type ScopePolicy uint
+
+// Supported values for ScopePolicy
+const (
+	// RejectScope rejects the requests that contain scopes not allowed for the client
+	RejectScope ScopePolicy = iota
+	// NarrowScope removes the scopes not allowed for the client from the request
+	NarrowScope
+)
+
+// Apply returns the scope granted to the client, if the allowed mask is nil the requested scope is granted
+func (p ScopePolicy) Apply(requested, allowed model.Mask) (model.Mask, error) {
+	if allowed == nil || isSubset(requested, allowed) {
+		return requested, nil
+	}
+
+	if p != NarrowScope {
+		return nil, fmt.Errorf("%w: scope is not allowed for the client", model.InvalidScope)
+	}
+
+	granted := intersectMask(requested, allowed)
+	if len(granted) == 0 {
+		return nil, fmt.Errorf("%w: none of the requested scopes is allowed for the client", model.InvalidScope)
+	}
+
+	return granted, nil
+}
+
+// scopeGranter applies the same scope rules in every grant: the granted scope is
+// requested ∩ client-allowed ∩ owner-entitled, the scopes not allowed for the client or not entitled to
+// the owner are handled by the ScopePolicy
+type scopeGranter struct {
+	// ScopeParser parses a scope from string
+	ScopeParser
+	// Clients finder of the clients used to obtain their allowed and default scopes (Optional)
+	Clients repository.Finder
+	// Entitler obtains the scope to which the owners are entitled through their roles and groups (Optional)
+	Entitler Entitler
+	// ScopePolicy defines if the scopes not allowed for the client or the owner are rejected or removed
+	ScopePolicy ScopePolicy
+}
+
+// grant returns the scope granted to the client on behalf of the owner, if the client does not request
+// a scope its default scope is used
+//
+// The entitlements are only evaluated if the owner is known, the narrowed scope is returned as
+// "resource:hexmask" pairs so it can be saved and parsed again
+func (s scopeGranter) grant(clientId, ownerId, requested string) (string, error) {
+	client, err := s.client(clientId)
+	if err != nil {
+		return "", err
+	}
+
+	if requested == "" {
+		requested = client.DefaultScope
+	}
+
+	i, err := s.ParseScope(requested)
+	if err != nil || i == nil {
+		return requested, err
+	}
+
+	mask, err := newMask(i)
+	if err != nil {
+		return "", err
+	}
+
+	granted, err := s.narrow(client, ownerId, mask)
+	if err != nil {
+		return "", err
+	}
+
+	if len(granted) != len(mask) || !isSubset(mask, granted) {
+		return formatMask(granted), nil
+	}
+
+	return requested, nil
+}
+
+// narrow applies the scopes allowed for the client and the scopes entitled to the owner to the mask
+func (s scopeGranter) narrow(client model.Client, ownerId string, mask model.Mask) (model.Mask, error) {
+	granted, err := s.ScopePolicy.Apply(mask, client.AllowedScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Entitler == nil || ownerId == "" {
+		return granted, nil
+	}
+
+	entitled, err := s.Entitler.Entitle(ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	granted, err = s.ScopePolicy.Apply(granted, entitled)
+	if err != nil {
+		return nil, fmt.Errorf("%w: scope is not entitled to the owner", model.InvalidScope)
+	}
+
+	return granted, nil
+}
+
+// client obtains the client, if the Clients are not defined or the client is not identified (e.g. JWT Bearer
+// requests without client_id) the client does not have scope restrictions
+func (s scopeGranter) client(clientId string) (model.Client, error) {
+	if s.Clients == nil || clientId == "" {
+		return model.Client{Id: clientId}, nil
+	}
+
+	return findClient(s.Clients, clientId)
+}
+
+// formatMask formats the mask as "resource:hexmask" pairs sorted by resource and split by spaces
+func formatMask(mask model.Mask) string {
+	pairs := make([]string, 0, len(mask))
+
+	for resource, bits := range mask {
+		pairs = append(pairs, resource+":"+strconv.FormatUint(bits, 16))
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// newMask converts the scope of a token to model.Mask, the scope could be a model.Mask or the
+// decoded "scp" claim of a parsed token
+func newMask(scope interface{}) (model.Mask, error) {
+	switch scope := scope.(type) {
+	case nil:
+		return model.Mask{}, nil
+	case model.Mask:
+		return scope, nil
+	case map[string]interface{}:
+		mask := make(model.Mask, len(scope))
+
+		for k, v := range scope {
+			number, ok := v.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("%w: malformed scope", model.InvalidScope)
+			}
+
+			bits, err := strconv.ParseUint(number.String(), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", model.InvalidScope, err.Error())
+			}
+
+			mask[k] = bits
+		}
+
+		return mask, nil
+	}
+
+	return nil, fmt.Errorf("%w: malformed scope", model.InvalidScope)
+}
+
+// isSubset indicates if all the permissions of the mask are contained in the superset
+func isSubset(mask, superset model.Mask) bool {
+	for k, bits := range mask {
+		if bits&^superset[k] != 0 {
+			return false
+		}
+	}
+
+	return true
+}
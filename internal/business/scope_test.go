@@ -67,3 +67,123 @@ func TestMaskParser_ParseScope(t *testing.T) {
 		})
 	}
 }
+
+// TestMaskParser_ParseScope_Named checks that the named scopes and the "resource:hexmask" pairs produce the same mask
+func TestMaskParser_ParseScope_Named(t *testing.T) {
+	parser := NewNamedScopeParser(ScopeRegistry{
+		"orders.read":  {Name: "orders.read", Resource: "orders", Bits: 0x01, Description: "Read your orders"},
+		"orders.write": {Name: "orders.write", Resource: "orders", Bits: 0x02, Description: "Create orders"},
+		"profile":      {Name: "profile", Resource: "users", Bits: 0x10},
+	})
+
+	tdt := []struct {
+		input       string
+		output      interface{}
+		expectedErr error
+	}{
+		// Named scopes
+		{
+			input:  "orders.read orders.write profile",
+			output: model.Mask{"orders": 0x03, "users": 0x10},
+		},
+		// Legacy form
+		{
+			input:  "orders:3 users:10",
+			output: model.Mask{"orders": 0x03, "users": 0x10},
+		},
+		// Both forms
+		{
+			input:  "orders.read orders:2 profile",
+			output: model.Mask{"orders": 0x03, "users": 0x10},
+		},
+		// Unknown scope
+		{
+			input:       "orders.delete",
+			expectedErr: model.InvalidScope,
+		},
+		// Malformed legacy form
+		{
+			input:       "orders:1:2",
+			expectedErr: model.InvalidScope,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			scope, err := parser.ParseScope(v.input)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if !reflect.DeepEqual(v.output, scope) {
+				t.Fatalf(`expected scope "%+v" got "%+v"`, v.output, scope)
+			}
+		})
+	}
+}
+
+// TestScopePolicy_Apply checks that the scopes not allowed for a client are rejected or removed depending on the policy
+func TestScopePolicy_Apply(t *testing.T) {
+	allowed := model.Mask{"orders": 0x03, "users": 0x01}
+
+	tdt := []struct {
+		policy      ScopePolicy
+		requested   model.Mask
+		allowed     model.Mask
+		output      model.Mask
+		expectedErr error
+	}{
+		// The requested scope is allowed
+		{
+			requested: model.Mask{"orders": 0x01},
+			allowed:   allowed,
+			output:    model.Mask{"orders": 0x01},
+		},
+		// The client is not restricted
+		{
+			requested: model.Mask{"admin": 0xffffffffffffffff},
+			output:    model.Mask{"admin": 0xffffffffffffffff},
+		},
+		// The scope exceeds the allowed scope
+		{
+			requested:   model.Mask{"orders": 0x07},
+			allowed:     allowed,
+			expectedErr: model.InvalidScope,
+		},
+		// The scope is narrowed
+		{
+			policy:    NarrowScope,
+			requested: model.Mask{"orders": 0x07, "admin": 0xff},
+			allowed:   allowed,
+			output:    model.Mask{"orders": 0x03},
+		},
+		// None of the requested scopes is allowed
+		{
+			policy:      NarrowScope,
+			requested:   model.Mask{"admin": 0xff},
+			allowed:     allowed,
+			expectedErr: model.InvalidScope,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			granted, err := v.policy.Apply(v.requested, v.allowed)
+			if !errors.Is(err, v.expectedErr) {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if !reflect.DeepEqual(v.output, granted) {
+				t.Fatalf(`expected scope "%+v" got "%+v"`, v.output, granted)
+			}
+		})
+	}
+}
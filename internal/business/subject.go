@@ -0,0 +1,145 @@
+package business
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// SubjectResolver obtains the subject identifier ("sub") of the owners for each client
+type SubjectResolver interface {
+	// Subject returns the subject identifier of the owner sent to the client
+	Subject(clientId, ownerId string) (string, error)
+}
+
+// resolveSubject returns the subject identifier of the owner for the client, the owner id is used if the resolver
+// is not defined or the client is not identified (e.g. JWT Bearer requests without client_id)
+func resolveSubject(resolver SubjectResolver, clientId, ownerId string) (string, error) {
+	if resolver == nil || clientId == "" {
+		return ownerId, nil
+	}
+
+	return resolver.Subject(clientId, ownerId)
+}
+
+// _ "implement" constraint for PairwiseSubjects
+var _ SubjectResolver = PairwiseSubjects{}
+
+// PairwiseSubjects sends the owner id as subject identifier to the public clients and a salted hash of the sector
+// and the owner id to the pairwise clients (OpenID Connect Core 1.0 section 8.1)
+//
+// The sector is the host of the sector_identifier_uri or the host of the redirect uris if the client does not
+// register a sector_identifier_uri, so the clients of the same sector receive the same subject identifiers
+type PairwiseSubjects struct {
+	// Clients finder of the clients used to obtain their subject type and redirect uris
+	Clients repository.Finder
+	// Sectors finder of the redirect uris listed in the sector_identifier_uri of the clients
+	Sectors repository.Finder
+	// Salt secret value added to the hash, so the subject identifiers cannot be calculated from the owner ids
+	Salt []byte
+}
+
+// Subject returns the pairwise subject identifier if the client is configured as pairwise, otherwise the owner id
+func (p PairwiseSubjects) Subject(clientId, ownerId string) (string, error) {
+	client, err := findClient(p.Clients, clientId)
+	if err != nil {
+		return "", err
+	}
+
+	switch client.SubjectType {
+	case "", model.PublicSubject:
+		return ownerId, nil
+	case model.PairwiseSubject:
+	default:
+		return "", fmt.Errorf(`%w: unsupported subject type "%s"`, model.InvalidClient, client.SubjectType)
+	}
+
+	if len(p.Salt) == 0 {
+		return "", errors.New("missing salt of the pairwise subject identifiers")
+	}
+
+	sector, err := p.sector(client)
+	if err != nil {
+		return "", err
+	}
+
+	// The null byte separates the sector from the owner id, so different pairs cannot produce the same input
+	hash := sha256.New()
+	hash.Write([]byte(sector))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ownerId))
+	hash.Write(p.Salt)
+
+	return base64.RawURLEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sector returns the sector identifier of the client, the sector_identifier_uri must use the https scheme and
+// contain all the redirect uris of the client (OpenID Connect Dynamic Client Registration 1.0 section 5)
+func (p PairwiseSubjects) sector(client model.Client) (string, error) {
+	if client.SectorIdentifierURI == "" {
+		return redirectSector(client)
+	}
+
+	uri, err := url.Parse(client.SectorIdentifierURI)
+	if err != nil || uri.Scheme != "https" || uri.Host == "" {
+		return "", fmt.Errorf(`%w: the sector_identifier_uri of "%s" must be an https URL`, model.InvalidClient, client.Id)
+	}
+
+	if p.Sectors == nil {
+		return "", fmt.Errorf(`%w: the sector_identifier_uri of "%s" cannot be validated`, model.InvalidClient, client.Id)
+	}
+
+	i, err := p.Sectors.Find(client.SectorIdentifierURI)
+	if _, ok := err.(model.NotFound); ok || err == redis.Nil {
+		return "", fmt.Errorf(`%w: missing sector_identifier_uri "%s"`, model.InvalidClient, client.SectorIdentifierURI)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	listed := make(map[string]bool)
+
+	for _, redirectURI := range i.([]string) {
+		listed[redirectURI] = true
+	}
+
+	for _, redirectURI := range client.AllowedOrigins {
+		if !listed[redirectURI] {
+			return "", fmt.Errorf(`%w: the redirect uri "%s" is not listed in the sector_identifier_uri "%s"`, model.InvalidClient, redirectURI, client.SectorIdentifierURI)
+		}
+	}
+
+	return uri.Hostname(), nil
+}
+
+// redirectSector returns the host of the redirect uris as sector identifier, the redirect uris of the client
+// must share the host
+func redirectSector(client model.Client) (string, error) {
+	var sector string
+
+	for _, redirectURI := range client.AllowedOrigins {
+		uri, err := url.Parse(redirectURI)
+		if err != nil || uri.Hostname() == "" {
+			return "", fmt.Errorf(`%w: the redirect uri "%s" does not have host, a sector_identifier_uri is required`, model.InvalidClient, redirectURI)
+		}
+
+		if sector != "" && sector != uri.Hostname() {
+			return "", fmt.Errorf(`%w: the redirect uris of "%s" have different hosts, a sector_identifier_uri is required`, model.InvalidClient, client.Id)
+		}
+
+		sector = uri.Hostname()
+	}
+
+	if sector == "" {
+		return "", fmt.Errorf(`%w: client "%s" does not have redirect uris`, model.InvalidClient, client.Id)
+	}
+
+	return sector, nil
+}
@@ -0,0 +1,326 @@
+package business
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// TestPairwiseSubjects_Subject checks that the pairwise clients of the same sector receive the same subject
+// identifier, that the sector_identifier_uri is validated and that the public clients receive the owner id
+func TestPairwiseSubjects_Subject(t *testing.T) {
+	subjects := PairwiseSubjects{
+		Clients: repository.MockClientFinder{
+			"public": {AllowedOrigins: []string{"https://public.example.com/callback"}},
+			"web": {
+				AllowedOrigins: []string{"https://app.example.com/callback"},
+				SubjectType:    model.PairwiseSubject,
+			},
+			// Same sector as "web" because the redirect uri has the same host
+			"admin": {
+				AllowedOrigins: []string{"https://app.example.com/admin/callback"},
+				SubjectType:    model.PairwiseSubject,
+			},
+			"partner": {
+				AllowedOrigins: []string{"https://partner.example.org/callback"},
+				SubjectType:    model.PairwiseSubject,
+			},
+			// The sector is the host of the sector_identifier_uri
+			"mobile": {
+				AllowedOrigins:      []string{"https://app.example.com/mobile", "com.example.app:/callback"},
+				SubjectType:         model.PairwiseSubject,
+				SectorIdentifierURI: "https://app.example.com/sector.json",
+			},
+			"multiple": {
+				AllowedOrigins: []string{"https://a.example.com/callback", "https://b.example.com/callback"},
+				SubjectType:    model.PairwiseSubject,
+			},
+			"insecure": {
+				AllowedOrigins:      []string{"https://app.example.com/callback"},
+				SubjectType:         model.PairwiseSubject,
+				SectorIdentifierURI: "http://app.example.com/sector.json",
+			},
+			// The redirect uri is not listed in the sector_identifier_uri
+			"unlisted": {
+				AllowedOrigins:      []string{"https://app.example.com/other"},
+				SubjectType:         model.PairwiseSubject,
+				SectorIdentifierURI: "https://app.example.com/sector.json",
+			},
+			"unknown": {SubjectType: "random"},
+		},
+		Sectors: repository.MockSectorFinder{
+			"https://app.example.com/sector.json": {"https://app.example.com/mobile", "com.example.app:/callback"},
+		},
+		Salt: []byte("salt"),
+	}
+
+	web, err := subjects.Subject("web", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdt := []struct {
+		subjects    PairwiseSubjects
+		client      string
+		owner       string
+		expected    string
+		different   bool
+		expectedErr bool
+	}{
+		{
+			client:   "public",
+			owner:    "alice",
+			expected: "alice",
+		},
+		{
+			client:   "admin",
+			owner:    "alice",
+			expected: web,
+		},
+		{
+			client:   "mobile",
+			owner:    "alice",
+			expected: web,
+		},
+		{
+			client:    "partner",
+			owner:     "alice",
+			different: true,
+		},
+		{
+			client:    "web",
+			owner:     "bob",
+			different: true,
+		},
+		// The salt changes the subject identifiers
+		{
+			subjects:  PairwiseSubjects{Clients: subjects.Clients, Salt: []byte("other")},
+			client:    "web",
+			owner:     "alice",
+			different: true,
+		},
+		{
+			subjects:    PairwiseSubjects{Clients: subjects.Clients},
+			client:      "web",
+			owner:       "alice",
+			expectedErr: true,
+		},
+		{
+			client:      "multiple",
+			owner:       "alice",
+			expectedErr: true,
+		},
+		{
+			client:      "insecure",
+			owner:       "alice",
+			expectedErr: true,
+		},
+		{
+			client:      "unlisted",
+			owner:       "alice",
+			expectedErr: true,
+		},
+		{
+			client:      "unknown",
+			owner:       "alice",
+			expectedErr: true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			resolver := subjects
+			if v.subjects.Clients != nil {
+				resolver = v.subjects
+			}
+
+			subject, err := resolver.Subject(v.client, v.owner)
+			if (err != nil) != v.expectedErr {
+				t.Fatalf(`unexpected error "%v"`, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if v.different {
+				if subject == web || subject == v.owner {
+					t.Fatalf(`the subject "%s" must be different`, subject)
+				}
+
+				return
+			}
+
+			if subject != v.expected {
+				t.Fatalf(`expected "%s" got "%s"`, v.expected, subject)
+			}
+		})
+	}
+}
+
+// TestIntrospector_Introspect_Pairwise checks that the pairwise subject identifiers are resolved to the owner id
+// only for the clients that are not pairwise
+func TestIntrospector_Introspect_Pairwise(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := generator.GenerateToken(model.JWT{
+		StandardClaims: model.StandardClaims{
+			Id:        "session",
+			Subject:   "pairwise-subject",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspector := Introspector{
+		Clients: repository.MockClientFinder{
+			"reports": {Secret: "reports"},
+			"web":     {Secret: "web", SubjectType: model.PairwiseSubject},
+		},
+		TokenParser: generator,
+		SessionStorage: repository.NewMockStorage(map[string]interface{}{
+			"session": model.Session{Owner: model.Owner{Id: "alice"}, TokenId: "session"},
+		}),
+	}
+
+	tdt := []struct {
+		application model.Application
+		expected    string
+	}{
+		{
+			application: model.Application{Id: "reports", Secret: "reports"},
+			expected:    "alice",
+		},
+		{
+			application: model.Application{Id: "web", Secret: "web"},
+			expected:    "pairwise-subject",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			res, err := introspector.Introspect(v.application, tkn.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res["sub"] != v.expected {
+				t.Fatalf(`expected "%s" got "%v"`, v.expected, res["sub"])
+			}
+		})
+	}
+}
+
+// TestTokenExchangeGrant_ExchangeCode_Pairwise checks that the owner of the subject_token is obtained from its session
+// instead of the pairwise "sub" claim and that the exchanged token receives the subject identifier of the client
+func TestTokenExchangeGrant_ExchangeCode_Pairwise(t *testing.T) {
+	generator := JWTGenerator{}
+
+	err := generator.SetPrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := repository.MockClientFinder{
+		"gateway": {Secret: "gateway", ExchangeAudiences: []string{"orders"}},
+		"partner": {
+			Secret:            "partner",
+			ExchangeAudiences: []string{"orders"},
+			AllowedOrigins:    []string{"https://partner.example.org/callback"},
+			SubjectType:       model.PairwiseSubject,
+		},
+	}
+
+	subjects := PairwiseSubjects{Clients: clients, Salt: []byte("salt")}
+
+	sessions := repository.NewMockStorage(map[string]interface{}{
+		"session": model.Session{Owner: model.Owner{Id: "alice"}},
+	})
+
+	grant := TokenExchangeGrant{
+		Issuer:         "go-test",
+		Client:         ClientAuthenticator{Finder: clients},
+		Clients:        clients,
+		ScopeParser:    NewScopeParser(),
+		Tokens:         Introspector{TokenParser: generator, SessionStorage: sessions},
+		TokenGenerator: generator,
+		SessionStorage: sessions,
+		Subjects:       subjects,
+	}
+
+	subjectToken, err := generator.GenerateToken(model.JWT{
+		StandardClaims: model.StandardClaims{
+			Id:      "session",
+			Issuer:  "go-test",
+			Subject: "pairwise-subject",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partner, err := subjects.Subject("partner", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdt := []struct {
+		client   string
+		expected string
+	}{
+		{
+			client:   "gateway",
+			expected: "alice",
+		},
+		{
+			client:   "partner",
+			expected: partner,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			tkn, err := grant.ExchangeCode(model.Exchange{
+				GrantType:   model.TokenExchangeGrantType,
+				Application: model.Application{Id: v.client, Secret: v.client},
+				TokenExchange: model.TokenExchange{
+					Audience:         "orders",
+					SubjectToken:     subjectToken.AccessToken,
+					SubjectTokenType: model.AccessTokenType,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := generator.ParseToken(tkn.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != v.expected {
+				t.Fatalf(`expected subject "%s" got "%s"`, v.expected, claims.Subject)
+			}
+
+			// The session of the exchanged token keeps the owner id
+			i, err := sessions.Obtain(claims.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if owner := i.(model.Session).Owner.Id; owner != "alice" {
+				t.Fatalf(`expected owner "alice" got "%s"`, owner)
+			}
+		})
+	}
+}
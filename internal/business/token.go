@@ -1,9 +1,17 @@
 package business
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt"
 	"github.com/yael-castro/goauth/internal/model"
+	"strings"
 )
 
 // TokenGenerator defines a provider of token generated from some data
@@ -12,24 +20,247 @@ type TokenGenerator interface {
 	GenerateToken(interface{}) (model.Token, error)
 }
 
+// Signer defines a signer of arbitrary claims
+type Signer interface {
+	// Sign serializes and signs the received claims
+	Sign(model.Map) (string, error)
+}
+
+// TokenParser defines a parser of the tokens issued by the authorization server
+type TokenParser interface {
+	// ParseToken verifies the signature and the validity of the token and returns its claims
+	ParseToken(string) (model.JWT, error)
+}
+
+// KeyPublisher defines a generator whose verification key can be published in the key set
+type KeyPublisher interface {
+	// JWK returns the public key, false is returned if the key cannot be published
+	JWK() (model.JWK, bool)
+}
+
+// NewKeySet builds the JSON Web Key Set with the public keys of the generators, the generators that are not
+// instances of KeyPublisher or whose keys cannot be published (e.g. HMAC secrets) are ignored and the keys
+// shared by several generators are published once
+func NewKeySet(generators ...TokenGenerator) model.JWKSet {
+	set := model.JWKSet{Keys: []model.JWK{}}
+
+	for _, generator := range generators {
+		publisher, ok := generator.(KeyPublisher)
+		if !ok {
+			continue
+		}
+
+		jwk, ok := publisher.JWK()
+		if ok && !containsKey(set.Keys, jwk) {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// containsKey indicates if the key is contained in the slice
+func containsKey(keys []model.JWK, key model.JWK) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
 // _ "implement" constraint for JWTGenerator
-var _ TokenGenerator = (*JWTGenerator)(nil)
+var (
+	_ TokenGenerator = (*JWTGenerator)(nil)
+	_ Signer         = (*JWTGenerator)(nil)
+	_ TokenParser    = (*JWTGenerator)(nil)
+	_ KeyPublisher   = (*JWTGenerator)(nil)
+)
+
+// MinRSAKeySize minimum size in bits of the RSA keys used to sign the tokens (RFC 7518 section 3.3)
+const MinRSAKeySize = 2048
+
+// signingMethods signing algorithms supported by the JWTGenerator indexed by name
+var signingMethods = map[string]jwt.SigningMethod{
+	"RS256": jwt.SigningMethodRS256,
+	"RS384": jwt.SigningMethodRS384,
+	"RS512": jwt.SigningMethodRS512,
+	"PS256": jwt.SigningMethodPS256,
+	"PS384": jwt.SigningMethodPS384,
+	"PS512": jwt.SigningMethodPS512,
+	"ES256": jwt.SigningMethodES256,
+	"ES384": jwt.SigningMethodES384,
+	"ES512": jwt.SigningMethodES512,
+	"EdDSA": jwt.SigningMethodEdDSA,
+	"HS256": jwt.SigningMethodHS256,
+	"HS384": jwt.SigningMethodHS384,
+	"HS512": jwt.SigningMethodHS512,
+}
 
+// JWTGenerator generates, signs and parses JWTs using a single key
+//
+// Supported algorithms: RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, EdDSA (Ed25519),
+// HS256, HS384 and HS512
 type JWTGenerator struct {
-	privateKey *rsa.PrivateKey
+	// KeyId identifier of the private key sent in the "kid" header of the tokens (Optional)
+	KeyId string
+	// Algorithm used to sign the tokens, if it is empty the algorithm is selected by the type of key:
+	// RS256 for RSA keys, ES256, ES384 or ES512 for EC keys depending on the curve and EdDSA for Ed25519 keys
+	//
+	// The HMAC algorithms (HS256, HS384 and HS512) must be set explicitly
+	Algorithm string
+	// Encrypter encrypts the signed tokens as nested JWTs, so the claims can only be read by the recipient
+	// (Optional)
+	Encrypter  TokenEncrypter
+	method     jwt.SigningMethod
+	privateKey interface{}
 }
 
-// SetPrivateKey parse the slice of bytes to a *rsa.PrivateKey
-func (g *JWTGenerator) SetPrivateKey(privateKey []byte) (err error) {
-	g.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privateKey)
-	return
+// SetPrivateKey parses the private key in PEM format (PKCS#1, PKCS#8 or SEC1) and validates that it can be used
+// with the Algorithm, the RSA keys must have at least MinRSAKeySize bits
+//
+// If the Algorithm is HMAC the slice of bytes is used as secret, it must be at least as long as the hash output
+func (g *JWTGenerator) SetPrivateKey(privateKey []byte) error {
+	if strings.HasPrefix(g.Algorithm, "HS") {
+		method, ok := signingMethods[g.Algorithm].(*jwt.SigningMethodHMAC)
+		if !ok {
+			return fmt.Errorf(`unsupported signing algorithm "%s"`, g.Algorithm)
+		}
+
+		if len(privateKey) < method.Hash.Size() {
+			return fmt.Errorf(`the secret of "%s" must have at least %d bytes`, g.Algorithm, method.Hash.Size())
+		}
+
+		g.method, g.privateKey = method, privateKey
+		return nil
+	}
+
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	algorithms, err := keyAlgorithms(key)
+	if err != nil {
+		return err
+	}
+
+	algorithm := g.Algorithm
+	if algorithm == "" {
+		algorithm = algorithms[0]
+	}
+
+	if !containsString(algorithms, algorithm) {
+		return fmt.Errorf(`the signing algorithm "%s" cannot be used with a key of type %T`, algorithm, key)
+	}
+
+	g.method, g.privateKey = signingMethods[algorithm], key
+	return nil
+}
+
+// JWK returns the public key as model.JWK to be published in the key set of the authorization server,
+// the HMAC secrets are never published so false is returned
+func (g JWTGenerator) JWK() (model.JWK, bool) {
+	if _, ok := g.privateKey.([]byte); ok || g.privateKey == nil {
+		return model.JWK{}, false
+	}
+
+	jwk, err := model.NewJWK(g.publicKey())
+	if err != nil {
+		return model.JWK{}, false
+	}
+
+	jwk.KeyId, jwk.Use, jwk.Algorithm = g.KeyId, "sig", g.signingMethod().Alg()
+	return jwk, true
+}
+
+// signingMethod returns the signing method of the key, RS256 by default
+func (g JWTGenerator) signingMethod() jwt.SigningMethod {
+	if g.method == nil {
+		return jwt.SigningMethodRS256
+	}
+
+	return g.method
+}
+
+// publicKey returns the key that verifies the signatures
+func (g JWTGenerator) publicKey() interface{} {
+	switch key := g.privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+
+	// The HMAC secret signs and verifies
+	return g.privateKey
+}
+
+// parsePrivateKey decodes a private key in PEM format: PKCS#1 ("RSA PRIVATE KEY"), SEC1 ("EC PRIVATE KEY")
+// or PKCS#8 ("PRIVATE KEY")
+func parsePrivateKey(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("the private key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf(`unsupported PEM block "%s"`, block.Type)
+}
+
+// keyAlgorithms returns the signing algorithms that can be used with the key, the first is the default
+func keyAlgorithms(key interface{}) ([]string, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if size := key.N.BitLen(); size < MinRSAKeySize {
+			return nil, fmt.Errorf("the RSA key must have at least %d bits, got %d bits", MinRSAKeySize, size)
+		}
+
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, nil
+
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return []string{"ES256"}, nil
+		case elliptic.P384():
+			return []string{"ES384"}, nil
+		case elliptic.P521():
+			return []string{"ES512"}, nil
+		}
+
+		return nil, fmt.Errorf(`unsupported curve "%s"`, key.Curve.Params().Name)
+
+	case ed25519.PrivateKey:
+		return []string{"EdDSA"}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key of type %T", key)
 }
 
 // GenerateToken generates a JWT based on the model.JWT received as parameter
 func (g JWTGenerator) GenerateToken(i interface{}) (model.Token, error) {
 	claims := i.(model.JWT)
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(g.privateKey)
+	jwtToken := jwt.NewWithClaims(g.signingMethod(), claims)
+	if g.KeyId != "" {
+		jwtToken.Header["kid"] = g.KeyId
+	}
+
+	token, err := jwtToken.SignedString(g.privateKey)
+	if err == nil && g.Encrypter != nil {
+		token, err = g.Encrypter.Encrypt(token)
+	}
 
 	tkn := model.Token{
 		Type:        "Bearer",
@@ -39,3 +270,32 @@ func (g JWTGenerator) GenerateToken(i interface{}) (model.Token, error) {
 
 	return tkn, err
 }
+
+// Sign generates a JWT signed with the private key that contains the received claims
+func (g JWTGenerator) Sign(claims model.Map) (string, error) {
+	token := jwt.NewWithClaims(g.signingMethod(), jwt.MapClaims(claims))
+	if g.KeyId != "" {
+		token.Header["kid"] = g.KeyId
+	}
+
+	return token.SignedString(g.privateKey)
+}
+
+// ParseToken verifies a JWT signed with the private key and returns its claims
+//
+// The numbers of the claims are decoded as json.Number to keep the precision of the bit masks
+func (g JWTGenerator) ParseToken(token string) (claims model.JWT, err error) {
+	parser := jwt.Parser{
+		ValidMethods:  []string{g.signingMethod().Alg()},
+		UseJSONNumber: true,
+	}
+
+	_, err = parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return g.publicKey(), nil
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", model.InvalidToken, err.Error())
+	}
+
+	return
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Paths of the administration endpoints, the identifier of the record is the last segment of the path
+//
+// Example:
+//
+//	PUT /go-auth/v1/admin/roles/sales
+//	{"scopes": {"orders": 3}}
+const (
+	AdminRolesPath       = "/go-auth/v1/admin/roles/"
+	AdminGroupsPath      = "/go-auth/v1/admin/groups/"
+	AdminAssignmentsPath = "/go-auth/v1/admin/assignments/"
+)
+
+// NewAdminHandler creates a http.Handler using a business.AccessManager to manage the roles, the groups
+// and the assignments of the owners
+//
+// Supported methods: GET, PUT (create or replace) and DELETE, every request must contain the
+// token of the administrator as bearer token
+func NewAdminHandler(manager business.AccessManager, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(AdminRolesPath, newAdminRecordHandler(AdminRolesPath, adminRecord{
+		obtain: func(id string) (interface{}, error) { return manager.ObtainRole(id) },
+		save: func(id string, decoder *json.Decoder) error {
+			role := model.Role{}
+			if err := decoder.Decode(&role); err != nil {
+				return fmt.Errorf("%w: %s", model.InvalidRequest, err.Error())
+			}
+
+			role.Id = id
+			return manager.SaveRole(role)
+		},
+		remove: manager.DeleteRole,
+	}))
+
+	mux.HandleFunc(AdminGroupsPath, newAdminRecordHandler(AdminGroupsPath, adminRecord{
+		obtain: func(id string) (interface{}, error) { return manager.ObtainGroup(id) },
+		save: func(id string, decoder *json.Decoder) error {
+			group := model.Group{}
+			if err := decoder.Decode(&group); err != nil {
+				return fmt.Errorf("%w: %s", model.InvalidRequest, err.Error())
+			}
+
+			group.Id = id
+			return manager.SaveGroup(group)
+		},
+		remove: manager.DeleteGroup,
+	}))
+
+	mux.HandleFunc(AdminAssignmentsPath, newAdminRecordHandler(AdminAssignmentsPath, adminRecord{
+		obtain: func(id string) (interface{}, error) { return manager.ObtainAssignment(id) },
+		save: func(id string, decoder *json.Decoder) error {
+			assignment := model.Assignment{}
+			if err := decoder.Decode(&assignment); err != nil {
+				return fmt.Errorf("%w: %s", model.InvalidRequest, err.Error())
+			}
+
+			assignment.Owner = id
+			return manager.SaveAssignment(assignment)
+		},
+		remove: manager.DeleteAssignment,
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminRecord operations over a type of record managed by the administration endpoints
+type adminRecord struct {
+	obtain func(string) (interface{}, error)
+	save   func(string, *json.Decoder) error
+	remove func(string) error
+}
+
+// newAdminRecordHandler creates a http.HandlerFunc that obtains, saves or removes the record identified
+// by the last segment of the path
+func newAdminRecordHandler(prefix string, record adminRecord) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, prefix)
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			i, err := record.obtain(id)
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+
+			JSON(w, http.StatusOK, i)
+
+		case http.MethodPut:
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+
+			if err := record.save(id, decoder); err != nil {
+				adminError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			if err := record.remove(id); err != nil {
+				adminError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// adminError sends the error of an administration request, the missing records are reported with 404 status code
+func adminError(w http.ResponseWriter, err error) {
+	var notFound model.NotFound
+
+	switch {
+	case errors.As(err, &notFound):
+		JSON(w, http.StatusNotFound, model.ErrorResponse{Error: "not_found", ErrorDescription: err.Error()})
+
+	case errors.Is(err, model.InvalidRequest):
+		JSON(w, http.StatusBadRequest, model.ErrorResponse{Error: model.InvalidRequest.Error(), ErrorDescription: err.Error()})
+
+	default:
+		JSON(w, http.StatusInternalServerError, model.ErrorResponse{Error: model.ServerError.Error(), ErrorDescription: err.Error()})
+	}
+}
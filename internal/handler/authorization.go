@@ -13,7 +13,10 @@ import (
 
 // NewAuthorizationHandler creates a http.HandleFunc using a business.Authorizer to handle authorization requests in
 // the Authorization Code Grant flow described in the OAuth 2.0 protocol
-func NewAuthorizationHandler(authorizer business.Authorizer) http.HandlerFunc {
+//
+// The authorization responses (successful or not) are rendered by the Responder and the ClientIPResolver
+// obtains the ip address of the owner evaluated by the authorization policies
+func NewAuthorizationHandler(authorizer business.Authorizer, responder Responder, resolver ClientIPResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "", http.StatusMethodNotAllowed)
@@ -40,7 +43,8 @@ func NewAuthorizationHandler(authorizer business.Authorizer) http.HandlerFunc {
 			return
 		}
 
-		redirectURL := &[]url.URL{*r.URL}[0]
+		// The redirect uri remains nil if it is missing or malformed
+		var redirectURL *url.URL
 
 		if r.Form.Get("redirect_uri") != "" {
 			redirect, err := url.Parse(r.Form.Get("redirect_uri"))
@@ -49,6 +53,16 @@ func NewAuthorizationHandler(authorizer business.Authorizer) http.HandlerFunc {
 			}
 		}
 
+		// The redirect uri is not trusted yet, so the malformed authorization_details are never sent to it
+		details, err := model.ParseAuthorizationDetails(r.Form.Get("authorization_details"))
+		if err != nil {
+			oauthErr := model.InvalidAuthorizationDetails
+			_ = errors.As(err, &oauthErr)
+
+			responder.RespondError(w, r, oauthErr.StatusCode(), OAuthError(oauthErr, errorDescription(oauthErr, err)))
+			return
+		}
+
 		username, password, ok := r.BasicAuth()
 		if !ok {
 			w.Header().Set("WWW-Authenticate", "Basic")
@@ -62,34 +76,59 @@ func NewAuthorizationHandler(authorizer business.Authorizer) http.HandlerFunc {
 				Secret:      r.Form.Get("client_secret"),
 				RedirectURL: redirectURL,
 			},
-			State:               model.State(r.Form.Get("state")),
-			CodeChallenge:       model.CodeChallenge(r.Form.Get("code_challenge")),
-			CodeChallengeMethod: model.CodeChallengeMethod(r.Form.Get("code_challenge_method")),
-			Scope:               r.Form.Get("scope"),
-			ResponseType:        r.Form.Get("response_type"),
+			State:                model.State(r.Form.Get("state")),
+			CodeChallenge:        model.CodeChallenge(r.Form.Get("code_challenge")),
+			CodeChallengeMethod:  model.CodeChallengeMethod(r.Form.Get("code_challenge_method")),
+			Scope:                r.Form.Get("scope"),
+			Nonce:                r.Form.Get("nonce"),
+			AuthorizationDetails: details,
+			Resources:            r.Form["resource"],
+			ResponseType:         r.Form.Get("response_type"),
+			ResponseMode:         model.ResponseMode(r.Form.Get("response_mode")),
 			BasicAuth: model.Owner{
 				Id:       username,
 				Password: password,
 			},
+			IP:        resolver.Resolve(r),
+			UserAgent: r.UserAgent(),
 		}
 
 		oauthErr := model.OAuthError(0)
 
+		var parameters url.Values
+
 		code, err := authorizer.Authorize(a)
+
+		// Errors related to the client or the redirect uri are never sent to the redirect uri
+		if errors.As(err, &model.UntrustedRedirect{}) {
+			if !errors.As(err, &oauthErr) {
+				oauthErr = model.ServerError
+			}
+
+			responder.RespondError(w, r, oauthErr.StatusCode(), OAuthError(oauthErr, errorDescription(oauthErr, err)))
+			return
+		}
+
 		switch {
 		case errors.As(err, &oauthErr):
-			OAuthError(redirectURL, oauthErr, err.Error())
+			if !oauthErr.IsRedirectable() {
+				responder.RespondError(w, r, oauthErr.StatusCode(), OAuthError(oauthErr, errorDescription(oauthErr, err)))
+				return
+			}
+
+			parameters = OAuthError(oauthErr, errorDescription(oauthErr, err))
 
 		case err != nil:
-			OAuthError(redirectURL, model.ServerError, err.Error())
+			parameters = OAuthError(model.ServerError, errorDescription(model.ServerError, err))
 
 		default:
-			redirectURL.RawQuery = url.Values{
-				"code":  {string(code)},
-				"state": {string(a.State)},
-			}.Encode()
+			parameters = url.Values{"code": {string(code)}}
+		}
+
+		if a.State != "" {
+			parameters.Set("state", string(a.State))
 		}
 
-		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+		responder.Respond(w, r, a, parameters)
 	}
 }
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+	"github.com/yael-castro/goauth/internal/repository"
+)
+
+// brokenClientFinder fails to search the client "broken" like an unavailable database
+type brokenClientFinder struct {
+	repository.MockClientFinder
+}
+
+// Find search a model.Client by id
+func (b brokenClientFinder) Find(id string) (interface{}, error) {
+	if id == "broken" {
+		return nil, errors.New("dial tcp 10.0.0.5:6379: connect: connection refused")
+	}
+
+	return b.MockClientFinder.Find(id)
+}
+
+// TestNewAuthorizationHandler checks that the authorization responses are delivered with the requested
+// response mode and that the errors are never sent to an untrusted redirect_uri
+func TestNewAuthorizationHandler(t *testing.T) {
+	const (
+		issuer      = "http://localhost:8080"
+		redirectURI = "https://app.example.com/callback"
+	)
+
+	grant := business.AuthorizationCodeGrant{
+		ScopeParser:   business.NewScopeParser(),
+		CodeGenerator: business.GenerateRandomCode,
+		Client: business.ClientAuthenticator{
+			Finder: brokenClientFinder{
+				repository.MockClientFinder{
+					"mobile": {Id: "mobile", AllowedOrigins: []string{redirectURI}},
+				},
+			},
+		},
+		Owner: business.OwnerAuthenticator{
+			Storage: repository.NewMockStorage(map[string]interface{}{
+				"contacto@yael-castro.com": model.Owner{
+					Id:       "contacto@yael-castro.com",
+					Password: "$2a$10$g141w.TTnp5Bm/rLNqRRRevOSFhKBdV5KaJYxEDi9U5R9TgkZbfne",
+				},
+			}),
+		},
+		CodeStorage: repository.NewMockStorage(nil),
+	}
+
+	handler := NewAuthorizationHandler(grant, Responder{Issuer: issuer, Signer: mockSigner{}}, ClientIPResolver{})
+
+	tdt := []struct {
+		query map[string]string
+		// expectedMode delivery of the response parameters, empty if the response must not reach the client
+		expectedMode  model.ResponseMode
+		expectedError string
+	}{
+		{
+			query:        map[string]string{},
+			expectedMode: model.QueryMode,
+		},
+		{
+			query:        map[string]string{"response_mode": "fragment"},
+			expectedMode: model.FragmentMode,
+		},
+		{
+			query:        map[string]string{"response_mode": "form_post"},
+			expectedMode: model.FormPostMode,
+		},
+		{
+			query:        map[string]string{"response_mode": "jwt"},
+			expectedMode: model.QueryJWTMode,
+		},
+		{
+			query:        map[string]string{"response_mode": "fragment.jwt"},
+			expectedMode: model.FragmentJWTMode,
+		},
+		{
+			query:        map[string]string{"response_mode": "form_post.jwt"},
+			expectedMode: model.FormPostJWTMode,
+		},
+		// The errors are sent to the trusted redirect_uri with the requested response mode
+		{
+			query:         map[string]string{"response_type": "token", "response_mode": "fragment"},
+			expectedMode:  model.FragmentMode,
+			expectedError: "unsupported_response_type",
+		},
+		{
+			query:         map[string]string{"response_type": "token", "response_mode": "form_post.jwt"},
+			expectedMode:  model.FormPostJWTMode,
+			expectedError: "unsupported_response_type",
+		},
+		// The redirect_uri is not registered for the client
+		{
+			query:         map[string]string{"redirect_uri": "https://attacker.example.com/callback"},
+			expectedError: "invalid_request",
+		},
+		{
+			query:         map[string]string{"redirect_uri": "https://attacker.example.com/callback", "response_type": "token"},
+			expectedError: "invalid_request",
+		},
+		{
+			query:         map[string]string{"redirect_uri": "https://app.example.com.attacker.example.com/callback", "response_mode": "form_post"},
+			expectedError: "invalid_request",
+		},
+		// Unknown client
+		{
+			query:         map[string]string{"client_id": "unknown", "redirect_uri": "https://attacker.example.com/callback"},
+			expectedError: "invalid_client",
+		},
+		// The client cannot be searched
+		{
+			query:         map[string]string{"client_id": "broken", "redirect_uri": "https://attacker.example.com/callback"},
+			expectedError: "server_error",
+		},
+		// Missing and malformed redirect_uri
+		{
+			query:         map[string]string{"redirect_uri": ""},
+			expectedError: "invalid_request",
+		},
+		{
+			query:         map[string]string{"redirect_uri": "https://app.example.com/%zz"},
+			expectedError: "invalid_request",
+		},
+		// The malformed authorization_details are never sent to the redirect_uri
+		{
+			query:         map[string]string{"authorization_details": "{"},
+			expectedError: "invalid_authorization_details",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			query := url.Values{
+				"client_id":     {"mobile"},
+				"redirect_uri":  {redirectURI},
+				"response_type": {"code"},
+				"state":         {"AAA"},
+			}
+
+			for key, value := range v.query {
+				query.Set(key, value)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, issuer+AuthorizationPath+"?"+query.Encode(), nil)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("contacto@yael-castro.com", "yael.castro")
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if v.expectedMode == "" {
+				oauthErr, _ := model.ParseOAuthError(v.expectedError)
+
+				if w.Code != oauthErr.StatusCode() {
+					t.Fatalf(`expected status "%d" got "%d"`, oauthErr.StatusCode(), w.Code)
+				}
+
+				if location := w.Header().Get("Location"); location != "" {
+					t.Fatalf(`unexpected redirect to "%s"`, location)
+				}
+
+				if strings.Contains(w.Body.String(), "attacker.example.com") {
+					t.Fatal("the error page contains the untrusted redirect_uri")
+				}
+
+				if !strings.Contains(w.Body.String(), v.expectedError) {
+					t.Fatalf(`expected error "%s" got "%s"`, v.expectedError, w.Body)
+				}
+
+				if strings.Count(w.Body.String(), v.expectedError+":") > 1 {
+					t.Fatalf(`the error description repeats the error code "%s"`, w.Body)
+				}
+
+				return
+			}
+
+			parameters := responseParameters(t, w, redirectURI, v.expectedMode)
+
+			if parameters.Get("iss") != issuer || parameters.Get("state") != "AAA" {
+				t.Fatalf(`unexpected parameters "%v"`, parameters)
+			}
+
+			if parameters.Get("error") != v.expectedError {
+				t.Fatalf(`expected error "%s" got "%s"`, v.expectedError, parameters.Get("error"))
+			}
+
+			if code := parameters.Get("code"); (code != "") != (v.expectedError == "") {
+				t.Fatalf(`unexpected code "%s"`, code)
+			}
+		})
+	}
+}
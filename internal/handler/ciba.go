@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// NewBackchannelAuthenticationHandler creates a http.HandlerFunc using a business.BackchannelAuthorizer to handle
+// the authentication requests of the Client-Initiated Backchannel Authentication flow (CIBA)
+func NewBackchannelAuthenticationHandler(authorizer business.BackchannelAuthorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || media != "application/x-www-form-urlencoded" {
+			TokenError(w, fmt.Errorf(`%w: media "%s" is not supported`, model.InvalidRequest, media))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
+			return
+		}
+
+		res, err := authorizer.AuthorizeBackchannel(model.BackchannelAuthorization{
+			Application:             clientCredentials(r),
+			Scope:                   r.Form.Get("scope"),
+			LoginHint:               r.Form.Get("login_hint"),
+			BindingMessage:          r.Form.Get("binding_message"),
+			ClientNotificationToken: r.Form.Get("client_notification_token"),
+		})
+		if err != nil {
+			TokenError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		JSON(w, http.StatusOK, res)
+	}
+}
+
+// NewBackchannelApprovalHandler creates a http.HandlerFunc using a business.BackchannelAuthorizer to complete
+// the authentication requests of the CIBA flow from the device of the owner
+//
+// The owner credentials are received using the basic authentication and the form contains the "auth_req_id"
+// and the "action" (approve or deny)
+func NewBackchannelApprovalHandler(authorizer business.BackchannelAuthorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		approved := r.PostForm.Get("action") == "approve"
+		owner := model.Owner{Id: username, Password: password}
+
+		err := authorizer.CompleteBackchannel(r.PostForm.Get("auth_req_id"), owner, approved)
+		if err != nil {
+			TokenError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// verificationTemplate HTML page where the owner enters the user code to approve or deny a device
+var verificationTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Device activation</title></head>
+<body>
+{{- if .Message }}
+<p>{{ .Message }}</p>
+{{- else }}
+<form method="post">
+<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}"/>
+<label for="user_code">Enter the code displayed on your device</label>
+<input id="user_code" name="user_code" value="{{ .UserCode }}" autocomplete="off" autocapitalize="characters"/>
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{- end }}
+</body>
+</html>
+`))
+
+// csrfCookie name of the cookie that contains the anti-CSRF token of the verification page
+const csrfCookie = "device_csrf"
+
+// verificationErrorMessage message rendered in the verification page when the user code or the owner is not valid
+const verificationErrorMessage = "The code or the credentials are not valid, check them and try again"
+
+// NewDeviceAuthorizationHandler creates a http.HandlerFunc using a business.DeviceAuthorizer to handle the
+// device authorization requests of the Device Authorization Grant (RFC 8628 section 3.1)
+func NewDeviceAuthorizationHandler(authorizer business.DeviceAuthorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || media != "application/x-www-form-urlencoded" {
+			TokenError(w, fmt.Errorf(`%w: media "%s" is not supported`, model.InvalidRequest, media))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
+			return
+		}
+
+		res, err := authorizer.AuthorizeDevice(model.DeviceAuthorization{
+			Application: clientCredentials(r),
+			Scope:       r.Form.Get("scope"),
+		})
+		if err != nil {
+			TokenError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		JSON(w, http.StatusOK, res)
+	}
+}
+
+// NewDeviceVerificationHandler creates a http.HandlerFunc using a business.DeviceAuthorizer to render the
+// verification page where the owner enters the user code and approves or denies the device (RFC 8628 section 3.3)
+//
+// The owner credentials are received using the basic authentication like in the authorization endpoint, since the
+// browsers send the credentials in every request the form is protected with an anti-CSRF token saved in a cookie
+// (double submit) and the cross-origin requests are rejected
+func NewDeviceVerificationHandler(authorizer business.DeviceAuthorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := struct {
+			UserCode  string
+			CSRFToken string
+			Message   string
+		}{
+			UserCode: r.URL.Query().Get("user_code"),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch r.Method {
+		case http.MethodGet:
+			token, err := csrfToken()
+			if err != nil {
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     DeviceVerificationPath,
+				Secure:   r.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+
+			page.CSRFToken = token
+			_ = verificationTemplate.Execute(w, page)
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !sameOrigin(r) || !validCSRFToken(r) {
+			w.WriteHeader(http.StatusForbidden)
+			page.Message = "The request could not be verified, reload the page and try again"
+			_ = verificationTemplate.Execute(w, page)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		approved := r.PostForm.Get("action") == "approve"
+		owner := model.Owner{Id: username, Password: password}
+
+		err := authorizer.VerifyUserCode(model.UserCode(r.PostForm.Get("user_code")), owner, approved)
+
+		oauthErr := model.OAuthError(0)
+
+		switch {
+		// The detail of the error is not rendered, so the page does not reveal which owners or user codes exist
+		case errors.As(err, &oauthErr):
+			log.Printf("device verification: %v", err)
+			w.WriteHeader(oauthErr.StatusCode())
+			page.Message = verificationErrorMessage
+		case err != nil:
+			log.Printf("server_error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			page.Message = model.ServerError.Error()
+		case approved:
+			page.Message = "Your device was approved, you can return to your device"
+		default:
+			page.Message = "Your device was denied"
+		}
+
+		_ = verificationTemplate.Execute(w, page)
+	}
+}
+
+// csrfToken generates a random anti-CSRF token
+func csrfToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validCSRFToken indicates if the anti-CSRF token of the form matches the token of the cookie
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+// sameOrigin indicates if the request was sent from the same origin, the requests without Origin header
+// (e.g. old browsers) are validated only by the anti-CSRF token
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	uri, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return uri.Host == r.Host
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// mockDeviceAuthorizer records the clients that authorize devices and the user codes verified by the owners
+type mockDeviceAuthorizer struct {
+	applications []model.Application
+	verified     []model.UserCode
+	// err returned by VerifyUserCode
+	err error
+}
+
+// AuthorizeDevice records the client and returns an empty response
+func (m *mockDeviceAuthorizer) AuthorizeDevice(device model.DeviceAuthorization) (model.DeviceCodeResponse, error) {
+	m.applications = append(m.applications, device.Application)
+	return model.DeviceCodeResponse{}, nil
+}
+
+// VerifyUserCode records the user code
+func (m *mockDeviceAuthorizer) VerifyUserCode(userCode model.UserCode, _ model.Owner, _ bool) error {
+	m.verified = append(m.verified, userCode)
+	return m.err
+}
+
+// TestNewDeviceAuthorizationHandler checks that the client credentials are obtained from the basic authentication
+// or from the form
+func TestNewDeviceAuthorizationHandler(t *testing.T) {
+	tdt := []struct {
+		form                url.Values
+		basicAuth           bool
+		expectedApplication model.Application
+	}{
+		// client_secret_basic
+		{
+			form:                url.Values{"scope": {"read"}},
+			basicAuth:           true,
+			expectedApplication: model.Application{Id: "tv", Secret: "secret"},
+		},
+		// client_secret_post
+		{
+			form:                url.Values{"client_id": {"tv"}, "client_secret": {"secret"}},
+			expectedApplication: model.Application{Id: "tv", Secret: "secret"},
+		},
+		// Public client
+		{
+			form:                url.Values{"client_id": {"tv"}},
+			expectedApplication: model.Application{Id: "tv"},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			authorizer := &mockDeviceAuthorizer{}
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+DeviceAuthorizationPath, strings.NewReader(v.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if v.basicAuth {
+				r.SetBasicAuth("tv", "secret")
+			}
+
+			w := httptest.NewRecorder()
+			NewDeviceAuthorizationHandler(authorizer)(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf(`expected status "%d" got "%d"`, http.StatusOK, w.Code)
+			}
+
+			if len(authorizer.applications) != 1 || authorizer.applications[0] != v.expectedApplication {
+				t.Fatalf(`expected client "%v" got "%v"`, v.expectedApplication, authorizer.applications)
+			}
+		})
+	}
+}
+
+// TestNewDeviceVerificationHandler checks that the verification form is only accepted with the anti-CSRF token
+// of the cookie and from the same origin
+func TestNewDeviceVerificationHandler(t *testing.T) {
+	authorizer := &mockDeviceAuthorizer{}
+	handler := NewDeviceVerificationHandler(authorizer)
+
+	// The verification page sets the anti-CSRF token
+	page := httptest.NewRecorder()
+	handler(page, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+DeviceVerificationPath, nil))
+
+	cookies := page.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value == "" {
+		t.Fatalf("missing anti-CSRF cookie %v", cookies)
+	}
+
+	token := cookies[0].Value
+
+	if !strings.Contains(page.Body.String(), `value="`+token+`"`) {
+		t.Fatal("the form does not contain the anti-CSRF token")
+	}
+
+	tdt := []struct {
+		cookie         string
+		token          string
+		origin         string
+		expectedStatus int
+	}{
+		{
+			cookie:         token,
+			token:          token,
+			origin:         "http://localhost:8080",
+			expectedStatus: http.StatusOK,
+		},
+		// Request without Origin header
+		{
+			cookie:         token,
+			token:          token,
+			expectedStatus: http.StatusOK,
+		},
+		// Cross-site request, the cookie is not sent
+		{
+			token:          token,
+			expectedStatus: http.StatusForbidden,
+		},
+		// The token of the form does not match the cookie
+		{
+			cookie:         token,
+			token:          "forged",
+			expectedStatus: http.StatusForbidden,
+		},
+		// Cross-origin request
+		{
+			cookie:         token,
+			token:          token,
+			origin:         "https://attacker.example.com",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			authorizer.verified = nil
+
+			form := url.Values{"user_code": {"BCDF-GHJK"}, "action": {"approve"}, "csrf_token": {v.token}}
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+DeviceVerificationPath, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("contacto@yael-castro.com", "yael.castro")
+
+			if v.origin != "" {
+				r.Header.Set("Origin", v.origin)
+			}
+
+			if v.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: v.cookie})
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != v.expectedStatus {
+				t.Fatalf(`expected status "%d" got "%d"`, v.expectedStatus, w.Code)
+			}
+
+			if verified := len(authorizer.verified) == 1; verified != (v.expectedStatus == http.StatusOK) {
+				t.Fatalf("unexpected verification %v", authorizer.verified)
+			}
+		})
+	}
+}
+
+// TestNewDeviceVerificationHandler_Error checks that the verification page does not reveal if the owner or the
+// user code exist
+func TestNewDeviceVerificationHandler_Error(t *testing.T) {
+	tdt := []struct {
+		err            error
+		expectedStatus int
+	}{
+		{
+			err:            fmt.Errorf(`%w: owner "contacto@yael-castro.com" does not exists`, model.AccessDenied),
+			expectedStatus: model.AccessDenied.StatusCode(),
+		},
+		{
+			err:            fmt.Errorf("%w: invalid user_code", model.InvalidGrant),
+			expectedStatus: model.InvalidGrant.StatusCode(),
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			handler := NewDeviceVerificationHandler(&mockDeviceAuthorizer{err: v.err})
+
+			form := url.Values{"user_code": {"BCDF-GHJK"}, "action": {"approve"}, "csrf_token": {"token"}}
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+DeviceVerificationPath, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("contacto@yael-castro.com", "yael.castro")
+			r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != v.expectedStatus {
+				t.Fatalf(`expected status "%d" got "%d"`, v.expectedStatus, w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), verificationErrorMessage) {
+				t.Fatalf(`expected the message "%s" got "%s"`, verificationErrorMessage, w.Body)
+			}
+
+			if strings.Contains(w.Body.String(), "owner") || strings.Contains(w.Body.String(), "user_code") {
+				t.Fatalf(`the page contains the detail of the error "%s"`, w.Body)
+			}
+		})
+	}
+}
@@ -3,34 +3,93 @@ package handler
 import (
 	"encoding/json"
 	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
 	"net/http"
 	"net/url"
 )
 
-// NewServeMux builds a http.ServeMux based on a business.CodeGrant
-// and is returned as http.Handler
-func NewServeMux(grant business.CodeGrant) *http.ServeMux {
+// Paths of the endpoints exposed by the authorization server
+const (
+	AuthorizationPath       = "/go-auth/v1/authorization"
+	TokenPath               = "/go-auth/v1/token"
+	DeviceAuthorizationPath = "/go-auth/v1/device_authorization"
+	DeviceVerificationPath  = "/go-auth/v1/device"
+	BackchannelPath         = "/go-auth/v1/bc-authorize"
+	BackchannelApprovalPath = "/go-auth/v1/bc-approve"
+	IntrospectionPath       = "/go-auth/v1/introspect"
+	MetadataPath            = "/.well-known/oauth-authorization-server"
+	KeySetPath              = "/go-auth/v1/jwks"
+)
+
+// Configuration dependencies used to build the HTTP handlers of the authorization server
+type Configuration struct {
+	// Authorizer handles the authorization requests of the Authorization Code Grant flow
+	business.Authorizer
+	// Exchanger handles the token requests of every supported grant type
+	Exchanger business.CodeExchanger
+	// DeviceAuthorizer handles the requests of the Device Authorization Grant (Optional)
+	business.DeviceAuthorizer
+	// BackchannelAuthorizer handles the requests of the CIBA flow (Optional)
+	business.BackchannelAuthorizer
+	// AccessManager manages the roles, the groups and the assignments of the owners (Optional)
+	business.AccessManager
+	// AdminToken bearer token required by the administration endpoints
+	AdminToken string
+	// Introspector handles the token introspection requests (Optional)
+	business.TokenIntrospector
+	// Responder renders the authorization responses
+	Responder
+	// Metadata of the authorization server (RFC 8414)
+	Metadata model.Metadata
+	// KeySet public keys used to verify the tokens (RFC 7517)
+	KeySet model.JWKSet
+	// ClientIPResolver obtains the ip address of the clients to save it in their sessions
+	ClientIPResolver
+}
+
+// NewServeMux builds a http.ServeMux based on the Configuration and is returned as http.Handler
+func NewServeMux(config Configuration) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/go-auth/v1/authorization", NewAuthorizationHandler(grant))
-	mux.HandleFunc("/go-auth/v1/token", NewTokenHandler(grant))
+	mux.HandleFunc(AuthorizationPath, NewAuthorizationHandler(config.Authorizer, config.Responder, config.ClientIPResolver))
+	mux.HandleFunc(TokenPath, NewTokenHandler(config.Exchanger, config.ClientIPResolver))
+	mux.HandleFunc(MetadataPath, NewMetadataHandler(config.Metadata))
+	mux.HandleFunc(KeySetPath, NewKeySetHandler(config.KeySet))
+
+	if config.DeviceAuthorizer != nil {
+		mux.HandleFunc(DeviceAuthorizationPath, NewDeviceAuthorizationHandler(config.DeviceAuthorizer))
+		mux.HandleFunc(DeviceVerificationPath, NewDeviceVerificationHandler(config.DeviceAuthorizer))
+	}
+
+	if config.BackchannelAuthorizer != nil {
+		mux.HandleFunc(BackchannelPath, NewBackchannelAuthenticationHandler(config.BackchannelAuthorizer))
+		mux.HandleFunc(BackchannelApprovalPath, NewBackchannelApprovalHandler(config.BackchannelAuthorizer))
+	}
+
+	if config.TokenIntrospector != nil {
+		mux.HandleFunc(IntrospectionPath, NewIntrospectionHandler(config.TokenIntrospector))
+	}
+
+	if config.AccessManager != nil && config.AdminToken != "" {
+		mux.Handle("/go-auth/v1/admin/", NewAdminHandler(config.AccessManager, config.AdminToken))
+	}
 
 	return mux
 }
 
-// OAuthError takes a *url.URL to set OAuth errors in their query parameters
-func OAuthError(uri *url.URL, err error, description string) {
+// OAuthError builds the parameters of an OAuth error response to be rendered by the Responder
+func OAuthError(err error, description string) url.Values {
 	q := url.Values{}
 
 	q.Set("error", err.Error())
 	q.Set("error_description", description)
 
-	uri.RawQuery = q.Encode()
+	return q
 }
 
 // JSON sends serialized json data via HTTP using an instance of http.ResponseWriter
 func JSON(w http.ResponseWriter, code int, i interface{}) {
-	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(i)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(i)
 }
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// NewIntrospectionHandler creates a http.HandlerFunc using a business.TokenIntrospector to handle the
+// token introspection requests (RFC 7662)
+//
+// The client credentials are received using the basic authentication or the "client_id" and
+// "client_secret" parameters of the form
+func NewIntrospectionHandler(introspector business.TokenIntrospector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || media != "application/x-www-form-urlencoded" {
+			TokenError(w, fmt.Errorf(`%w: media "%s" is not supported`, model.InvalidRequest, media))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
+			return
+		}
+
+		// The "token_type_hint" parameter is ignored, the format of the token is detected by the introspector
+		res, err := introspector.Introspect(clientCredentials(r), r.PostForm.Get("token"))
+		if err != nil {
+			TokenError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		JSON(w, http.StatusOK, res)
+	}
+}
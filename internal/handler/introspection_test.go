@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// mockIntrospector records the clients that introspect tokens
+type mockIntrospector struct {
+	applications []model.Application
+}
+
+// Introspect records the client and reports the token as not active
+func (m *mockIntrospector) Introspect(application model.Application, _ string) (model.Map, error) {
+	m.applications = append(m.applications, application)
+	return model.Map{"active": false}, nil
+}
+
+// TestNewIntrospectionHandler checks that the client credentials are obtained from the basic authentication
+// or from the form
+func TestNewIntrospectionHandler(t *testing.T) {
+	tdt := []struct {
+		form                url.Values
+		basicAuth           bool
+		expectedApplication model.Application
+	}{
+		// client_secret_basic
+		{
+			form:                url.Values{"token": {"token"}},
+			basicAuth:           true,
+			expectedApplication: model.Application{Id: "reports", Secret: "secret"},
+		},
+		// client_secret_post
+		{
+			form:                url.Values{"token": {"token"}, "client_id": {"reports"}, "client_secret": {"secret"}},
+			expectedApplication: model.Application{Id: "reports", Secret: "secret"},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			introspector := &mockIntrospector{}
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+IntrospectionPath, strings.NewReader(v.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if v.basicAuth {
+				r.SetBasicAuth("reports", "secret")
+			}
+
+			w := httptest.NewRecorder()
+			NewIntrospectionHandler(introspector)(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf(`expected status "%d" got "%d"`, http.StatusOK, w.Code)
+			}
+
+			if len(introspector.applications) != 1 || introspector.applications[0] != v.expectedApplication {
+				t.Fatalf(`expected client "%v" got "%v"`, v.expectedApplication, introspector.applications)
+			}
+		})
+	}
+}
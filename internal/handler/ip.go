@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// ClientIPResolver obtains the ip address of the client that made a request
+//
+// If the request comes from a trusted proxy, the client ip is derived from the "Forwarded" (RFC 7239)
+// or "X-Forwarded-For" headers, otherwise the remote address of the connection is used
+type ClientIPResolver struct {
+	// TrustedProxies networks of the reverse proxies whose forwarding headers are trusted
+	TrustedProxies model.Networks
+}
+
+// Resolve returns the ip address of the client that made the request
+//
+// The forwarding headers are read from right to left skipping the trusted proxies,
+// the first untrusted address is the client ip
+func (c ClientIPResolver) Resolve(r *http.Request) model.IP {
+	ip, err := model.NewIP(r.RemoteAddr)
+	if err != nil || !c.TrustedProxies.Contains(ip) {
+		return ip
+	}
+
+	forwarded := forwardedFor(r.Header.Values("Forwarded"))
+	if len(forwarded) == 0 {
+		forwarded = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := model.NewIP(forwarded[i])
+		if err != nil {
+			// Obfuscated identifiers or "unknown" hops can not be followed
+			break
+		}
+
+		ip = hop
+
+		if !c.TrustedProxies.Contains(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedFor obtains the "for" parameters of the "Forwarded" headers (RFC 7239)
+//
+// Example:
+//
+//	Forwarded: for=192.0.2.43, for="[2001:db8:cafe::17]:4711";proto=https
+func forwardedFor(headers []string) (addresses []string) {
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+
+				addresses = append(addresses, strings.Trim(pair[4:], `"`))
+			}
+		}
+	}
+
+	return
+}
+
+// xForwardedFor obtains the addresses of the "X-Forwarded-For" headers
+func xForwardedFor(headers []string) (addresses []string) {
+	for _, header := range headers {
+		for _, address := range strings.Split(header, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestClientIPResolver_Resolve checks that the forwarding headers are only followed through the trusted proxies,
+// so the clients cannot spoof their ip address
+func TestClientIPResolver_Resolve(t *testing.T) {
+	trustedProxies, err := model.ParseNetworks("10.0.0.0/8", "2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := ClientIPResolver{TrustedProxies: trustedProxies}
+
+	tdt := []struct {
+		remoteAddr      string
+		xForwardedFor   []string
+		forwarded       []string
+		expectedAddress string
+	}{
+		// Direct connection
+		{
+			remoteAddr:      "203.0.113.7:51000",
+			expectedAddress: "203.0.113.7",
+		},
+		// The headers sent by an untrusted client are ignored
+		{
+			remoteAddr:      "203.0.113.7:51000",
+			xForwardedFor:   []string{"198.51.100.1"},
+			forwarded:       []string{"for=198.51.100.1"},
+			expectedAddress: "203.0.113.7",
+		},
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"203.0.113.7"},
+			expectedAddress: "203.0.113.7",
+		},
+		// The client prepends a spoofed address, the address added by the trusted proxy is used
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"198.51.100.1, 203.0.113.7"},
+			expectedAddress: "203.0.113.7",
+		},
+		// Chain of trusted proxies in multiple headers
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"198.51.100.1, 203.0.113.7", "10.0.0.2, 10.0.0.3"},
+			expectedAddress: "203.0.113.7",
+		},
+		// All the hops are trusted proxies
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"10.0.0.2, 10.0.0.3"},
+			expectedAddress: "10.0.0.2",
+		},
+		// The Forwarded header is preferred over X-Forwarded-For
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"198.51.100.1"},
+			forwarded:       []string{`for=198.51.100.2, For="10.0.0.2";proto=https`},
+			expectedAddress: "198.51.100.2",
+		},
+		// IPv6 with brackets and port
+		{
+			remoteAddr:      "[2001:db8:ffff::1]:443",
+			forwarded:       []string{`for="[2001:db8:cafe::17]:4711"`},
+			expectedAddress: "2001:db8:cafe::17",
+		},
+		{
+			remoteAddr:      "[2001:db8:ffff::1]:443",
+			xForwardedFor:   []string{"2001:db8:cafe::17, [2001:db8:ffff::2]:8080"},
+			expectedAddress: "2001:db8:cafe::17",
+		},
+		// Untrusted IPv6 client
+		{
+			remoteAddr:      "[2001:db8:cafe::17]:443",
+			xForwardedFor:   []string{"198.51.100.1"},
+			expectedAddress: "2001:db8:cafe::17",
+		},
+		// The garbage hops are not followed, the last valid address is used
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{"198.51.100.1, garbage"},
+			expectedAddress: "10.0.0.1",
+		},
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			forwarded:       []string{"for=198.51.100.1, for=unknown"},
+			expectedAddress: "10.0.0.1",
+		},
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			forwarded:       []string{"for=_hidden, for=10.0.0.2"},
+			expectedAddress: "10.0.0.2",
+		},
+		// Empty headers
+		{
+			remoteAddr:      "10.0.0.1:51000",
+			xForwardedFor:   []string{" , "},
+			forwarded:       []string{"proto=https"},
+			expectedAddress: "10.0.0.1",
+		},
+		// Invalid remote address
+		{
+			remoteAddr:    "garbage",
+			xForwardedFor: []string{"198.51.100.1"},
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = v.remoteAddr
+
+			for _, header := range v.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+
+			for _, header := range v.forwarded {
+				r.Header.Add("Forwarded", header)
+			}
+
+			if ip := resolver.Resolve(r); ip.String() != v.expectedAddress {
+				t.Fatalf(`expected ip "%s" got "%s"`, v.expectedAddress, ip)
+			}
+		})
+	}
+}
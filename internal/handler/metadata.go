@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// NewMetadataHandler creates a http.HandlerFunc that publishes the model.Metadata of the authorization server
+// (RFC 8414)
+func NewMetadataHandler(metadata model.Metadata) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		JSON(w, http.StatusOK, metadata)
+	}
+}
+
+// NewKeySetHandler creates a http.HandlerFunc that publishes the model.JWKSet with the public keys of the
+// authorization server (RFC 7517)
+func NewKeySetHandler(set model.JWKSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(set)
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestNewMetadataHandler checks that the metadata of the authorization server is published (RFC 8414)
+func TestNewMetadataHandler(t *testing.T) {
+	metadata := model.Metadata{
+		Issuer:                 "http://localhost:8080",
+		AuthorizationEndpoint:  "http://localhost:8080" + AuthorizationPath,
+		TokenEndpoint:          "http://localhost:8080" + TokenPath,
+		ResponseTypesSupported: []string{"code"},
+		AuthorizationResponseIssParameterSupported: true,
+	}
+
+	handler := NewMetadataHandler(metadata)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+MetadataPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf(`expected status "%d" got "%d"`, http.StatusOK, w.Code)
+	}
+
+	published := model.Metadata{}
+
+	if err := json.NewDecoder(w.Body).Decode(&published); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(metadata, published) {
+		t.Fatalf(`expected "%+v" got "%+v"`, metadata, published)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "http://localhost:8080"+MetadataPath, nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf(`expected status "%d" got "%d"`, http.StatusMethodNotAllowed, w.Code)
+	}
+}
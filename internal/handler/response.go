@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/business"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// responseLifeTime life time of the JWT that wraps the authorization response parameters (JARM)
+const responseLifeTime = 10 * time.Minute
+
+// formPostTemplate HTML page that auto-submits the authorization response parameters to the redirect uri
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{ .Action }}">
+{{- range $name, $values := .Parameters }}{{ range $values }}
+<input type="hidden" name="{{ $name }}" value="{{ . }}"/>
+{{- end }}{{ end }}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// errorTemplate HTML page shown to the owner when the error can not be sent to the redirect uri
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorization error</title></head>
+<body>
+<h1>{{ .Get "error" }}</h1>
+<p>{{ .Get "error_description" }}</p>
+</body>
+</html>
+`))
+
+// Responder renders the authorization responses (successful or not) using the model.ResponseMode requested
+// by the client
+type Responder struct {
+	// Issuer identifier of the authorization server sent as "iss" parameter of every response (RFC 9207)
+	// or as "iss" claim of JWT responses
+	Issuer string
+	// Signer signs the response parameters when a JWT response mode is requested (JARM)
+	business.Signer
+}
+
+// Respond sends the parameters to the redirect uri of the model.Authorization following its model.ResponseMode
+//
+// Supported modes: query, fragment, form_post, jwt, query.jwt, fragment.jwt and form_post.jwt
+func (res Responder) Respond(w http.ResponseWriter, r *http.Request, a model.Authorization, parameters url.Values) {
+	mode := a.ResponseMode
+	if !mode.IsValid() {
+		mode = model.QueryMode
+	}
+
+	// JWT responses carry the issuer in the "iss" claim
+	if mode.IsJWT() {
+		response, err := res.sign(a.Application.Id, parameters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		parameters = url.Values{"response": {response}}
+	} else {
+		// Issuer identification to prevent mix-up attacks (RFC 9207)
+		parameters.Set("iss", res.Issuer)
+	}
+
+	redirectURL := &[]url.URL{*a.RedirectURL}[0]
+
+	switch mode.Delivery() {
+	case model.FormPostMode:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		_ = formPostTemplate.Execute(w, struct {
+			Action     string
+			Parameters url.Values
+		}{
+			Action:     redirectURL.String(),
+			Parameters: parameters,
+		})
+		return
+
+	case model.FragmentMode:
+		redirectURL.Fragment = ""
+		http.Redirect(w, r, redirectURL.String()+"#"+parameters.Encode(), http.StatusFound)
+		return
+	}
+
+	// Preserving the query parameters sent by the client
+	query := redirectURL.Query()
+	for key, values := range parameters {
+		query[key] = values
+	}
+
+	redirectURL.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// sign wraps the response parameters in a signed JWT following the JWT Secured Authorization Response Mode (JARM)
+func (res Responder) sign(clientId string, parameters url.Values) (string, error) {
+	if res.Signer == nil {
+		return "", model.ServerError
+	}
+
+	now := time.Now()
+
+	claims := model.Map{
+		"iss": res.Issuer,
+		"aud": clientId,
+		"iat": now.Unix(),
+		"exp": now.Add(responseLifeTime).Unix(),
+	}
+
+	for key := range parameters {
+		claims[key] = parameters.Get(key)
+	}
+
+	return res.Sign(claims)
+}
+
+// RespondError renders the error parameters directly to the user agent instead of redirecting to the client
+//
+// The error is rendered as JSON if the user agent accepts "application/json", otherwise an HTML page is rendered
+func (res Responder) RespondError(w http.ResponseWriter, r *http.Request, status int, parameters url.Values) {
+	parameters.Set("iss", res.Issuer)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		body := make(map[string]string, len(parameters))
+		for key := range parameters {
+			body[key] = parameters.Get(key)
+		}
+
+		JSON(w, status, body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = errorTemplate.Execute(w, parameters)
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// responseSecret HMAC secret used by the mockSigner
+const responseSecret = "01234567890123456789012345678901"
+
+// hiddenInput matches the hidden inputs of the form_post page
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)"/>`)
+
+// mockSigner signs the claims with the HS256 algorithm
+type mockSigner struct{}
+
+// Sign signs the claims with the responseSecret
+func (mockSigner) Sign(claims model.Map) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims)).SignedString([]byte(responseSecret))
+}
+
+// responseParameters obtains the parameters of the authorization response delivered to the redirect uri with
+// the response mode, the parameters of the JWT responses are read from the claims of the "response" parameter
+func responseParameters(t *testing.T, w *httptest.ResponseRecorder, redirectURI string, mode model.ResponseMode) url.Values {
+	var parameters url.Values
+
+	switch mode.Delivery() {
+	case model.FormPostMode:
+		if w.Code != http.StatusOK {
+			t.Fatalf(`expected status "%d" got "%d"`, http.StatusOK, w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), `action="`+redirectURI+`"`) {
+			t.Fatalf(`the form is not submitted to the redirect_uri "%s"`, w.Body)
+		}
+
+		parameters = url.Values{}
+
+		for _, match := range hiddenInput.FindAllStringSubmatch(w.Body.String(), -1) {
+			parameters.Add(match[1], html.UnescapeString(match[2]))
+		}
+
+	default:
+		if w.Code != http.StatusFound {
+			t.Fatalf(`expected status "%d" got "%d"`, http.StatusFound, w.Code)
+		}
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if location.Scheme+"://"+location.Host+location.Path != strings.Split(redirectURI, "?")[0] {
+			t.Fatalf(`unexpected redirect to "%s"`, location)
+		}
+
+		parameters = location.Query()
+
+		if mode.Delivery() == model.FragmentMode {
+			parameters, err = url.ParseQuery(location.Fragment)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if !mode.IsJWT() {
+		return parameters
+	}
+
+	if parameters.Get("response") == "" || parameters.Get("code") != "" || parameters.Get("error") != "" {
+		t.Fatalf(`expected only the "response" parameter got "%v"`, parameters)
+	}
+
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(parameters.Get("response"), claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(responseSecret), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameters = url.Values{}
+
+	for key, value := range claims {
+		if s, ok := value.(string); ok {
+			parameters.Set(key, s)
+		}
+	}
+
+	return parameters
+}
+
+// TestResponder_Respond checks that the authorization responses are delivered with the requested response mode
+// and that they identify the issuer
+func TestResponder_Respond(t *testing.T) {
+	const (
+		issuer      = "http://localhost:8080"
+		redirectURI = "https://app.example.com/callback?tenant=acme"
+	)
+
+	responder := Responder{Issuer: issuer, Signer: mockSigner{}}
+
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdt := []struct {
+		mode         model.ResponseMode
+		expectedMode model.ResponseMode
+	}{
+		{
+			expectedMode: model.QueryMode,
+		},
+		// Unknown modes are delivered in the query
+		{
+			mode:         "unknown",
+			expectedMode: model.QueryMode,
+		},
+		{
+			mode:         model.QueryMode,
+			expectedMode: model.QueryMode,
+		},
+		{
+			mode:         model.FragmentMode,
+			expectedMode: model.FragmentMode,
+		},
+		{
+			mode:         model.FormPostMode,
+			expectedMode: model.FormPostMode,
+		},
+		{
+			mode:         model.JWTMode,
+			expectedMode: model.QueryJWTMode,
+		},
+		{
+			mode:         model.QueryJWTMode,
+			expectedMode: model.QueryJWTMode,
+		},
+		{
+			mode:         model.FragmentJWTMode,
+			expectedMode: model.FragmentJWTMode,
+		},
+		{
+			mode:         model.FormPostJWTMode,
+			expectedMode: model.FormPostJWTMode,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			a := model.Authorization{
+				Application:  model.Application{Id: "mobile", RedirectURL: redirectURL},
+				ResponseMode: v.mode,
+			}
+
+			w := httptest.NewRecorder()
+			responder.Respond(w, httptest.NewRequest(http.MethodGet, issuer, nil), a, url.Values{"code": {"ABC"}, "state": {"AAA"}})
+
+			parameters := responseParameters(t, w, redirectURI, v.expectedMode)
+
+			if parameters.Get("code") != "ABC" || parameters.Get("state") != "AAA" {
+				t.Fatalf(`unexpected parameters "%v"`, parameters)
+			}
+
+			// The query parameters of the redirect uri are preserved
+			if v.expectedMode == model.QueryMode && parameters.Get("tenant") != "acme" {
+				t.Fatalf(`the query of the redirect uri was not preserved "%v"`, parameters)
+			}
+
+			// The issuer is sent in every response mode (RFC 9207)
+			if parameters.Get("iss") != issuer {
+				t.Fatalf(`expected iss "%s" got "%s"`, issuer, parameters.Get("iss"))
+			}
+
+			if v.expectedMode.IsJWT() && parameters.Get("aud") != "mobile" {
+				t.Fatalf(`unexpected claims "%v"`, parameters)
+			}
+		})
+	}
+}
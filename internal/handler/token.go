@@ -5,37 +5,47 @@ import (
 	"fmt"
 	"github.com/yael-castro/goauth/internal/business"
 	"github.com/yael-castro/goauth/internal/model"
+	"log"
 	"mime"
 	"net/http"
 	"net/url"
 )
 
+// tokenErrorURI human-readable documentation of the errors returned by the token endpoint
+const tokenErrorURI = "https://datatracker.ietf.org/doc/html/rfc6749#section-5.2"
+
+// serverErrorDescription description sent for the unexpected errors, their detail is only written in the log
+const serverErrorDescription = "the authorization server encountered an unexpected condition"
+
 // NewTokenHandler handle all requests made to obtain an authorization token
 //
-// Is the HTTP handler for the token endpoint in the OAuth 2.0 framework
-func NewTokenHandler(exchanger business.CodeExchanger) http.HandlerFunc {
+// Is the HTTP handler for the token endpoint in the OAuth 2.0 framework, the ClientIPResolver
+// obtains the ip address saved in the session of the issued token
+func NewTokenHandler(exchanger business.CodeExchanger, resolver ClientIPResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
 		}
 
 		media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
 			return
 		}
 
 		if media != "application/x-www-form-urlencoded" {
-			http.Error(w, fmt.Sprintf(`media "%s" is not supported`, media), http.StatusUnsupportedMediaType)
+			TokenError(w, fmt.Errorf(`%w: media "%s" is not supported`, model.InvalidRequest, media))
 			return
 		}
 
 		if err := r.ParseForm(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			TokenError(w, fmt.Errorf("%w: %s", model.InvalidRequest, err.Error()))
 			return
 		}
 
-		redirectURL := &[]url.URL{*r.URL}[0]
+		// The redirect uri remains nil if it is missing or malformed
+		var redirectURL *url.URL
 
 		if r.Form.Get("redirect_uri") != "" {
 			redirect, err := url.Parse(r.Form.Get("redirect_uri"))
@@ -44,35 +54,94 @@ func NewTokenHandler(exchanger business.CodeExchanger) http.HandlerFunc {
 			}
 		}
 
-		// TODO support port scanning
-		ip, _ := model.NewIP(r.RemoteAddr)
+		details, err := model.ParseAuthorizationDetails(r.Form.Get("authorization_details"))
+		if err != nil {
+			TokenError(w, err)
+			return
+		}
 
 		exchange := model.Exchange{
-			GrantType: r.Form.Get("grant_type"),
-			Application: model.Application{
-				Id:          r.Form.Get("client_id"),
-				Secret:      r.Form.Get("client_secret"),
-				RedirectURL: redirectURL,
+			GrantType:            r.Form.Get("grant_type"),
+			Application:          clientCredentials(r),
+			RedirectURL:          redirectURL,
+			DeviceCode:           r.Form.Get("device_code"),
+			AuthReqId:            r.Form.Get("auth_req_id"),
+			AuthorizationCode:    model.AuthorizationCode(r.Form.Get("code")),
+			CodeVerifier:         model.CodeVerifier(r.Form.Get("code_verifier")),
+			State:                model.State(r.Form.Get("state")),
+			Scope:                r.Form.Get("scope"),
+			Assertion:            r.Form.Get("assertion"),
+			AuthorizationDetails: details,
+			Resources:            r.Form["resource"],
+			TokenExchange: model.TokenExchange{
+				SubjectToken:       r.Form.Get("subject_token"),
+				SubjectTokenType:   r.Form.Get("subject_token_type"),
+				ActorToken:         r.Form.Get("actor_token"),
+				ActorTokenType:     r.Form.Get("actor_token_type"),
+				RequestedTokenType: r.Form.Get("requested_token_type"),
+				Audience:           r.Form.Get("audience"),
 			},
-			AuthorizationCode: model.AuthorizationCode(r.Form.Get("code")),
-			CodeVerifier:      model.CodeVerifier(r.Form.Get("code_verifier")),
-			State:             model.State(r.Form.Get("state")),
 			Session: model.Session{
 				UserAgent: r.UserAgent(),
-				IP:        ip,
+				IP:        resolver.Resolve(r),
 			},
 		}
 
 		token, err := exchanger.ExchangeCode(exchange)
-		switch err := errors.Unwrap(err); err {
-		case model.UnauthorizedClient:
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		case model.InvalidRequest, model.InvalidScope, model.UnsupportedResponseType:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case nil:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		default:
-			JSON(w, http.StatusCreated, token)
+		if err != nil {
+			TokenError(w, err)
+			return
 		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		JSON(w, http.StatusOK, token)
+	}
+}
+
+// TokenError sends the error as an OAuth error response of the token endpoint (RFC 6749 section 5.2)
+//
+// The errors that are not a model.OAuthError are sent as server_error with a generic description
+func TokenError(w http.ResponseWriter, err error) {
+	oauthErr := model.ServerError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = model.ServerError
+	}
+
+	if oauthErr == model.InvalidClient {
+		w.Header().Set("WWW-Authenticate", "Basic")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	JSON(w, oauthErr.StatusCode(), model.ErrorResponse{
+		Error:            oauthErr.Error(),
+		ErrorDescription: errorDescription(oauthErr, err),
+		ErrorURI:         tokenErrorURI,
+	})
+}
+
+// errorDescription returns the description of the error sent to the client, the server errors may contain
+// internal details (e.g. storage addresses) so they are logged and a generic description is sent instead
+func errorDescription(oauthErr model.OAuthError, err error) string {
+	if oauthErr != model.ServerError {
+		return err.Error()
+	}
+
+	log.Printf("server_error: %v", err)
+	return serverErrorDescription
+}
+
+// clientCredentials obtains the credentials of the client from the basic authentication (client_secret_basic)
+// or from the "client_id" and "client_secret" parameters of the form (client_secret_post)
+func clientCredentials(r *http.Request) model.Application {
+	if username, password, ok := r.BasicAuth(); ok {
+		return model.Application{Id: username, Secret: password}
+	}
+
+	return model.Application{
+		Id:     r.Form.Get("client_id"),
+		Secret: r.Form.Get("client_secret"),
 	}
 }
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// mockExchanger returns the configured error
+type mockExchanger struct {
+	err error
+}
+
+// ExchangeCode returns a token or the configured error
+func (m mockExchanger) ExchangeCode(model.Exchange) (model.Token, error) {
+	return model.Token{Type: "Bearer", AccessToken: "token"}, m.err
+}
+
+// TestTokenError checks the error responses of the token endpoint (RFC 6749 section 5.2), the unexpected errors
+// are sent as server_error without their internal details
+func TestTokenError(t *testing.T) {
+	tdt := []struct {
+		err                 error
+		expectedStatus      int
+		expectedError       string
+		expectedDescription string
+		expectedChallenge   bool
+	}{
+		{
+			err:                 fmt.Errorf("%w: authorization code was already used", model.InvalidGrant),
+			expectedStatus:      http.StatusBadRequest,
+			expectedError:       "invalid_grant",
+			expectedDescription: "invalid_grant: authorization code was already used",
+		},
+		{
+			err:                 fmt.Errorf("%w: invalid client credentials", model.InvalidClient),
+			expectedStatus:      http.StatusUnauthorized,
+			expectedError:       "invalid_client",
+			expectedDescription: "invalid_client: invalid client credentials",
+			expectedChallenge:   true,
+		},
+		// The errors that are not OAuth errors are server errors, their details are not sent
+		{
+			err:                 errors.New("dial tcp 10.0.0.5:6379: connect: connection refused"),
+			expectedStatus:      http.StatusInternalServerError,
+			expectedError:       "server_error",
+			expectedDescription: serverErrorDescription,
+		},
+		{
+			err:                 fmt.Errorf("%w: redis: nil", model.ServerError),
+			expectedStatus:      http.StatusInternalServerError,
+			expectedError:       "server_error",
+			expectedDescription: serverErrorDescription,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			TokenError(w, v.err)
+
+			if w.Code != v.expectedStatus {
+				t.Fatalf(`expected status "%d" got "%d"`, v.expectedStatus, w.Code)
+			}
+
+			if challenge := w.Header().Get("WWW-Authenticate") != ""; challenge != v.expectedChallenge {
+				t.Fatalf(`unexpected WWW-Authenticate header "%s"`, w.Header().Get("WWW-Authenticate"))
+			}
+
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Fatal(`missing "Cache-Control: no-store" header`)
+			}
+
+			response := model.ErrorResponse{}
+
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Error != v.expectedError || response.ErrorDescription != v.expectedDescription {
+				t.Fatalf(`unexpected error response "%+v"`, response)
+			}
+		})
+	}
+}
+
+// TestNewTokenHandler checks that the invalid requests and the errors of the grants are sent as JSON error responses
+func TestNewTokenHandler(t *testing.T) {
+	tdt := []struct {
+		exchanger      mockExchanger
+		contentType    string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			contentType:    "application/x-www-form-urlencoded",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_request",
+		},
+		{
+			contentType:    "invalid;;",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_request",
+		},
+		{
+			exchanger:      mockExchanger{err: fmt.Errorf("%w: invalid code", model.InvalidGrant)},
+			contentType:    "application/x-www-form-urlencoded",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_grant",
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+TokenPath, strings.NewReader("grant_type=authorization_code"))
+			r.Header.Set("Content-Type", v.contentType)
+
+			w := httptest.NewRecorder()
+			NewTokenHandler(v.exchanger, ClientIPResolver{})(w, r)
+
+			if w.Code != v.expectedStatus {
+				t.Fatalf(`expected status "%d" got "%d"`, v.expectedStatus, w.Code)
+			}
+
+			response := model.ErrorResponse{}
+
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Error != v.expectedError {
+				t.Fatalf(`expected error "%s" got "%s"`, v.expectedError, response.Error)
+			}
+		})
+	}
+}
@@ -2,11 +2,19 @@ package model
 
 import (
 	"net/url"
-	"path"
 	"regexp"
 	"strings"
 )
 
+// Subject identifier types supported (OpenID Connect Core 1.0 section 8)
+const (
+	// PublicSubject the same subject identifier is sent to all the clients
+	PublicSubject = "public"
+	// PairwiseSubject a different subject identifier is sent to each sector, so the clients of different
+	// sectors cannot correlate the owners
+	PairwiseSubject = "pairwise"
+)
+
 // Owner represents the person owner of protected resources
 type Owner struct {
 	// Id is the owner username
@@ -20,14 +28,28 @@ type Authorization struct {
 	Application
 	// Scope one or more scope values indicating additional access requested by the application (Optional)
 	Scope string `json:"scope,omitempty"`
+	// OpenID indicates if the "openid" scope was requested, in that case an ID token is issued
+	OpenID bool `json:"openid,omitempty"`
+	// Nonce value sent in the ID token to mitigate replay attacks (Optional)
+	Nonce string `json:"nonce,omitempty"`
+	// AuthorizationDetails fine-grained permissions requested by the application (RFC 9396) (Optional)
+	AuthorizationDetails AuthorizationDetails `json:"authorizationDetails,omitempty"`
+	// Resources identifiers of the protected resources where the token will be used (RFC 8707) (Optional)
+	Resources []string `json:"resources,omitempty"`
 	// ResponseType expected response type (code, ...)
-	ResponseType        string `json:"responseType,omitempty"`
+	ResponseType string `json:"responseType,omitempty"`
+	// ResponseMode mechanism used to return the authorization response parameters (Optional)
+	ResponseMode        `json:"responseMode,omitempty"`
 	State               `json:"state,omitempty"`
 	CodeChallenge       `json:"codeChallenge,omitempty"`
 	CodeChallengeMethod `json:"codeChallengeMethod,omitempty"`
 	// BasicAuth is not explicit part of the protocol OAuth 2.0
 	// but is a way to pass the owner credentials
 	BasicAuth Owner `json:"basicAuth"`
+	// IP address of the user agent of the owner
+	IP IP `json:"ip,omitempty"`
+	// UserAgent user agent of the owner
+	UserAgent string `json:"userAgent,omitempty"`
 }
 
 // Client defines the data of allowed client to make request for the Authorization Server
@@ -38,6 +60,46 @@ type Client struct {
 	Secret string
 	// AllowedOrigins origins to which the client can be redirected
 	AllowedOrigins []string
+	// WildcardRedirect enables the wildcard subdomain patterns in AllowedOrigins (e.g. "https://*.example.com/callback")
+	WildcardRedirect bool
+	// ExchangeAudiences audiences for which the client may exchange tokens (RFC 8693)
+	ExchangeAudiences []string
+	// AllowedScopes permissions that can be granted to the client, any scope is allowed if it is nil
+	AllowedScopes Mask
+	// DefaultScope scope used when the client does not request a scope (Optional)
+	DefaultScope string
+	// BackchannelMode token delivery mode used by the client in the CIBA flow (poll by default)
+	BackchannelMode DeliveryMode
+	// BackchannelEndpoint client endpoint that receives the notifications in the ping and push modes
+	BackchannelEndpoint string
+	// TokenFormat format of the access tokens issued to the client when no resource is indicated
+	// (JWTFormat by default, OpaqueFormat or PasetoFormat)
+	TokenFormat string
+	// IDTokenEncryptedResponseAlg key management algorithm used to encrypt the ID tokens issued to the client
+	// ("RSA-OAEP-256" or "ECDH-ES"), the ID tokens are only signed if it is empty
+	IDTokenEncryptedResponseAlg string
+	// Keys public keys of the client used to encrypt the ID tokens
+	Keys JWKSet
+	// SubjectType type of the subject identifiers sent to the client (PublicSubject by default or PairwiseSubject)
+	SubjectType string
+	// SectorIdentifierURI HTTPS URL of a JSON array that contains the redirect uris of the client, its host is
+	// the sector of the pairwise subject identifiers (Optional)
+	//
+	// It is required for the pairwise clients whose redirect uris have different hosts
+	SectorIdentifierURI string
+	// Metadata additional data of the client (e.g. "tenant") that can be added to the tokens as claims
+	Metadata map[string]string
+}
+
+// CanExchangeFor indicates if the client may exchange tokens for the audience
+func (c Client) CanExchangeFor(audience string) bool {
+	for _, allowed := range c.ExchangeAudiences {
+		if allowed == audience || allowed == "*" {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Application defines the credentials of client to can make authorization requests
@@ -53,21 +115,6 @@ type Application struct {
 	RedirectURL *url.URL
 }
 
-// IsValidOrigin checks if the origin received as parameter match with some valid origin in AllowedOrigins
-func (c Client) IsValidOrigin(origin string) bool {
-	origin = path.Join(origin)
-
-	for _, allowedOrigin := range c.AllowedOrigins {
-		allowedOrigin = path.Join(allowedOrigin)
-
-		if allowedOrigin == origin {
-			return true
-		}
-	}
-
-	return false
-}
-
 // State is used by the application to store request-specific data and/or prevent CSRF attacks (Recommended)
 type State string
 
@@ -80,20 +127,119 @@ func (s State) IsValid() (ok bool) {
 // CodeChallenge code based on the CodeVerifier
 type CodeChallenge string
 
-// IsValid check if the CodeChallenge is valid
-func (c CodeChallenge) IsValid() (ok bool) {
-	ok, _ = regexp.MatchString(`^([-A-Z.a-z0-9]|_|~){43,128}$`, string(c))
-	return
+// IsValid check if the CodeChallenge is valid for the CodeChallengeMethod
+//
+// The S256 challenges are the BASE64URL (without padding) encoding of a SHA-256 hash, so they always
+// have 43 characters of the URL safe alphabet. The plain challenges follow the code_verifier syntax
+func (c CodeChallenge) IsValid(method CodeChallengeMethod) bool {
+	if method.IsS256() {
+		return s256ChallengeRegexp.MatchString(string(c))
+	}
+
+	return CodeVerifier(c).IsValid()
 }
 
 // CodeChallengeMethod is the method that the token endpoint (authorization endpoint) MUST use to verify
 // the "code_verifier"
 type CodeChallengeMethod string
 
+// Supported values for CodeChallengeMethod
+const (
+	PlainMethod CodeChallengeMethod = "plain"
+	S256Method  CodeChallengeMethod = "S256"
+)
+
 // IsValid indicates if the CodeChallengeMethod is valid
 func (m CodeChallengeMethod) IsValid() bool {
-	method := strings.ToLower(string(m))
-	return method == "plain" || method == "s256"
+	return m.IsPlain() || m.IsS256()
+}
+
+// IsPlain indicates if the CodeChallengeMethod is plain
+//
+// Note: an empty CodeChallengeMethod is NOT plain, the default value must be resolved by the caller
+func (m CodeChallengeMethod) IsPlain() bool {
+	return strings.EqualFold(string(m), string(PlainMethod))
 }
 
+// IsS256 indicates if the CodeChallengeMethod is S256
+func (m CodeChallengeMethod) IsS256() bool {
+	return strings.EqualFold(string(m), string(S256Method))
+}
+
+// Regular expressions used to validate the PKCE codes (RFC 7636)
+var (
+	// codeVerifierRegexp code_verifier = 43*128unreserved
+	codeVerifierRegexp = regexp.MustCompile(`^[-A-Za-z0-9._~]{43,128}$`)
+	// s256ChallengeRegexp BASE64URL-ENCODE(SHA256(ASCII(code_verifier)))
+	s256ChallengeRegexp = regexp.MustCompile(`^[-A-Za-z0-9_]{43}$`)
+)
+
 type AuthorizationCode string
+
+// ResponseMode informs the authorization server of the mechanism to be used for returning
+// authorization response parameters from the authorization endpoint
+type ResponseMode string
+
+// Supported values for ResponseMode
+const (
+	// QueryMode encodes the response parameters in the query string of the redirect uri
+	QueryMode ResponseMode = "query"
+	// FragmentMode encodes the response parameters in the fragment of the redirect uri
+	FragmentMode ResponseMode = "fragment"
+	// FormPostMode encodes the response parameters as HTML form values auto-submitted by the user agent
+	FormPostMode ResponseMode = "form_post"
+	// JWTMode is the shortcut to the default JWT response mode of the response type (query.jwt for code)
+	JWTMode ResponseMode = "jwt"
+	// QueryJWTMode sends the signed JWT response in the query string of the redirect uri
+	QueryJWTMode ResponseMode = "query.jwt"
+	// FragmentJWTMode sends the signed JWT response in the fragment of the redirect uri
+	FragmentJWTMode ResponseMode = "fragment.jwt"
+	// FormPostJWTMode sends the signed JWT response as HTML form value auto-submitted by the user agent
+	FormPostJWTMode ResponseMode = "form_post.jwt"
+)
+
+// IsValid indicates if the ResponseMode is supported
+func (m ResponseMode) IsValid() bool {
+	switch m {
+	case QueryMode, FragmentMode, FormPostMode, JWTMode, QueryJWTMode, FragmentJWTMode, FormPostJWTMode:
+		return true
+	}
+
+	return false
+}
+
+// IsJWT indicates if the response parameters must be wrapped in a signed JWT following
+// the JWT Secured Authorization Response Mode (JARM)
+func (m ResponseMode) IsJWT() bool {
+	return m == JWTMode || strings.HasSuffix(string(m), ".jwt")
+}
+
+// Delivery returns the mechanism used to deliver the response parameters (query, fragment or form_post)
+//
+// If the ResponseMode is empty or invalid the default mode for the code response type (query) is returned
+func (m ResponseMode) Delivery() ResponseMode {
+	switch m {
+	case FragmentMode, FragmentJWTMode:
+		return FragmentMode
+	case FormPostMode, FormPostJWTMode:
+		return FormPostMode
+	}
+
+	return QueryMode
+}
+
+// OpenIDScope scope value that requests an ID token
+const OpenIDScope = "openid"
+
+// SplitOpenIDScope removes the "openid" value from the scope and indicates if it was present
+func SplitOpenIDScope(scope string) (string, bool) {
+	values := strings.Fields(scope)
+
+	for i, v := range values {
+		if v == OpenIDScope {
+			return strings.Join(append(values[:i:i], values[i+1:]...), " "), true
+		}
+	}
+
+	return scope, false
+}
@@ -0,0 +1,77 @@
+package model
+
+import "time"
+
+// CIBAGrantType grant_type used to obtain the tokens in the Client-Initiated Backchannel Authentication flow
+const CIBAGrantType = "urn:openid:params:grant-type:ciba"
+
+// DeliveryMode mode used to deliver the tokens to the client in the CIBA flow
+type DeliveryMode string
+
+// Supported values for DeliveryMode
+const (
+	// PollDelivery the client polls the token endpoint until the owner completes the request
+	PollDelivery DeliveryMode = "poll"
+	// PingDelivery the client is notified when the owner completes the request and then calls the token endpoint
+	PingDelivery DeliveryMode = "ping"
+	// PushDelivery the tokens are sent to the client when the owner completes the request
+	PushDelivery DeliveryMode = "push"
+)
+
+// IsValid indicates if the DeliveryMode is supported
+func (d DeliveryMode) IsValid() bool {
+	switch d {
+	case PollDelivery, PingDelivery, PushDelivery:
+		return true
+	}
+
+	return false
+}
+
+// BackchannelStatus status of a BackchannelAuthorization
+type BackchannelStatus string
+
+// Supported values for BackchannelStatus
+const (
+	// BackchannelPending the owner has not approved or denied the request
+	BackchannelPending BackchannelStatus = "pending"
+	// BackchannelApproved the owner approved the request
+	BackchannelApproved BackchannelStatus = "approved"
+	// BackchannelDenied the owner denied the request
+	BackchannelDenied BackchannelStatus = "denied"
+)
+
+// BackchannelAuthorization authentication request started by a client in the CIBA flow
+type BackchannelAuthorization struct {
+	Application
+	// Scope one or more scope values indicating the access requested by the client (Optional)
+	Scope string `json:"scope,omitempty"`
+	// LoginHint identifier of the owner to be authenticated
+	LoginHint string `json:"loginHint"`
+	// BindingMessage message displayed on both the consumption device and the device of the owner (Optional)
+	BindingMessage string `json:"bindingMessage,omitempty"`
+	// ClientNotificationToken bearer token used by the server to notify the client (required in ping and push modes)
+	ClientNotificationToken string `json:"clientNotificationToken,omitempty"`
+	// AuthReqId identifier of the authentication request
+	AuthReqId string `json:"authReqId"`
+	// Mode token delivery mode of the client
+	Mode DeliveryMode `json:"mode"`
+	// NotificationEndpoint client endpoint that receives the notifications in ping and push modes
+	NotificationEndpoint string `json:"notificationEndpoint,omitempty"`
+	// Status indicates if the owner approved or denied the request
+	Status BackchannelStatus `json:"status"`
+	// ExpiresAt moment when the authentication request expires
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Interval minimum amount of time that the client should wait between polling requests
+	Interval time.Duration `json:"interval"`
+}
+
+// BackchannelResponse response of the backchannel authentication endpoint
+type BackchannelResponse struct {
+	// AuthReqId identifier of the authentication request
+	AuthReqId string `json:"auth_req_id"`
+	// ExpiresIn lifetime in seconds of the auth_req_id
+	ExpiresIn int64 `json:"expires_in"`
+	// Interval minimum amount of time in seconds that the client should wait between polling requests
+	Interval int64 `json:"interval,omitempty"`
+}
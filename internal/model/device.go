@@ -0,0 +1,81 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// DeviceCodeGrantType grant_type used to poll the token endpoint in the Device Authorization Grant (RFC 8628)
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceStatus status of a DeviceAuthorization
+type DeviceStatus string
+
+// Supported values for DeviceStatus
+const (
+	// DevicePending the owner has not approved or denied the request
+	DevicePending DeviceStatus = "pending"
+	// DeviceApproved the owner approved the request
+	DeviceApproved DeviceStatus = "approved"
+	// DeviceDenied the owner denied the request
+	DeviceDenied DeviceStatus = "denied"
+)
+
+// DeviceAuthorization request of authorization made by a device with limited input capabilities (RFC 8628)
+type DeviceAuthorization struct {
+	Application
+	// Scope one or more scope values indicating additional access requested by the application (Optional)
+	Scope string `json:"scope,omitempty"`
+	// DeviceCode verification code used by the device to poll the token endpoint
+	DeviceCode string `json:"deviceCode"`
+	// UserCode end-user verification code entered by the owner in the verification page
+	UserCode `json:"userCode"`
+	// Status indicates if the owner approved or denied the request
+	Status DeviceStatus `json:"status"`
+	// Owner who approved the request
+	Owner Owner `json:"owner"`
+	// ExpiresAt moment when the device code expires
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Interval minimum amount of time that the device should wait between polling requests
+	Interval time.Duration `json:"interval"`
+}
+
+// Poll polling request made by a device or a client to the token endpoint, the polling state is saved apart from
+// the pending request, so the polling requests never overwrite the decision of the owner
+type Poll struct {
+	// At moment of the polling request
+	At time.Time
+	// Interval minimum amount of time between polling requests until the client polls too fast
+	Interval time.Duration
+	// SlowDown amount of time added to the interval each time the client polls too fast
+	SlowDown time.Duration
+	// ExpiresAt moment when the polling state is removed (expiration of the pending request)
+	ExpiresAt time.Time
+}
+
+// DeviceCodeResponse response of the device authorization endpoint (RFC 8628 section 3.2)
+type DeviceCodeResponse struct {
+	// DeviceCode device verification code
+	DeviceCode string `json:"device_code"`
+	// UserCode end-user verification code
+	UserCode `json:"user_code"`
+	// VerificationURI end-user verification URI on the authorization server
+	VerificationURI string `json:"verification_uri"`
+	// VerificationURIComplete verification URI that includes the user_code
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn lifetime in seconds of the device_code and user_code
+	ExpiresIn int64 `json:"expires_in"`
+	// Interval minimum amount of time in seconds that the client should wait between polling requests
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// UserCode end-user verification code of the Device Authorization Grant
+type UserCode string
+
+// Normalize removes the characters added to improve the readability and typing of the UserCode
+// (dashes and spaces) and transforms it to upper case
+//
+// Example: "wdjb-mjht" => "WDJBMJHT"
+func (u UserCode) Normalize() UserCode {
+	return UserCode(strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(string(u))))
+}
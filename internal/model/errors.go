@@ -1,6 +1,6 @@
 package model
 
-import "fmt"
+import "net/http"
 
 // ValidationError error caused by wrong validation
 type ValidationError string
@@ -34,35 +34,72 @@ func (d DuplicateRecord) Error() string {
 	return string(d)
 }
 
+// UntrustedRedirect error caused before the client and its redirect uri were validated
+//
+// The errors of this type must be shown to the owner by the authorization server instead of being
+// sent to the redirect uri (RFC 6749 section 4.1.2.1)
+type UntrustedRedirect struct {
+	Err error
+}
+
+// Error returns the string value of the wrapped error
+func (u UntrustedRedirect) Error() string {
+	return u.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (u UntrustedRedirect) Unwrap() error {
+	return u.Err
+}
+
 // _ "implement" constraint for OAuthError
 var _ error = OAuthError(0)
 
+// OAuthError error codes of the OAuth Extensions Error Registry maintained by IANA
 type OAuthError uint
 
-// Error parsing the integer value to
+// Error returns the error code registered for the OAuthError
 func (e OAuthError) Error() string {
 	return e.String()
 }
 
+// String returns the error code registered for the OAuthError
+//
+// Unknown values are reported as "server_error"
 func (e OAuthError) String() string {
-	switch e {
-	case InvalidRequest:
-		return "invalid_request"
-	case AccessDenied:
-		return "access_denied"
-	case UnauthorizedClient:
-		return "unauthorized_client"
-	case UnsupportedResponseType:
-		return "unsupported_response_type"
-	case InvalidScope:
-		return "invalid_scope"
-	case ServerError:
-		return "server_error"
-	case TemporarilyUnavailable:
-		return "temporarily_unavailable"
+	return e.entry().code
+}
+
+// StatusCode returns the HTTP status code that corresponds to the OAuthError when it is returned
+// directly to the client (e.g. by the token endpoint)
+func (e OAuthError) StatusCode() int {
+	return e.entry().status
+}
+
+// IsRedirectable indicates if the OAuthError may be sent to the redirect uri of the client
+// as an authorization error response
+func (e OAuthError) IsRedirectable() bool {
+	return e.entry().redirectable
+}
+
+// entry returns the registry entry of the OAuthError, unknown values are treated as ServerError
+func (e OAuthError) entry() oauthErrorEntry {
+	if int(e) >= len(oauthErrors) {
+		return oauthErrors[ServerError]
+	}
+
+	return oauthErrors[e]
+}
+
+// ParseOAuthError returns the OAuthError registered with the error code
+func ParseOAuthError(code string) (OAuthError, bool) {
+	for e, entry := range oauthErrors {
+		if entry.code == code {
+			return OAuthError(e), true
+		}
 	}
 
-	panic(fmt.Sprintf(`value "%d" is not supported`, e))
+	return ServerError, false
 }
 
 // Supported values for OAuthError
@@ -86,4 +123,137 @@ const (
 	// TemporarilyUnavailable if the server is undergoing maintenance, or is otherwise unavailable,
 	// this error code can be returned instead of responding with a 503 Service Unavailable status code
 	TemporarilyUnavailable
+	// InvalidClient client authentication failed (e.g., unknown client, no client authentication included,
+	// or unsupported authentication method)
+	InvalidClient
+	// InvalidGrant the provided authorization grant (e.g., authorization code) is invalid, expired, revoked,
+	// does not match the redirection URI used in the authorization request, or was issued to another client
+	InvalidGrant
+	// UnsupportedGrantType the authorization grant type is not supported by the authorization server
+	UnsupportedGrantType
+	// InvalidToken the access token provided is expired, revoked, malformed, or invalid (RFC 6750)
+	InvalidToken
+	// InsufficientScope the request requires higher privileges than provided by the access token (RFC 6750)
+	InsufficientScope
+	// UnsupportedTokenType the authorization server does not support the revocation of the presented
+	// token type (RFC 7009)
+	UnsupportedTokenType
+	// InteractionRequired the authorization server requires end-user interaction of some form to proceed (OIDC)
+	InteractionRequired
+	// LoginRequired the authorization server requires end-user authentication (OIDC)
+	LoginRequired
+	// AccountSelectionRequired the end-user is required to select a session at the authorization server (OIDC)
+	AccountSelectionRequired
+	// ConsentRequired the authorization server requires end-user consent (OIDC)
+	ConsentRequired
+	// InvalidRequestURI the request_uri in the authorization request returns an error or contains invalid data
+	InvalidRequestURI
+	// InvalidRequestObject the request parameter contains an invalid request object
+	InvalidRequestObject
+	// RequestNotSupported the authorization server does not support use of the request parameter
+	RequestNotSupported
+	// RequestURINotSupported the authorization server does not support use of the request_uri parameter
+	RequestURINotSupported
+	// RegistrationNotSupported the authorization server does not support use of the registration parameter
+	RegistrationNotSupported
+	// InvalidRedirectURI the value of one or more redirection URIs is invalid (RFC 7591)
+	InvalidRedirectURI
+	// InvalidClientMetadata the value of one of the client metadata fields is invalid (RFC 7591)
+	InvalidClientMetadata
+	// InvalidSoftwareStatement the software statement presented is invalid (RFC 7591)
+	InvalidSoftwareStatement
+	// UnapprovedSoftwareStatement the software statement presented is not approved (RFC 7591)
+	UnapprovedSoftwareStatement
+	// AuthorizationPending the authorization request is still pending as the end user hasn't yet
+	// completed the user-interaction steps (RFC 8628 and CIBA)
+	AuthorizationPending
+	// SlowDown the authorization request is still pending and polling should continue, but the interval
+	// must be increased (RFC 8628 and CIBA)
+	SlowDown
+	// ExpiredToken the device_code or auth_req_id has expired (RFC 8628 and CIBA)
+	ExpiredToken
+	// InvalidTarget the requested resource or audience is invalid, unknown, or malformed (RFC 8707 and RFC 8693)
+	InvalidTarget
+	// InvalidDPoPProof the DPoP proof is invalid (RFC 9449)
+	InvalidDPoPProof
+	// UseDPoPNonce the authorization server requires a nonce in the DPoP proof (RFC 9449)
+	UseDPoPNonce
+	// InvalidAuthorizationDetails the authorization_details parameter is invalid (RFC 9396)
+	InvalidAuthorizationDetails
+	// InsufficientUserAuthentication the authentication event does not meet the requirements of the
+	// protected resource (RFC 9470)
+	InsufficientUserAuthentication
+	// UnknownUserId the authorization server is not able to identify the end-user (CIBA)
+	UnknownUserId
+	// MissingUserCode user_code is required but was missing from the request (CIBA)
+	MissingUserCode
+	// InvalidUserCode user_code was invalid (CIBA)
+	InvalidUserCode
+	// InvalidBindingMessage the binding message is invalid or unacceptable (CIBA)
+	InvalidBindingMessage
+	// TransactionFailed the transaction failed due to an unexpected condition (CIBA)
+	TransactionFailed
 )
+
+// oauthErrorEntry entry of the OAuth error registry
+type oauthErrorEntry struct {
+	// code registered error code
+	code string
+	// status HTTP status code used when the error is returned directly to the client
+	status int
+	// redirectable indicates if the error may be sent to the redirect uri as authorization error response
+	redirectable bool
+}
+
+// oauthErrors registry of the OAuth errors indexed by OAuthError
+var oauthErrors = [...]oauthErrorEntry{
+	InvalidRequest:                 {"invalid_request", http.StatusBadRequest, true},
+	AccessDenied:                   {"access_denied", http.StatusBadRequest, true},
+	UnauthorizedClient:             {"unauthorized_client", http.StatusBadRequest, true},
+	UnsupportedResponseType:        {"unsupported_response_type", http.StatusBadRequest, true},
+	InvalidScope:                   {"invalid_scope", http.StatusBadRequest, true},
+	ServerError:                    {"server_error", http.StatusInternalServerError, true},
+	TemporarilyUnavailable:         {"temporarily_unavailable", http.StatusServiceUnavailable, true},
+	InvalidClient:                  {"invalid_client", http.StatusUnauthorized, false},
+	InvalidGrant:                   {"invalid_grant", http.StatusBadRequest, false},
+	UnsupportedGrantType:           {"unsupported_grant_type", http.StatusBadRequest, false},
+	InvalidToken:                   {"invalid_token", http.StatusUnauthorized, false},
+	InsufficientScope:              {"insufficient_scope", http.StatusForbidden, false},
+	UnsupportedTokenType:           {"unsupported_token_type", http.StatusBadRequest, false},
+	InteractionRequired:            {"interaction_required", http.StatusBadRequest, true},
+	LoginRequired:                  {"login_required", http.StatusBadRequest, true},
+	AccountSelectionRequired:       {"account_selection_required", http.StatusBadRequest, true},
+	ConsentRequired:                {"consent_required", http.StatusBadRequest, true},
+	InvalidRequestURI:              {"invalid_request_uri", http.StatusBadRequest, true},
+	InvalidRequestObject:           {"invalid_request_object", http.StatusBadRequest, true},
+	RequestNotSupported:            {"request_not_supported", http.StatusBadRequest, true},
+	RequestURINotSupported:         {"request_uri_not_supported", http.StatusBadRequest, true},
+	RegistrationNotSupported:       {"registration_not_supported", http.StatusBadRequest, true},
+	InvalidRedirectURI:             {"invalid_redirect_uri", http.StatusBadRequest, false},
+	InvalidClientMetadata:          {"invalid_client_metadata", http.StatusBadRequest, false},
+	InvalidSoftwareStatement:       {"invalid_software_statement", http.StatusBadRequest, false},
+	UnapprovedSoftwareStatement:    {"unapproved_software_statement", http.StatusBadRequest, false},
+	AuthorizationPending:           {"authorization_pending", http.StatusBadRequest, false},
+	SlowDown:                       {"slow_down", http.StatusBadRequest, false},
+	ExpiredToken:                   {"expired_token", http.StatusBadRequest, false},
+	InvalidTarget:                  {"invalid_target", http.StatusBadRequest, true},
+	InvalidDPoPProof:               {"invalid_dpop_proof", http.StatusBadRequest, false},
+	UseDPoPNonce:                   {"use_dpop_nonce", http.StatusBadRequest, false},
+	InvalidAuthorizationDetails:    {"invalid_authorization_details", http.StatusBadRequest, true},
+	InsufficientUserAuthentication: {"insufficient_user_authentication", http.StatusUnauthorized, false},
+	UnknownUserId:                  {"unknown_user_id", http.StatusBadRequest, false},
+	MissingUserCode:                {"missing_user_code", http.StatusBadRequest, false},
+	InvalidUserCode:                {"invalid_user_code", http.StatusBadRequest, false},
+	InvalidBindingMessage:          {"invalid_binding_message", http.StatusBadRequest, false},
+	TransactionFailed:              {"transaction_failed", http.StatusBadRequest, false},
+}
+
+// ErrorResponse body of the OAuth error responses returned directly to the client (RFC 6749 section 5.2)
+type ErrorResponse struct {
+	// Error ASCII error code
+	Error string `json:"error"`
+	// ErrorDescription human-readable text providing additional information
+	ErrorDescription string `json:"error_description,omitempty"`
+	// ErrorURI URI identifying a human-readable web page with information about the error
+	ErrorURI string `json:"error_uri,omitempty"`
+}
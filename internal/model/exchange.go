@@ -1,11 +1,13 @@
 package model
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"github.com/golang-jwt/jwt"
 	"net/url"
-	"strings"
 	"time"
 )
 
@@ -17,6 +19,20 @@ type Exchange struct {
 	CodeVerifier
 	State
 	RedirectURL *url.URL
+	// DeviceCode device verification code of the Device Authorization Grant (RFC 8628)
+	DeviceCode string
+	// AuthReqId identifier of the authentication request of the CIBA flow
+	AuthReqId string
+	// Scope requested scope (Optional)
+	Scope string
+	// AuthorizationDetails subset of the authorized authorization_details requested for the token (RFC 9396) (Optional)
+	AuthorizationDetails AuthorizationDetails
+	// Resources identifiers of the protected resources where the token will be used (RFC 8707) (Optional)
+	Resources []string
+	// Assertion signed JWT of the JWT Bearer grant (RFC 7523)
+	Assertion string
+	// TokenExchange parameters of the Token Exchange grant (RFC 8693)
+	TokenExchange
 	// Session metadata of client
 	// Is NOT part of the OAuth 2.0 protocol
 	Session
@@ -33,32 +49,76 @@ type Token struct {
 	AccessToken string `json:"access_token,omitempty"`
 	// Scope represents the permissions that the token have
 	Scope interface{} `json:"scope,omitempty"`
-	// ExpiresIn token lifetime
-	ExpiresIn *time.Duration `json:"expires_in,omitempty"`
+	// ExpiresIn token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// AuthorizationDetails fine-grained permissions granted to the token (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `json:"authorization_details,omitempty"`
+	// IssuedTokenType identifier of the representation of the issued token (RFC 8693)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	// IdToken token that contains the claims about the authentication of the owner, issued when
+	// the "openid" scope is requested
+	IdToken string `json:"id_token,omitempty"`
+}
+
+// Token type identifiers (RFC 8693 section 3)
+const (
+	AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	JWTTokenType    = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchangeGrantType grant_type of the Token Exchange grant (RFC 8693)
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// JWTBearerGrantType grant_type of the JWT Bearer authorization grant (RFC 7523)
+const JWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// TokenExchange parameters of a token exchange request (RFC 8693 section 2.1)
+type TokenExchange struct {
+	// SubjectToken token that represents the identity of the party on behalf of whom the request is being made
+	SubjectToken string
+	// SubjectTokenType identifier of the type of the SubjectToken
+	SubjectTokenType string
+	// ActorToken token that represents the identity of the acting party (Optional)
+	ActorToken string
+	// ActorTokenType identifier of the type of the ActorToken (required if ActorToken is defined)
+	ActorTokenType string
+	// RequestedTokenType identifier of the type of the requested token (Optional)
+	RequestedTokenType string
+	// Audience logical name of the target service where the client intends to use the requested token (Optional)
+	Audience string
 }
 
 // CodeVerifier is the code which the CodeChallenge is generated
 type CodeVerifier string
 
-// IsValid receives a code and method to check if the CodeVerifier match to the CodeChallengeMethod
-func (v CodeVerifier) IsValid(code CodeChallenge, method CodeChallengeMethod) bool {
-	method = CodeChallengeMethod(strings.ToUpper(string(method)))
-
-	if method == "PLAIN" {
-		return code == CodeChallenge(v)
-	}
+// IsValid indicates if the CodeVerifier has between 43 and 128 characters of the unreserved
+// characters [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~"
+func (v CodeVerifier) IsValid() bool {
+	return codeVerifierRegexp.MatchString(string(v))
+}
 
-	hash := sha256.New().Sum([]byte(code))
+// Verify receives a code and method to check if the CodeVerifier match to the CodeChallenge
+//
+// S256: BASE64URL-ENCODE(SHA256(ASCII(code_verifier))) == code_challenge
+//
+// plain: code_verifier == code_challenge
+func (v CodeVerifier) Verify(challenge CodeChallenge, method CodeChallengeMethod) bool {
+	expected := string(v)
 
-	challenge := base64.URLEncoding.EncodeToString(hash)
+	if method.IsS256() {
+		hash := sha256.Sum256([]byte(v))
+		expected = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
 
-	return challenge == string(code)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
 }
 
 // Session details about the owner session
 type Session struct {
-	// IP address v4
-	IP
+	// IP address v4 or v6 of the client
+	//
+	// Note: it is not embedded because the promoted MarshalText method would serialize the Session as the IP
+	IP IP `json:"ip,omitempty"`
 	// Owner who is owner of this session
 	Owner
 	// TokenId is the token identifier like JTI
@@ -67,6 +127,8 @@ type Session struct {
 	UserAgent string
 	// Expiration Token Lifetime
 	Expiration time.Duration
+	// Claims of the opaque token referenced by the session (Optional)
+	Claims *JWT `json:"claims,omitempty"`
 }
 
 // StandardClaims alias for jwt.StandardClaims
@@ -76,5 +138,87 @@ type StandardClaims = jwt.StandardClaims
 type JWT struct {
 	StandardClaims
 	// Scope indicates the permissions that the JWT has
-	Scope interface{} `json:"scp"`
+	Scope interface{} `json:"scp,omitempty"`
+	// AuthorizationDetails fine-grained permissions granted to the token (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `json:"authorization_details,omitempty"`
+	// Actor identifies the acting party to whom authority has been delegated (RFC 8693 section 4.1)
+	Actor *Actor `json:"act,omitempty"`
+	// Claims additional claims (e.g. "email" or "nonce"), they never replace the claims defined above
+	Claims Map `json:"-"`
+}
+
+// registeredClaims names of the claims defined by the fields of the JWT
+var registeredClaims = []string{"aud", "exp", "jti", "iat", "iss", "nbf", "sub", "scp", "authorization_details", "act"}
+
+// UnmarshalJSON deserializes the claims of the JWT, the claims that are not defined by the fields are saved
+// in the additional Claims
+//
+// The numbers are decoded as json.Number to keep the precision of the bit masks
+func (j *JWT) UnmarshalJSON(b []byte) error {
+	// The alias does not have the UnmarshalJSON method
+	type claims JWT
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	if err := decoder.Decode((*claims)(j)); err != nil {
+		return err
+	}
+
+	m := Map{}
+
+	decoder = json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&m); err != nil {
+		return err
+	}
+
+	for _, claim := range registeredClaims {
+		delete(m, claim)
+	}
+
+	j.Claims = nil
+	if len(m) > 0 {
+		j.Claims = m
+	}
+
+	return nil
+}
+
+// MarshalJSON serializes the claims of the JWT including the additional Claims
+func (j JWT) MarshalJSON() ([]byte, error) {
+	// The alias does not have the MarshalJSON method
+	type claims JWT
+
+	b, err := json.Marshal(claims(j))
+	if err != nil || len(j.Claims) == 0 {
+		return b, err
+	}
+
+	// The numbers are decoded as json.Number, otherwise the bit masks greater than 2^53 lose precision
+	m := Map{}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	if err = decoder.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	for k, v := range j.Claims {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+// Actor claim that identifies the acting party, the nested actors represent the prior actors of the delegation chain
+type Actor struct {
+	// Subject identifier of the acting party
+	Subject string `json:"sub"`
+	// Actor prior acting party
+	Actor *Actor `json:"act,omitempty"`
 }
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK JSON Web Key (RFC 7517)
+type JWK struct {
+	// KeyType cryptographic algorithm family used with the key ("RSA", "EC" or "OKP")
+	KeyType string `json:"kty"`
+	// KeyId identifier of the key
+	KeyId string `json:"kid,omitempty"`
+	// Use intended use of the key ("sig" or "enc")
+	Use string `json:"use,omitempty"`
+	// Algorithm algorithm intended for use with the key
+	Algorithm string `json:"alg,omitempty"`
+	// N modulus of a RSA key
+	N string `json:"n,omitempty"`
+	// E exponent of a RSA key
+	E string `json:"e,omitempty"`
+	// Curve curve of an EC or OKP key
+	Curve string `json:"crv,omitempty"`
+	// X coordinate of an EC key or public key of an OKP key
+	X string `json:"x,omitempty"`
+	// Y coordinate of an EC key
+	Y string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the public key contained in the JWK
+//
+// Supported keys: RSA, EC (P-256, P-384 and P-521) and OKP (Ed25519)
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf(`invalid exponent of key "%s"`, j.KeyId)
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf(`unsupported curve "%s"`, j.Curve)
+		}
+
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf(`point of key "%s" is not on the curve`, j.KeyId)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf(`unsupported curve "%s"`, j.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf(`invalid public key "%s"`, j.KeyId)
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf(`unsupported key type "%s"`, j.KeyType)
+}
+
+// NewJWK encodes a public key as JWK, the key id, the use and the algorithm must be set by the caller
+//
+// Supported keys: *rsa.PublicKey, *ecdsa.PublicKey (P-256, P-384 and P-521) and ed25519.PublicKey
+func NewJWK(key crypto.PublicKey) (JWK, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+
+		switch params.Name {
+		case "P-256", "P-384", "P-521":
+		default:
+			return JWK{}, fmt.Errorf(`unsupported curve "%s"`, params.Name)
+		}
+
+		// The coordinates have the length of the curve size (RFC 7518 section 6.2.1.2)
+		size := (params.BitSize + 7) / 8
+
+		return JWK{
+			KeyType: "EC",
+			Curve:   params.Name,
+			X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+
+	return JWK{}, fmt.Errorf("unsupported public key of type %T", key)
+}
+
+// Thumbprint returns the JWK Thumbprint (RFC 7638) encoded in base64 (URL), the hash (SHA-256) is computed over
+// the required members of the key in lexicographic order
+func (j JWK) Thumbprint() (string, error) {
+	var members string
+
+	switch j.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, j.E, j.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, j.Curve, j.X, j.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, j.Curve, j.X)
+	default:
+		return "", fmt.Errorf(`unsupported key type "%s"`, j.KeyType)
+	}
+
+	hash := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// JWKSet JSON Web Key Set (RFC 7517 section 5)
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key search a key by key id, if the key id is empty and the set contains only one key that key is returned
+func (s JWKSet) Key(kid string) (JWK, bool) {
+	if kid == "" && len(s.Keys) == 1 {
+		return s.Keys[0], true
+	}
+
+	for _, key := range s.Keys {
+		if key.KeyId == kid {
+			return key, true
+		}
+	}
+
+	return JWK{}, false
+}
+
+// decodeBigInt decodes an unsigned integer encoded in base64 (URL)
+func decodeBigInt(str string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf(`invalid base64url integer "%s"`, str)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -1,14 +1,56 @@
 package model
 
 import (
+	"encoding"
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"net"
 	"strings"
 )
 
 type Map = map[string]interface{}
 
+// Metadata describes the configuration of the authorization server (RFC 8414)
+type Metadata struct {
+	// Issuer authorization server's issuer identifier
+	Issuer string `json:"issuer"`
+	// AuthorizationEndpoint URL of the authorization endpoint
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	// TokenEndpoint URL of the token endpoint
+	TokenEndpoint string `json:"token_endpoint"`
+	// DeviceAuthorizationEndpoint URL of the device authorization endpoint (RFC 8628)
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+	// ResponseTypesSupported response_type values supported by the authorization server
+	ResponseTypesSupported []string `json:"response_types_supported"`
+	// ResponseModesSupported response_mode values supported by the authorization server
+	ResponseModesSupported []string `json:"response_modes_supported,omitempty"`
+	// ScopesSupported named scopes supported by the authorization server
+	ScopesSupported []string `json:"scopes_supported,omitempty"`
+	// GrantTypesSupported grant_type values supported by the authorization server
+	GrantTypesSupported []string `json:"grant_types_supported,omitempty"`
+	// CodeChallengeMethodsSupported PKCE code_challenge_method values supported by the authorization server
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+	// AuthorizationDetailsTypesSupported authorization details types supported by the authorization server (RFC 9396)
+	AuthorizationDetailsTypesSupported []string `json:"authorization_details_types_supported,omitempty"`
+	// JWKSURI URL of the key set that contains the public keys used to verify the tokens
+	JWKSURI string `json:"jwks_uri,omitempty"`
+	// IDTokenEncryptionAlgValuesSupported key management algorithms supported to encrypt the ID tokens
+	IDTokenEncryptionAlgValuesSupported []string `json:"id_token_encryption_alg_values_supported,omitempty"`
+	// IDTokenEncryptionEncValuesSupported content encryption algorithms supported to encrypt the ID tokens
+	IDTokenEncryptionEncValuesSupported []string `json:"id_token_encryption_enc_values_supported,omitempty"`
+	// SubjectTypesSupported subject identifier types supported by the authorization server
+	SubjectTypesSupported []string `json:"subject_types_supported,omitempty"`
+	// IntrospectionEndpoint URL of the token introspection endpoint (RFC 7662)
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+	// BackchannelAuthenticationEndpoint URL of the backchannel authentication endpoint (CIBA)
+	BackchannelAuthenticationEndpoint string `json:"backchannel_authentication_endpoint,omitempty"`
+	// BackchannelTokenDeliveryModesSupported token delivery modes supported in the CIBA flow
+	BackchannelTokenDeliveryModesSupported []string `json:"backchannel_token_delivery_modes_supported,omitempty"`
+	// AuthorizationResponseIssParameterSupported indicates if the "iss" parameter is sent in the
+	// authorization responses (RFC 9207)
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported"`
+}
+
 // BinaryJSON json serializer that implements encoding.BinaryMarshaler
 type BinaryJSON struct {
 	// I embed data to later be serialized
@@ -23,43 +65,125 @@ func (b BinaryJSON) MarshalBinary() ([]byte, error) {
 // Mask defines a mask that contains multiple bit masks
 type Mask map[string]uint64
 
+// ScopeDefinition human-readable scope that grants bits of the mask of a resource
+type ScopeDefinition struct {
+	// Name of the scope (e.g. "orders.read")
+	Name string `json:"name"`
+	// Resource key of the Mask where the bits are granted (e.g. "orders")
+	Resource string `json:"resource"`
+	// Bits granted by the scope
+	Bits uint64 `json:"bits"`
+	// Description of the scope to be shown to the owner
+	Description string `json:"description,omitempty"`
+}
+
 // NewIP constructor for IP
-// Parse an IP from string
-func NewIP(str string) (ip IP, err error) {
-	parts := strings.Split(str, ".")
+// Parse an IP v4 or v6 from string, the string may contain a port (e.g. "192.0.2.1:8080" or "[2001:db8::1]:8080")
+func NewIP(str string) (IP, error) {
+	str = strings.TrimSpace(str)
 
-	if len(parts) > 4 {
-		err = fmt.Errorf("invalid number of ip segments")
-		return
+	if host, _, err := net.SplitHostPort(str); err == nil {
+		str = host
 	}
 
-	for i, part := range parts {
-		var segment int64
-
-		segment, err = strconv.ParseInt(part, 10, 8)
-		if err != nil {
-			return
-		}
+	// The brackets are only removed in pairs, so unbalanced values (e.g. "[192.0.2.1") are rejected
+	if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+		str = str[1 : len(str)-1]
+	}
 
-		ip[i] = byte(segment)
+	ip := net.ParseIP(str)
+	if ip == nil {
+		return nil, fmt.Errorf(`invalid ip address "%s"`, str)
 	}
 
-	return
+	return IP(ip), nil
 }
 
 // _ "implement" constraint for IP
-var _ fmt.Stringer = IP{}
+var (
+	_ fmt.Stringer             = IP{}
+	_ encoding.TextMarshaler   = IP{}
+	_ encoding.TextUnmarshaler = (*IP)(nil)
+)
 
-// IP data type for ip address v4
-type IP [4]byte
+// IP data type for ip addresses v4 and v6
+type IP net.IP
 
 // String transforms an IP to string
 //
 // Example:
 //
-//     xxx.xxx.xxx.xxx
+//	xxx.xxx.xxx.xxx or 2001:db8::1
 func (i IP) String() string {
-	itoa := strconv.Itoa
+	if len(i) == 0 {
+		return ""
+	}
+
+	return net.IP(i).String()
+}
+
+// MarshalText serializes the IP as string
+func (i IP) MarshalText() ([]byte, error) {
+	return net.IP(i).MarshalText()
+}
+
+// UnmarshalText parses the IP from string
+func (i *IP) UnmarshalText(text []byte) error {
+	return (*net.IP)(i).UnmarshalText(text)
+}
+
+// Networks list of ip networks (CIDR blocks)
+type Networks []*net.IPNet
+
+// ParseNetworks parses a list of CIDR blocks (e.g. "10.0.0.0/8" or "2001:db8::/32")
+//
+// The ip addresses without prefix length are parsed as networks of a single address
+func ParseNetworks(blocks ...string) (Networks, error) {
+	networks := make(Networks, 0, len(blocks))
+
+	for _, block := range blocks {
+		block = strings.TrimSpace(block)
+		if block == "" {
+			continue
+		}
+
+		if !strings.Contains(block, "/") {
+			ip, err := NewIP(block)
+			if err != nil {
+				return nil, err
+			}
+
+			bits := 8 * net.IPv6len
+			if net.IP(ip).To4() != nil {
+				ip, bits = IP(net.IP(ip).To4()), 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: net.IP(ip), Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(block)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Contains indicates if the IP belongs to some of the Networks
+func (n Networks) Contains(ip IP) bool {
+	if len(ip) == 0 {
+		return false
+	}
+
+	for _, network := range n {
+		if network.Contains(net.IP(ip)) {
+			return true
+		}
+	}
 
-	return itoa(int(i[0])) + "." + itoa(int(i[1])) + "." + itoa(int(i[2])) + "." + itoa(int(i[3]))
+	return false
 }
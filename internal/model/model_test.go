@@ -0,0 +1,217 @@
+package model
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestNewIP checks the parsing of ip addresses v4 and v6 with and without port
+func TestNewIP(t *testing.T) {
+	tdt := []struct {
+		input       string
+		expectedIP  string
+		expectedErr bool
+	}{
+		{
+			input:      "192.0.2.1",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			input:      " 192.0.2.1:8080 ",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			input:      "2001:db8::1",
+			expectedIP: "2001:db8::1",
+		},
+		{
+			input:      "[2001:db8::1]",
+			expectedIP: "2001:db8::1",
+		},
+		{
+			input:      "[2001:db8:cafe::17]:4711",
+			expectedIP: "2001:db8:cafe::17",
+		},
+		// IPv4-mapped IPv6 address
+		{
+			input:      "::ffff:192.0.2.1",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			input:       "",
+			expectedErr: true,
+		},
+		{
+			input:       "unknown",
+			expectedErr: true,
+		},
+		// Obfuscated identifier (RFC 7239 section 6.3)
+		{
+			input:       "_hidden",
+			expectedErr: true,
+		},
+		{
+			input:       "192.0.2",
+			expectedErr: true,
+		},
+		{
+			input:       "256.0.2.1",
+			expectedErr: true,
+		},
+		{
+			input:       "[192.0.2.1",
+			expectedErr: true,
+		},
+		{
+			input:       "2001:db8::1]",
+			expectedErr: true,
+		},
+		{
+			input:       "example.com:443",
+			expectedErr: true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			ip, err := NewIP(v.input)
+			if (err != nil) != v.expectedErr {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			if ip.String() != v.expectedIP {
+				t.Fatalf(`expected ip "%s" got "%s"`, v.expectedIP, ip)
+			}
+		})
+	}
+}
+
+// TestParseNetworks checks the parsing of the CIDR blocks and the single addresses and the membership of the
+// ip addresses
+func TestParseNetworks(t *testing.T) {
+	tdt := []struct {
+		blocks      []string
+		contained   []string
+		excluded    []string
+		expectedErr bool
+	}{
+		{
+			blocks:    []string{"10.0.0.0/8", " 2001:db8::/32 "},
+			contained: []string{"10.1.2.3", "2001:db8::1", "[2001:db8::1]:443"},
+			excluded:  []string{"11.0.0.1", "2001:db9::1"},
+		},
+		// Single addresses
+		{
+			blocks:    []string{"192.0.2.1", "2001:db8::1"},
+			contained: []string{"192.0.2.1", "::ffff:192.0.2.1", "2001:db8::1"},
+			excluded:  []string{"192.0.2.2", "2001:db8::2"},
+		},
+		// Empty blocks are ignored
+		{
+			blocks:   []string{"", " "},
+			excluded: []string{"192.0.2.1"},
+		},
+		{
+			blocks:      []string{"10.0.0.0/33"},
+			expectedErr: true,
+		},
+		{
+			blocks:      []string{"10.0.0/8"},
+			expectedErr: true,
+		},
+		{
+			blocks:      []string{"unknown"},
+			expectedErr: true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			networks, err := ParseNetworks(v.blocks...)
+			if (err != nil) != v.expectedErr {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if err != nil {
+				t.Skip(err)
+			}
+
+			for _, address := range v.contained {
+				ip, err := NewIP(address)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !networks.Contains(ip) {
+					t.Fatalf(`expected "%s" to be contained in "%v"`, address, v.blocks)
+				}
+			}
+
+			for _, address := range v.excluded {
+				ip, err := NewIP(address)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if networks.Contains(ip) {
+					t.Fatalf(`expected "%s" to be excluded from "%v"`, address, v.blocks)
+				}
+			}
+
+			// The invalid addresses do not belong to any network
+			if networks.Contains(nil) {
+				t.Fatal("nil ip must not be contained")
+			}
+		})
+	}
+}
+
+// TestJWK_Thumbprint checks the JWK Thumbprints with the examples of RFC 7638 (section 3.1) and RFC 8037 (appendix A.3)
+func TestJWK_Thumbprint(t *testing.T) {
+	tdt := []struct {
+		jwk                JWK
+		expectedThumbprint string
+		expectedErr        bool
+	}{
+		{
+			jwk: JWK{
+				KeyType: "RSA",
+				KeyId:   "2011-04-29",
+				N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+					"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n" +
+					"91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				E: "AQAB",
+			},
+			expectedThumbprint: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			jwk: JWK{
+				KeyType: "OKP",
+				Curve:   "Ed25519",
+				X:       "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+			},
+			expectedThumbprint: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+		{
+			jwk:         JWK{KeyType: "oct"},
+			expectedErr: true,
+		},
+	}
+
+	for i, v := range tdt {
+		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
+			thumbprint, err := v.jwk.Thumbprint()
+			if (err != nil) != v.expectedErr {
+				t.Fatalf(`expected error "%v" got "%v"`, v.expectedErr, err)
+			}
+
+			if thumbprint != v.expectedThumbprint {
+				t.Fatalf(`expected thumbprint "%s" got "%s"`, v.expectedThumbprint, thumbprint)
+			}
+		})
+	}
+}
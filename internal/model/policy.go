@@ -0,0 +1,84 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Decision result of the evaluation of an authorization policy
+type Decision uint
+
+// Supported values for Decision
+const (
+	// Allow the grant can continue
+	Allow Decision = iota
+	// Deny the grant is rejected with AccessDenied
+	Deny
+	// StepUp the owner must authenticate again using a stronger method (e.g. MFA)
+	StepUp
+)
+
+// decisions names of the decisions used in the policy files and in the audit log
+var decisions = [...]string{
+	Allow:  "allow",
+	Deny:   "deny",
+	StepUp: "step_up",
+}
+
+// String returns the name of the Decision
+func (d Decision) String() string {
+	if int(d) < len(decisions) {
+		return decisions[d]
+	}
+
+	return fmt.Sprintf("Decision(%d)", uint(d))
+}
+
+// MarshalText encodes the Decision as its name
+func (d Decision) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes the name of a Decision (allow, deny or step_up)
+func (d *Decision) UnmarshalText(b []byte) error {
+	for i, name := range decisions {
+		if name == string(b) {
+			*d = Decision(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf(`unknown decision "%s"`, b)
+}
+
+// PolicyRequest facts of a grant evaluated by the authorization policies
+type PolicyRequest struct {
+	// Grant grant type that issues the token (e.g. "authorization_code")
+	Grant string `json:"grant"`
+	// Owner identifier of the owner on behalf of whom the token is issued
+	Owner string `json:"owner"`
+	// Client identifier of the client that requests the token
+	Client string `json:"client"`
+	// Scope requested scope
+	Scope Mask `json:"scope,omitempty"`
+	// IP address of the client or the user agent of the owner
+	IP IP `json:"ip,omitempty"`
+	// UserAgent user agent of the request
+	UserAgent string `json:"userAgent,omitempty"`
+	// Time when the request is made
+	Time time.Time `json:"time"`
+}
+
+// AuditEvent record of the audit log
+type AuditEvent struct {
+	// Time when the event happened
+	Time time.Time `json:"time"`
+	// Action audited action (e.g. "policy")
+	Action string `json:"action"`
+	// Request facts of the audited grant
+	Request PolicyRequest `json:"request"`
+	// Decision taken
+	Decision Decision `json:"decision"`
+	// Rule name of the rule that took the decision, empty if the default decision was taken
+	Rule string `json:"rule,omitempty"`
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// AuthorizationDetail element of the authorization_details parameter (RFC 9396 section 2)
+//
+// Contains the common fields "type", "locations", "actions", "datatypes", "identifier" and "privileges"
+// and the fields defined by each type
+type AuthorizationDetail map[string]interface{}
+
+// Type returns the value of the required "type" field
+func (a AuthorizationDetail) Type() string {
+	t, _ := a["type"].(string)
+	return t
+}
+
+// Strings returns the value of a field that contains an array of strings (e.g. "actions" or "locations")
+func (a AuthorizationDetail) Strings(field string) ([]string, error) {
+	i, ok := a[field]
+	if !ok {
+		return nil, nil
+	}
+
+	values, ok := i.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf(`field "%s" must be an array of strings`, field)
+	}
+
+	slice := make([]string, 0, len(values))
+
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf(`field "%s" must be an array of strings`, field)
+		}
+
+		slice = append(slice, str)
+	}
+
+	return slice, nil
+}
+
+// AuthorizationDetails value of the authorization_details parameter (RFC 9396)
+type AuthorizationDetails []AuthorizationDetail
+
+// ParseAuthorizationDetails parses the JSON array of the authorization_details parameter,
+// every element must be an object that contains the "type" field
+func ParseAuthorizationDetails(str string) (AuthorizationDetails, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(str)))
+	decoder.UseNumber()
+
+	details := AuthorizationDetails{}
+
+	if err := decoder.Decode(&details); err != nil {
+		return nil, fmt.Errorf("%w: authorization_details must be a JSON array of objects", InvalidAuthorizationDetails)
+	}
+
+	for _, detail := range details {
+		if detail.Type() == "" {
+			return nil, fmt.Errorf(`%w: missing "type" field`, InvalidAuthorizationDetails)
+		}
+	}
+
+	return details, nil
+}
+
+// Contains indicates if every element of the subset is equal to some element of the AuthorizationDetails
+func (a AuthorizationDetails) Contains(subset AuthorizationDetails) bool {
+	for _, detail := range subset {
+		found := false
+
+		for _, authorized := range a {
+			if reflect.DeepEqual(normalizeDetail(detail), normalizeDetail(authorized)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeDetail serializes and deserializes the detail to compare the values regardless of their go types
+// (e.g. json.Number and float64)
+func normalizeDetail(detail AuthorizationDetail) interface{} {
+	b, _ := json.Marshal(detail)
+
+	var i interface{}
+	_ = json.Unmarshal(b, &i)
+
+	return i
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Token formats supported by the protected resources
+const (
+	// JWTFormat the tokens are self-contained JSON Web Tokens
+	JWTFormat = "jwt"
+	// OpaqueFormat the tokens are random references to claims saved by the authorization server,
+	// they can only be read using the introspection endpoint (RFC 7662)
+	OpaqueFormat = "opaque"
+	// PasetoFormat the tokens are v4.public PASETO tokens signed with Ed25519
+	PasetoFormat = "paseto"
+)
+
+// Resource protected resource that accepts the tokens issued by the authorization server (RFC 8707)
+type Resource struct {
+	// Identifier absolute URI of the resource used as "aud" claim of the tokens (e.g. "https://api.example.com")
+	Identifier string
+	// Scopes permissions that can be granted for the resource, any scope is allowed if it is nil
+	Scopes Mask
+	// Format of the tokens issued for the resource (JWTFormat by default, OpaqueFormat or PasetoFormat)
+	Format string
+	// LifeTime of the tokens issued for the resource, the tokens do not expire if it is zero
+	LifeTime time.Duration
+	// KeyId identifier of the keys used to sign and encrypt the tokens of the resource, the tokens are signed with
+	// the default key and are not encrypted if it is empty
+	KeyId string
+}
+
+// IsValidResource indicates if the resource indicator is an absolute URI without fragment (RFC 8707 section 2)
+func IsValidResource(resource string) bool {
+	uri, err := url.Parse(resource)
+	return err == nil && uri.IsAbs() && !strings.Contains(resource, "#")
+}
@@ -0,0 +1,27 @@
+package model
+
+// Role named set of permissions, the Scopes grant bits of the Mask per resource to the owners assigned to the role
+type Role struct {
+	// Id identifier of the role (e.g. "sales")
+	Id string `json:"id"`
+	// Scopes bits of the Mask granted per resource (e.g. {"orders": 3})
+	Scopes Mask `json:"scopes"`
+}
+
+// Group named set of roles assigned together to the owners that belong to the group
+type Group struct {
+	// Id identifier of the group (e.g. "staff")
+	Id string `json:"id"`
+	// Roles identifiers of the roles granted to the members of the group
+	Roles []string `json:"roles"`
+}
+
+// Assignment roles and groups assigned to an owner
+type Assignment struct {
+	// Owner identifier of the owner (user id)
+	Owner string `json:"owner"`
+	// Roles identifiers of the roles assigned directly to the owner
+	Roles []string `json:"roles,omitempty"`
+	// Groups identifiers of the groups to which the owner belongs
+	Groups []string `json:"groups,omitempty"`
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// _ "implement" constraint for BackchannelStorage
+var _ DeviceStorer = BackchannelStorage{}
+
+// BackchannelStorage storage of pending authentication requests of the CIBA flow
+// Basically saves instances of model.BackchannelAuthorization identified by the auth_req_id
+type BackchannelStorage struct {
+	*redis.Client
+}
+
+// backchannelKey creates the key of an authentication request based on the auth_req_id
+func (BackchannelStorage) backchannelKey(authReqId string) string {
+	return "backchannel:" + authReqId
+}
+
+// Create saves a model.BackchannelAuthorization that lives until its expiration
+func (b BackchannelStorage) Create(authReqId string, i interface{}) error {
+	request := i.(model.BackchannelAuthorization)
+
+	cmd := b.SetNX(context.TODO(), b.backchannelKey(authReqId), model.BinaryJSON{I: request}, time.Until(request.ExpiresAt))
+
+	wasCreated, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`auth_req_id "%s" already exists`, authReqId))
+	}
+
+	return err
+}
+
+// Obtain search a model.BackchannelAuthorization by the auth_req_id
+func (b BackchannelStorage) Obtain(authReqId string) (interface{}, error) {
+	serialized, err := b.Get(context.TODO(), b.backchannelKey(authReqId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	request := model.BackchannelAuthorization{}
+
+	err = json.Unmarshal([]byte(serialized), &request)
+	return request, err
+}
+
+// Update replaces a model.BackchannelAuthorization keeping its expiration
+//
+// If the record does not exist an error of type model.NotFound is returned
+func (b BackchannelStorage) Update(authReqId string, i interface{}) error {
+	request := i.(model.BackchannelAuthorization)
+
+	cmd := b.SetArgs(context.TODO(), b.backchannelKey(authReqId), model.BinaryJSON{I: request}, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	})
+
+	err := cmd.Err()
+	if err == redis.Nil {
+		err = model.NotFound(fmt.Sprintf(`missing auth_req_id "%s"`, authReqId))
+	}
+
+	return err
+}
+
+// Consume obtains and removes a model.BackchannelAuthorization using the GETDEL command
+func (b BackchannelStorage) Consume(authReqId string) (interface{}, error) {
+	serialized, err := b.GetDel(context.TODO(), b.backchannelKey(authReqId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	request := model.BackchannelAuthorization{}
+
+	err = json.Unmarshal([]byte(serialized), &request)
+	return request, err
+}
+
+// Poll records a polling request of the client in a key apart from the model.BackchannelAuthorization
+func (b BackchannelStorage) Poll(authReqId string, p model.Poll) (bool, error) {
+	return poll(b.Client, b.backchannelKey(authReqId)+":poll", p)
+}
+
+// Delete removes a model.BackchannelAuthorization and its polling state by the auth_req_id
+func (b BackchannelStorage) Delete(authReqId string) error {
+	return b.Del(context.TODO(), b.backchannelKey(authReqId), b.backchannelKey(authReqId)+":poll").Err()
+}
@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/yael-castro/goauth/internal/model"
+	"strconv"
 )
 
 // Finder defines a finder for saved data in some storage
@@ -36,6 +38,70 @@ func (c ClientFinder) listKey(clientId string) string {
 	return c.clientKey(clientId) + ":origins"
 }
 
+// wildcardKey creates a key with the pattern "client:<clientId>:wildcard" to save if the client
+// enables wildcard subdomain patterns in the allowed origins
+func (c ClientFinder) wildcardKey(clientId string) string {
+	return c.clientKey(clientId) + ":wildcard"
+}
+
+// exchangeKey creates a key with the pattern "client:<clientId>:exchange_audiences" to save the audiences
+// for which the client may exchange tokens (RFC 8693)
+func (c ClientFinder) exchangeKey(clientId string) string {
+	return c.clientKey(clientId) + ":exchange_audiences"
+}
+
+// backchannelKey creates a key with the pattern "client:<clientId>:backchannel" to save the hash
+// with the "mode" and "endpoint" fields used by the client in the CIBA flow
+func (c ClientFinder) backchannelKey(clientId string) string {
+	return c.clientKey(clientId) + ":backchannel"
+}
+
+// scopesKey creates a key with the pattern "client:<clientId>:scopes" to save the hash of the scopes allowed
+// for the client where each field is a resource and each value a hexadecimal mask
+func (c ClientFinder) scopesKey(clientId string) string {
+	return c.clientKey(clientId) + ":scopes"
+}
+
+// defaultScopeKey creates a key with the pattern "client:<clientId>:default_scope" to save the default scope of the client
+func (c ClientFinder) defaultScopeKey(clientId string) string {
+	return c.clientKey(clientId) + ":default_scope"
+}
+
+// tokenFormatKey creates a key with the pattern "client:<clientId>:token_format" to save the format of the
+// access tokens issued to the client ("jwt", "opaque" or "paseto")
+func (c ClientFinder) tokenFormatKey(clientId string) string {
+	return c.clientKey(clientId) + ":token_format"
+}
+
+// idTokenEncryptionKey creates a key with the pattern "client:<clientId>:id_token_encrypted_response_alg" to save
+// the algorithm used to encrypt the ID tokens issued to the client
+func (c ClientFinder) idTokenEncryptionKey(clientId string) string {
+	return c.clientKey(clientId) + ":id_token_encrypted_response_alg"
+}
+
+// jwksKey creates a key with the pattern "client:<clientId>:jwks" to save the key set (JSON) of the client
+func (c ClientFinder) jwksKey(clientId string) string {
+	return c.clientKey(clientId) + ":jwks"
+}
+
+// subjectTypeKey creates a key with the pattern "client:<clientId>:subject_type" to save the type of the subject
+// identifiers sent to the client ("public" or "pairwise")
+func (c ClientFinder) subjectTypeKey(clientId string) string {
+	return c.clientKey(clientId) + ":subject_type"
+}
+
+// sectorKey creates a key with the pattern "client:<clientId>:sector_identifier_uri" to save the URL of the
+// redirect uris that define the sector of the client
+func (c ClientFinder) sectorKey(clientId string) string {
+	return c.clientKey(clientId) + ":sector_identifier_uri"
+}
+
+// metadataKey creates a key with the pattern "client:<clientId>:metadata" to save the hash of the additional
+// data of the client
+func (c ClientFinder) metadataKey(clientId string) string {
+	return c.clientKey(clientId) + ":metadata"
+}
+
 // Find search a client by client id
 func (c ClientFinder) Find(clientId string) (i interface{}, err error) {
 	client := model.Client{Id: clientId}
@@ -46,6 +112,118 @@ func (c ClientFinder) Find(clientId string) (i interface{}, err error) {
 	}
 
 	client.AllowedOrigins, err = c.LRange(context.TODO(), c.listKey(clientId), 0, 10).Result()
+	if err != nil {
+		return
+	}
+
+	client.WildcardRedirect, err = c.Get(context.TODO(), c.wildcardKey(clientId)).Bool()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	client.ExchangeAudiences, err = c.LRange(context.TODO(), c.exchangeKey(clientId), 0, -1).Result()
+	if err != nil {
+		return
+	}
+
+	backchannel, err := c.HGetAll(context.TODO(), c.backchannelKey(clientId)).Result()
+	if err != nil {
+		return
+	}
+
+	client.BackchannelMode = model.DeliveryMode(backchannel["mode"])
+	client.BackchannelEndpoint = backchannel["endpoint"]
+
+	scopes, err := c.HGetAll(context.TODO(), c.scopesKey(clientId)).Result()
+	if err != nil {
+		return
+	}
+
+	// The clients without allowed scopes are not restricted
+	if len(scopes) > 0 {
+		client.AllowedScopes = make(model.Mask, len(scopes))
+
+		for resource, hex := range scopes {
+			client.AllowedScopes[resource], err = strconv.ParseUint(hex, 16, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	client.DefaultScope, err = c.Get(context.TODO(), c.defaultScopeKey(clientId)).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	client.TokenFormat, err = c.Get(context.TODO(), c.tokenFormatKey(clientId)).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	client.IDTokenEncryptedResponseAlg, err = c.Get(context.TODO(), c.idTokenEncryptionKey(clientId)).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	jwks, err := c.Get(context.TODO(), c.jwksKey(clientId)).Bytes()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if len(jwks) > 0 {
+		if err = json.Unmarshal(jwks, &client.Keys); err != nil {
+			return
+		}
+	}
+
+	client.SubjectType, err = c.Get(context.TODO(), c.subjectTypeKey(clientId)).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	client.SectorIdentifierURI, err = c.Get(context.TODO(), c.sectorKey(clientId)).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	metadata, err := c.HGetAll(context.TODO(), c.metadataKey(clientId)).Result()
+	if err != nil {
+		return
+	}
+
+	if len(metadata) > 0 {
+		client.Metadata = metadata
+	}
+
 	i = client
 	return
 }
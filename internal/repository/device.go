@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// Updater defines a store whose records can be updated
+type Updater interface {
+	// Update replaces the data of an existing record
+	Update(string, interface{}) error
+}
+
+// Poller defines a store of the polling state of the pending requests
+type Poller interface {
+	// Poll records the polling request in a single atomic operation and indicates if it was made before
+	// the interval elapsed, in that case the interval is increased
+	Poll(string, model.Poll) (bool, error)
+}
+
+// Counter defines a store of counters that are reset after a window of time
+type Counter interface {
+	// Increment increases the counter in a single atomic operation and returns its value, the counter
+	// is reset when the window elapses since the first increment
+	Increment(string, time.Duration) (int64, error)
+}
+
+// DeviceStorer defines the store of pending device authorizations, each record can be updated
+// by the owner, polled by the device and consumed only once
+type DeviceStorer interface {
+	ConsumerStorage
+	Updater
+	Poller
+}
+
+// pollScript compares the polling request with the last one saved in the hash of the polling state,
+// returns 1 and increases the interval if the client polled too fast (ARGV: now, interval, slow down and
+// expiration, all in milliseconds)
+var pollScript = redis.NewScript(`
+local last = tonumber(redis.call("HGET", KEYS[1], "last"))
+local interval = tonumber(redis.call("HGET", KEYS[1], "interval")) or tonumber(ARGV[2])
+local now = tonumber(ARGV[1])
+local slow = 0
+
+if last and now - last < interval then
+	slow = 1
+	interval = interval + tonumber(ARGV[3])
+end
+
+redis.call("HSET", KEYS[1], "last", now, "interval", interval)
+redis.call("PEXPIREAT", KEYS[1], ARGV[4])
+
+return slow
+`)
+
+// poll runs the pollScript on the key of the polling state
+func poll(client *redis.Client, key string, p model.Poll) (bool, error) {
+	slow, err := pollScript.Run(context.TODO(), client, []string{key},
+		milliseconds(p.At), int64(p.Interval/time.Millisecond), int64(p.SlowDown/time.Millisecond), milliseconds(p.ExpiresAt),
+	).Int()
+
+	return slow == 1, err
+}
+
+// incrementScript increases the counter and sets its expiration when it is created (ARGV: window in milliseconds)
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+
+return count
+`)
+
+// _ "implement" constraint for AttemptStorage
+var _ Counter = AttemptStorage{}
+
+// AttemptStorage counters of the attempts made by each owner (e.g. the user codes entered in the verification page)
+type AttemptStorage struct {
+	*redis.Client
+}
+
+// Increment increases the counter of attempts identified by the key
+func (a AttemptStorage) Increment(key string, window time.Duration) (int64, error) {
+	return incrementScript.Run(context.TODO(), a.Client, []string{"attempts:" + key}, int64(window/time.Millisecond)).Int64()
+}
+
+// milliseconds returns the unix time in milliseconds
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// _ "implement" constraint for DeviceStorage
+var _ DeviceStorer = DeviceStorage{}
+
+// DeviceStorage storage of pending device authorizations (RFC 8628)
+// Basically saves instances of model.DeviceAuthorization identified by the device code
+type DeviceStorage struct {
+	*redis.Client
+}
+
+// deviceKey creates the key of a device authorization based on the device code
+func (DeviceStorage) deviceKey(deviceCode string) string {
+	return "device:" + deviceCode
+}
+
+// Create saves a model.DeviceAuthorization that lives until its expiration
+func (d DeviceStorage) Create(deviceCode string, i interface{}) error {
+	device := i.(model.DeviceAuthorization)
+
+	cmd := d.SetNX(context.TODO(), d.deviceKey(deviceCode), model.BinaryJSON{I: device}, time.Until(device.ExpiresAt))
+
+	wasCreated, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`device code "%s" already exists`, deviceCode))
+	}
+
+	return err
+}
+
+// Obtain search a model.DeviceAuthorization by the device code
+func (d DeviceStorage) Obtain(deviceCode string) (interface{}, error) {
+	serialized, err := d.Get(context.TODO(), d.deviceKey(deviceCode)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	device := model.DeviceAuthorization{}
+
+	err = json.Unmarshal([]byte(serialized), &device)
+	return device, err
+}
+
+// Update replaces a model.DeviceAuthorization keeping its expiration
+//
+// If the record does not exist an error of type model.NotFound is returned
+func (d DeviceStorage) Update(deviceCode string, i interface{}) error {
+	device := i.(model.DeviceAuthorization)
+
+	cmd := d.SetArgs(context.TODO(), d.deviceKey(deviceCode), model.BinaryJSON{I: device}, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	})
+
+	err := cmd.Err()
+	if err == redis.Nil {
+		err = model.NotFound(fmt.Sprintf(`missing device code "%s"`, deviceCode))
+	}
+
+	return err
+}
+
+// Consume obtains and removes a model.DeviceAuthorization using the GETDEL command
+func (d DeviceStorage) Consume(deviceCode string) (interface{}, error) {
+	serialized, err := d.GetDel(context.TODO(), d.deviceKey(deviceCode)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	device := model.DeviceAuthorization{}
+
+	err = json.Unmarshal([]byte(serialized), &device)
+	return device, err
+}
+
+// Poll records a polling request of the device in a key apart from the model.DeviceAuthorization
+func (d DeviceStorage) Poll(deviceCode string, p model.Poll) (bool, error) {
+	return poll(d.Client, d.deviceKey(deviceCode)+":poll", p)
+}
+
+// Delete removes a model.DeviceAuthorization and its polling state by the device code
+func (d DeviceStorage) Delete(deviceCode string) error {
+	return d.Del(context.TODO(), d.deviceKey(deviceCode), d.deviceKey(deviceCode)+":poll").Err()
+}
+
+// _ "implement" constraint for UserCodeStorage
+var _ ConsumerStorage = UserCodeStorage{}
+
+// UserCodeStorage index of the device codes (string) by user code
+type UserCodeStorage struct {
+	*redis.Client
+}
+
+// userCodeKey creates the key of the index based on the user code
+func (UserCodeStorage) userCodeKey(userCode string) string {
+	return "device:user_code:" + userCode
+}
+
+// Create saves the device code (string) related to the user code during the MaximumDeviceCodeLifeTime
+func (u UserCodeStorage) Create(userCode string, i interface{}) error {
+	cmd := u.SetNX(context.TODO(), u.userCodeKey(userCode), i.(string), MaximumDeviceCodeLifeTime)
+
+	wasCreated, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`user code "%s" already exists`, userCode))
+	}
+
+	return err
+}
+
+// Obtain search the device code (string) by user code
+func (u UserCodeStorage) Obtain(userCode string) (interface{}, error) {
+	return u.Get(context.TODO(), u.userCodeKey(userCode)).Result()
+}
+
+// Consume obtains and removes the device code (string) by user code using the GETDEL command,
+// so only one owner can approve or deny the device authorization
+func (u UserCodeStorage) Consume(userCode string) (interface{}, error) {
+	return u.GetDel(context.TODO(), u.userCodeKey(userCode)).Result()
+}
+
+// Delete removes the index of the user code
+func (u UserCodeStorage) Delete(userCode string) error {
+	return u.Del(context.TODO(), u.userCodeKey(userCode)).Err()
+}
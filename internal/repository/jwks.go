@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+const (
+	// DefaultKeySetMaxAge time that a key set obtained from an URL is cached
+	DefaultKeySetMaxAge = time.Hour
+	// DefaultKeySetTimeout time limit of the requests to the URL of a key set
+	DefaultKeySetTimeout = 10 * time.Second
+)
+
+// minimumKeySetRefresh minimum time between the requests made to refresh a key set when an unknown key is searched
+const minimumKeySetRefresh = time.Minute
+
+// maximumKeySetSize maximum size in bytes of a key set
+const maximumKeySetSize = 1 << 20
+
+// _ "implement" constraint for FileKeySet and URLKeySet
+var (
+	_ Finder = FileKeySet{}
+	_ Finder = (*URLKeySet)(nil)
+)
+
+// FileKeySet finder of model.JWK in a JSON Web Key Set saved in a file
+type FileKeySet struct {
+	// Path of the file that contains the key set
+	Path string
+}
+
+// Find search a model.JWK by key id, the file is read on each search so the keys can be rotated
+func (f FileKeySet) Find(kid string) (interface{}, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	set, err := decodeKeySet(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return findKey(set, kid)
+}
+
+// URLKeySet finder of model.JWK in a JSON Web Key Set published in an URL (e.g. "jwks_uri")
+//
+// The key set is cached during MaxAge, if an unknown key is searched the key set is refreshed
+// at most once per minute to support the rotation of keys. The requests are made without holding the lock
+// and the concurrent searches wait for a single request
+type URLKeySet struct {
+	// URL of the key set
+	URL string
+	// Client used to request the key set (client with DefaultKeySetTimeout by default)
+	Client *http.Client
+	// MaxAge time that the key set is cached (DefaultKeySetMaxAge by default)
+	MaxAge time.Duration
+
+	mutex     sync.Mutex
+	set       model.JWKSet
+	fetchedAt time.Time
+	fetching  *keySetFetch
+}
+
+// keySetFetch request in progress to the URL of a key set, done is closed when the request finishes
+type keySetFetch struct {
+	done      chan struct{}
+	set       model.JWKSet
+	fetchedAt time.Time
+	err       error
+}
+
+// Find search a model.JWK by key id
+func (u *URLKeySet) Find(kid string) (interface{}, error) {
+	maxAge := u.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultKeySetMaxAge
+	}
+
+	u.mutex.Lock()
+	set, fetchedAt := u.set, u.fetchedAt
+	u.mutex.Unlock()
+
+	if time.Since(fetchedAt) > maxAge {
+		var err error
+
+		set, fetchedAt, err = u.refresh()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := findKey(set, kid)
+	if _, ok := err.(model.NotFound); !ok || time.Since(fetchedAt) < minimumKeySetRefresh {
+		return key, err
+	}
+
+	set, _, err = u.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return findKey(set, kid)
+}
+
+// refresh requests the key set and caches it, if the key set is already being requested it waits for that request
+func (u *URLKeySet) refresh() (model.JWKSet, time.Time, error) {
+	u.mutex.Lock()
+
+	if call := u.fetching; call != nil {
+		u.mutex.Unlock()
+		<-call.done
+
+		return call.set, call.fetchedAt, call.err
+	}
+
+	call := &keySetFetch{done: make(chan struct{})}
+	u.fetching = call
+
+	u.mutex.Unlock()
+
+	call.set, call.err = u.fetch()
+	call.fetchedAt = time.Now()
+
+	u.mutex.Lock()
+
+	u.fetching = nil
+
+	if call.err == nil {
+		u.set, u.fetchedAt = call.set, call.fetchedAt
+	}
+
+	u.mutex.Unlock()
+	close(call.done)
+
+	return call.set, call.fetchedAt, call.err
+}
+
+// fetch requests the key set to the URL
+func (u *URLKeySet) fetch() (model.JWKSet, error) {
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultKeySetTimeout}
+	}
+
+	res, err := client.Get(u.URL)
+	if err != nil {
+		return model.JWKSet{}, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return model.JWKSet{}, fmt.Errorf(`unexpected status "%d" obtaining the key set "%s"`, res.StatusCode, u.URL)
+	}
+
+	return decodeKeySet(res.Body)
+}
+
+// decodeKeySet decodes a model.JWKSet in json
+func decodeKeySet(r io.Reader) (set model.JWKSet, err error) {
+	err = json.NewDecoder(io.LimitReader(r, maximumKeySetSize)).Decode(&set)
+	return
+}
+
+// findKey search a model.JWK in the key set by key id
+func findKey(set model.JWKSet, kid string) (interface{}, error) {
+	key, ok := set.Key(kid)
+	if !ok {
+		return nil, model.NotFound(fmt.Sprintf(`missing key "%s"`, kid))
+	}
+
+	return key, nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// TestURLKeySet_Find checks that the concurrent searches of an unknown key make a single request to refresh the
+// key set and that the refresh does not block the searches of the cached keys
+func TestURLKeySet_Find(t *testing.T) {
+	var requests int32
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request obtains the key set before the rotation
+		if atomic.AddInt32(&requests, 1) == 1 {
+			_, _ = w.Write([]byte(`{"keys":[{"kty":"oct","kid":"key-1"}]}`))
+			return
+		}
+
+		<-release
+		_, _ = w.Write([]byte(`{"keys":[{"kty":"oct","kid":"key-1"},{"kty":"oct","kid":"key-2"}]}`))
+	}))
+	defer server.Close()
+
+	keys := &URLKeySet{URL: server.URL, Client: server.Client()}
+
+	if _, err := keys.Find("key-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key set can be refreshed to search an unknown key
+	keys.mutex.Lock()
+	keys.fetchedAt = time.Now().Add(-2 * minimumKeySetRefresh)
+	keys.mutex.Unlock()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			key, err := keys.Find("key-2")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if kid := key.(model.JWK).KeyId; kid != "key-2" {
+				t.Errorf(`expected key "key-2" got "%s"`, kid)
+			}
+		}()
+	}
+
+	for atomic.LoadInt32(&requests) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The refresh is in progress, the cached keys must be found without waiting for it
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := keys.Find("key-1")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the search of a cached key was blocked by the refresh of the key set")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests got %d", n)
+	}
+}
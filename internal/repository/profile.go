@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// _ "implement" constraint for ProfileStorage
+var _ Storage = ProfileStorage{}
+
+// ProfileStorage storage of the profiles of the owners (e.g. "name" or "email")
+// Basically saves the claims of each owner as instances of model.Map in a hash
+type ProfileStorage struct {
+	*redis.Client
+}
+
+// profileKey creates the key of the profile of an owner
+func (ProfileStorage) profileKey(ownerId string) string {
+	return "owner:" + ownerId + ":profile"
+}
+
+// Create saves the fields of the model.Map in the profile of the owner
+func (p ProfileStorage) Create(ownerId string, i interface{}) error {
+	profile := i.(model.Map)
+	if len(profile) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, 2*len(profile))
+	for k, v := range profile {
+		values = append(values, k, fmt.Sprint(v))
+	}
+
+	return p.HSet(context.TODO(), p.profileKey(ownerId), values...).Err()
+}
+
+// Obtain search the profile of an owner as model.Map, if the owner does not have a profile
+// an error of type model.NotFound is returned
+func (p ProfileStorage) Obtain(ownerId string) (interface{}, error) {
+	fields, err := p.HGetAll(context.TODO(), p.profileKey(ownerId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return nil, model.NotFound(fmt.Sprintf(`missing profile of "%s"`, ownerId))
+	}
+
+	profile := make(model.Map, len(fields))
+	for k, v := range fields {
+		profile[k] = v
+	}
+
+	return profile, nil
+}
+
+// Delete removes the profile of an owner
+func (p ProfileStorage) Delete(ownerId string) error {
+	return p.Del(context.TODO(), p.profileKey(ownerId)).Err()
+}
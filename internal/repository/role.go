@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+// UpdaterStorage defines a Storage whose records can be updated
+type UpdaterStorage interface {
+	Storage
+	Updater
+}
+
+// _ "implement" constraint for RoleStorage
+var _ UpdaterStorage = RoleStorage{}
+
+// RoleStorage storage of the roles that grant bits of the mask per resource
+// Basically saves instances of model.Role identified by the role id
+type RoleStorage struct {
+	*redis.Client
+}
+
+// roleKey creates the key of a role based on its id
+func (RoleStorage) roleKey(roleId string) string {
+	return "role:" + roleId
+}
+
+// Create saves a model.Role, if the role exists an error of type model.DuplicateRecord is returned
+func (r RoleStorage) Create(roleId string, i interface{}) error {
+	return createRecord(r.Client, r.roleKey(roleId), i.(model.Role))
+}
+
+// Obtain search a model.Role by id
+func (r RoleStorage) Obtain(roleId string) (interface{}, error) {
+	role := model.Role{}
+
+	err := obtainRecord(r.Client, r.roleKey(roleId), &role)
+	return role, err
+}
+
+// Update replaces a model.Role, if the role does not exist an error of type model.NotFound is returned
+func (r RoleStorage) Update(roleId string, i interface{}) error {
+	return updateRecord(r.Client, r.roleKey(roleId), i.(model.Role))
+}
+
+// Delete removes a model.Role by id
+func (r RoleStorage) Delete(roleId string) error {
+	return r.Del(context.TODO(), r.roleKey(roleId)).Err()
+}
+
+// _ "implement" constraint for GroupStorage
+var _ UpdaterStorage = GroupStorage{}
+
+// GroupStorage storage of the groups of roles
+// Basically saves instances of model.Group identified by the group id
+type GroupStorage struct {
+	*redis.Client
+}
+
+// groupKey creates the key of a group based on its id
+func (GroupStorage) groupKey(groupId string) string {
+	return "group:" + groupId
+}
+
+// Create saves a model.Group, if the group exists an error of type model.DuplicateRecord is returned
+func (g GroupStorage) Create(groupId string, i interface{}) error {
+	return createRecord(g.Client, g.groupKey(groupId), i.(model.Group))
+}
+
+// Obtain search a model.Group by id
+func (g GroupStorage) Obtain(groupId string) (interface{}, error) {
+	group := model.Group{}
+
+	err := obtainRecord(g.Client, g.groupKey(groupId), &group)
+	return group, err
+}
+
+// Update replaces a model.Group, if the group does not exist an error of type model.NotFound is returned
+func (g GroupStorage) Update(groupId string, i interface{}) error {
+	return updateRecord(g.Client, g.groupKey(groupId), i.(model.Group))
+}
+
+// Delete removes a model.Group by id
+func (g GroupStorage) Delete(groupId string) error {
+	return g.Del(context.TODO(), g.groupKey(groupId)).Err()
+}
+
+// _ "implement" constraint for AssignmentStorage
+var _ UpdaterStorage = AssignmentStorage{}
+
+// AssignmentStorage storage of the roles and groups assigned to the owners
+// Basically saves instances of model.Assignment identified by the owner id
+type AssignmentStorage struct {
+	*redis.Client
+}
+
+// assignmentKey creates the key of the assignment of an owner
+func (AssignmentStorage) assignmentKey(ownerId string) string {
+	return "owner:" + ownerId + ":assignment"
+}
+
+// Create saves a model.Assignment, if the owner already has an assignment an error of type model.DuplicateRecord is returned
+func (a AssignmentStorage) Create(ownerId string, i interface{}) error {
+	return createRecord(a.Client, a.assignmentKey(ownerId), i.(model.Assignment))
+}
+
+// Obtain search the model.Assignment of an owner
+func (a AssignmentStorage) Obtain(ownerId string) (interface{}, error) {
+	assignment := model.Assignment{}
+
+	err := obtainRecord(a.Client, a.assignmentKey(ownerId), &assignment)
+	return assignment, err
+}
+
+// Update replaces the model.Assignment of an owner, if it does not exist an error of type model.NotFound is returned
+func (a AssignmentStorage) Update(ownerId string, i interface{}) error {
+	return updateRecord(a.Client, a.assignmentKey(ownerId), i.(model.Assignment))
+}
+
+// Delete removes the model.Assignment of an owner
+func (a AssignmentStorage) Delete(ownerId string) error {
+	return a.Del(context.TODO(), a.assignmentKey(ownerId)).Err()
+}
+
+// createRecord saves the record serialized as JSON without expiration only if the key does not exist
+func createRecord(client *redis.Client, key string, i interface{}) error {
+	wasCreated, err := client.SetNX(context.TODO(), key, model.BinaryJSON{I: i}, 0).Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`record "%s" already exists`, key))
+	}
+
+	return err
+}
+
+// obtainRecord deserializes the JSON record saved in the key
+func obtainRecord(client *redis.Client, key string, i interface{}) error {
+	serialized, err := client.Get(context.TODO(), key).Result()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(serialized), i)
+}
+
+// updateRecord replaces the JSON record saved in the key only if the key exists
+func updateRecord(client *redis.Client, key string, i interface{}) error {
+	err := client.SetArgs(context.TODO(), key, model.BinaryJSON{I: i}, redis.SetArgs{Mode: "XX"}).Err()
+	if err == redis.Nil {
+		err = model.NotFound(fmt.Sprintf(`missing record "%s"`, key))
+	}
+
+	return err
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yael-castro/goauth/internal/model"
+)
+
+const (
+	// DefaultSectorMaxAge time that the redirect uris obtained from a sector_identifier_uri are cached
+	DefaultSectorMaxAge = time.Hour
+	// DefaultSectorTimeout time limit of the requests to the sector_identifier_uri
+	DefaultSectorTimeout = 10 * time.Second
+)
+
+// maximumSectorSize maximum size in bytes of the document published in a sector_identifier_uri
+const maximumSectorSize = 1 << 16
+
+// _ "implement" constraint for URLSectorFinder and MockSectorFinder
+var (
+	_ Finder = (*URLSectorFinder)(nil)
+	_ Finder = MockSectorFinder{}
+)
+
+// URLSectorFinder finder of the redirect uris listed in the sector_identifier_uri of the clients
+// (OpenID Connect Dynamic Client Registration 1.0 section 5)
+//
+// The redirect uris of each URL are cached during MaxAge, the requests are made without holding the lock and
+// the concurrent searches of the same URL wait for a single request
+type URLSectorFinder struct {
+	// Client used to request the redirect uris (client with DefaultSectorTimeout by default)
+	Client *http.Client
+	// MaxAge time that the redirect uris are cached (DefaultSectorMaxAge by default)
+	MaxAge time.Duration
+
+	mutex    sync.Mutex
+	sectors  map[string]sector
+	fetching map[string]*sectorFetch
+}
+
+// sector redirect uris obtained from a sector_identifier_uri
+type sector struct {
+	redirectURIs []string
+	fetchedAt    time.Time
+}
+
+// sectorFetch request in progress to a sector_identifier_uri, done is closed when the request finishes
+type sectorFetch struct {
+	done         chan struct{}
+	redirectURIs []string
+	err          error
+}
+
+// Find obtains the redirect uris ([]string) listed in the sector_identifier_uri
+func (u *URLSectorFinder) Find(uri string) (interface{}, error) {
+	maxAge := u.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultSectorMaxAge
+	}
+
+	u.mutex.Lock()
+
+	if cached, ok := u.sectors[uri]; ok && time.Since(cached.fetchedAt) < maxAge {
+		u.mutex.Unlock()
+		return cached.redirectURIs, nil
+	}
+
+	// Another search is already requesting the URL
+	if call, ok := u.fetching[uri]; ok {
+		u.mutex.Unlock()
+		<-call.done
+
+		if call.err != nil {
+			return nil, call.err
+		}
+
+		return call.redirectURIs, nil
+	}
+
+	if u.fetching == nil {
+		u.fetching = make(map[string]*sectorFetch)
+	}
+
+	call := &sectorFetch{done: make(chan struct{})}
+	u.fetching[uri] = call
+
+	u.mutex.Unlock()
+
+	call.redirectURIs, call.err = u.fetch(uri)
+
+	u.mutex.Lock()
+
+	delete(u.fetching, uri)
+
+	if call.err == nil {
+		if u.sectors == nil {
+			u.sectors = make(map[string]sector)
+		}
+
+		u.sectors[uri] = sector{redirectURIs: call.redirectURIs, fetchedAt: time.Now()}
+	}
+
+	u.mutex.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return call.redirectURIs, nil
+}
+
+// fetch requests the JSON array of redirect uris to the sector_identifier_uri
+func (u *URLSectorFinder) fetch(uri string) (redirectURIs []string, err error) {
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultSectorTimeout}
+	}
+
+	res, err := client.Get(uri)
+	if err != nil {
+		return
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf(`unexpected status "%d" obtaining the sector "%s"`, res.StatusCode, uri)
+		return
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maximumSectorSize)).Decode(&redirectURIs)
+	return
+}
+
+// MockSectorFinder mock store for the redirect uris of each sector_identifier_uri
+type MockSectorFinder map[string][]string
+
+// Find obtains the redirect uris listed in the sector_identifier_uri
+func (m MockSectorFinder) Find(uri string) (interface{}, error) {
+	redirectURIs, ok := m[uri]
+	if !ok {
+		return nil, model.NotFound(fmt.Sprintf(`missing sector "%s"`, uri))
+	}
+
+	return redirectURIs, nil
+}
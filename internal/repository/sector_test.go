@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestURLSectorFinder_Find checks that the concurrent searches of the same sector_identifier_uri make a single
+// request and that a slow sector_identifier_uri does not block the searches of other URLs
+func TestURLSectorFinder_Find(t *testing.T) {
+	var requests int32
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt32(&requests, 1)
+			<-release
+		}
+
+		_, _ = w.Write([]byte(`["https://app.example.com/callback"]`))
+	}))
+	defer server.Close()
+
+	finder := &URLSectorFinder{Client: server.Client()}
+	expected := []string{"https://app.example.com/callback"}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			redirectURIs, err := finder.Find(server.URL + "/slow")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if !reflect.DeepEqual(expected, redirectURIs) {
+				t.Errorf(`expected "%v" got "%v"`, expected, redirectURIs)
+			}
+		}()
+	}
+
+	// The slow request is in progress, the other URLs must be searched without waiting for it
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := finder.Find(server.URL + "/fast")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the search was blocked by the request of another sector_identifier_uri")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request got %d", n)
+	}
+
+	// The redirect uris are cached
+	if _, err := finder.Find(server.URL + "/slow"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request got %d", n)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/yael-castro/goauth/internal/model"
+	"sync"
 	"time"
 )
 
@@ -13,6 +14,10 @@ import (
 const (
 	MaximumAuthorizationCodeLifeTime = 10 * time.Minute
 	MediumAuthorizationCodeLifeTime  = MaximumAuthorizationCodeLifeTime / 2
+	// UsedAuthorizationCodeLifeTime time during which the tombstones of consumed authorization codes are kept
+	UsedAuthorizationCodeLifeTime = 24 * time.Hour
+	// MaximumDeviceCodeLifeTime maximum life time of the device codes and user codes (RFC 8628)
+	MaximumDeviceCodeLifeTime = 30 * time.Minute
 )
 
 // Storage defines a general store
@@ -26,8 +31,20 @@ type Storage interface {
 	Delete(string) error
 }
 
+// Consumer defines a store of single-use records
+type Consumer interface {
+	// Consume obtains and removes a record by id in a single atomic operation
+	Consume(string) (interface{}, error)
+}
+
+// ConsumerStorage defines a Storage whose records can be consumed only once
+type ConsumerStorage interface {
+	Storage
+	Consumer
+}
+
 // _ "implement" constraint for StateStorage
-var _ Storage = StateStorage{}
+var _ ConsumerStorage = StateStorage{}
 
 // StateStorage storage of states related to authorization requests
 // Basically saves instances of model.Authorization
@@ -79,6 +96,20 @@ func (s StateStorage) Obtain(code string) (interface{}, error) {
 	return auth, err
 }
 
+// Consume obtains and removes a saved instance of model.Authorization using the GETDEL command,
+// so only one request can obtain the record
+func (s StateStorage) Consume(code string) (interface{}, error) {
+	serializedData, err := s.GetDel(context.TODO(), s.authorizationKey(model.AuthorizationCode(code))).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	auth := model.Authorization{}
+
+	err = json.Unmarshal([]byte(serializedData), &auth)
+	return auth, err
+}
+
 // Delete removes a record using the state received as parameter
 //
 // Note: if the record does not exist, it returns NO errors
@@ -87,20 +118,53 @@ func (s StateStorage) Delete(code string) error {
 }
 
 // _ "implement" constraint for *MockStorage
-var _ Storage = (*MockStorage)(nil)
+var (
+	_ DeviceStorer = (*MockStorage)(nil)
+	_ Counter      = (*MockStorage)(nil)
+)
+
+// MockStorage store for model.Authorization, the copies of a MockStorage share its records and its mutex
+type MockStorage struct {
+	mutex   *sync.Mutex
+	records map[string]interface{}
+}
+
+// NewMockStorage creates a MockStorage that contains the records received as parameter (Optional)
+func NewMockStorage(records map[string]interface{}) *MockStorage {
+	if records == nil {
+		records = make(map[string]interface{})
+	}
+
+	return &MockStorage{mutex: &sync.Mutex{}, records: records}
+}
 
-// MockStorage store for model.Authorization
-type MockStorage map[string]interface{}
+// Len returns the number of saved records
+func (m MockStorage) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.records)
+}
 
 // Create saves a model.Authorization in m
 func (m *MockStorage) Create(code string, i interface{}) error {
-	(*m)[code] = i
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.records[code]; ok {
+		return model.DuplicateRecord(fmt.Sprintf(`record id "%s" already exists`, code))
+	}
+
+	m.records[code] = i
 	return nil
 }
 
 // Obtain search a model.Authorization by state
 func (m MockStorage) Obtain(code string) (i interface{}, err error) {
-	i, ok := m[code]
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i, ok := m.records[code]
 	if !ok {
 		err = model.NotFound(fmt.Sprintf(`missing a record id "%s"`, code))
 	}
@@ -108,12 +172,176 @@ func (m MockStorage) Obtain(code string) (i interface{}, err error) {
 	return
 }
 
+// Consume search and removes a record in a single operation
+func (m *MockStorage) Consume(code string) (i interface{}, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i, ok := m.records[code]
+	if !ok {
+		return nil, model.NotFound(fmt.Sprintf(`missing a record id "%s"`, code))
+	}
+
+	delete(m.records, code)
+	return
+}
+
+// Update replaces an existing record
+func (m *MockStorage) Update(code string, i interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.records[code]; !ok {
+		return model.NotFound(fmt.Sprintf(`missing a record id "%s"`, code))
+	}
+
+	m.records[code] = i
+	return nil
+}
+
+// Poll records a polling request in a record apart from the polled record
+func (m *MockStorage) Poll(code string, p model.Poll) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := code + ":poll"
+
+	last, ok := m.records[key].(model.Poll)
+	if !ok {
+		m.records[key] = p
+		return false, nil
+	}
+
+	slow := p.At.Sub(last.At) < last.Interval
+
+	last.At = p.At
+	if slow {
+		last.Interval += p.SlowDown
+	}
+
+	m.records[key] = last
+	return slow, nil
+}
+
+// mockCounter counter saved by the MockStorage
+type mockCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// Increment increases the counter identified by the key, the counter is reset when the window elapses
+func (m *MockStorage) Increment(key string, window time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counter, ok := m.records[key].(mockCounter)
+	if !ok || time.Now().After(counter.expiresAt) {
+		counter = mockCounter{expiresAt: time.Now().Add(window)}
+	}
+
+	counter.count++
+
+	m.records[key] = counter
+	return counter.count, nil
+}
+
 // Delete removes a record by state
 func (m *MockStorage) Delete(code string) error {
-	delete(*m, code)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.records, code)
+	delete(m.records, code+":poll")
 	return nil
 }
 
+// _ "implement" constraint for UsedCodeStorage
+var _ Storage = UsedCodeStorage{}
+
+// UsedCodeStorage storage of tombstones of the consumed authorization codes
+// Basically saves the id of the session (token id) issued with each authorization code
+type UsedCodeStorage struct {
+	*redis.Client
+}
+
+// usedCodeKey creates the key of the tombstone of an authorization code
+func (UsedCodeStorage) usedCodeKey(code string) string {
+	return "authorization:" + code + ":used"
+}
+
+// Create saves the tombstone of the authorization code that contains the token id (string) issued with it
+func (u UsedCodeStorage) Create(code string, i interface{}) error {
+	cmd := u.SetNX(context.TODO(), u.usedCodeKey(code), i.(string), UsedAuthorizationCodeLifeTime)
+
+	wasCreated, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`authorization code "%s" was already used`, code))
+	}
+
+	return err
+}
+
+// Obtain search the token id (string) issued with the authorization code
+func (u UsedCodeStorage) Obtain(code string) (interface{}, error) {
+	return u.Get(context.TODO(), u.usedCodeKey(code)).Result()
+}
+
+// Delete removes the tombstone of the authorization code
+func (u UsedCodeStorage) Delete(code string) error {
+	return u.Del(context.TODO(), u.usedCodeKey(code)).Err()
+}
+
+// _ "implement" constraint for AssertionStorage
+var _ Storage = AssertionStorage{}
+
+// AssertionStorage storage of the ids (jti) of the assertions accepted by the JWT Bearer grant,
+// each id is kept until the assertion expires so it cannot be replayed
+type AssertionStorage struct {
+	*redis.Client
+}
+
+// assertionKey creates the key of an accepted assertion
+func (AssertionStorage) assertionKey(id string) string {
+	return "assertion:" + id
+}
+
+// Create saves the id of the assertion until its expiration (time.Time), if the id already exists
+// an error of type model.DuplicateRecord is returned
+func (a AssertionStorage) Create(id string, i interface{}) error {
+	// A zero expiration would remember the id forever and a negative one would not remember it
+	ttl := time.Until(i.(time.Time))
+	if ttl <= 0 {
+		return fmt.Errorf(`assertion "%s" already expired`, id)
+	}
+
+	cmd := a.SetNX(context.TODO(), a.assertionKey(id), "", ttl)
+
+	wasCreated, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+
+	if !wasCreated {
+		err = model.DuplicateRecord(fmt.Sprintf(`assertion "%s" was already used`, id))
+	}
+
+	return err
+}
+
+// Obtain search the id of an accepted assertion
+func (a AssertionStorage) Obtain(id string) (interface{}, error) {
+	return a.Get(context.TODO(), a.assertionKey(id)).Result()
+}
+
+// Delete removes the id of an accepted assertion
+func (a AssertionStorage) Delete(id string) error {
+	return a.Del(context.TODO(), a.assertionKey(id)).Err()
+}
+
 // _ "implement" constraint for OwnerStorage
 var _ Storage = OwnerStorage{}
 
@@ -187,6 +415,9 @@ func (s SessionStorage) Create(tokenId string, i interface{}) error {
 // Obtain search enabled session by token id
 func (s SessionStorage) Obtain(tokenId string) (i interface{}, err error) {
 	serialized, err := s.Get(context.TODO(), s.sessionKey(tokenId)).Result()
+	if err != nil {
+		return
+	}
 
 	session := model.Session{}
 
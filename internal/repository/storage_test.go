@@ -39,25 +39,30 @@ func TestStorage_Create(t *testing.T) {
 					id: "abc",
 					input: model.Authorization{
 						State:               "ABC",
-						ClientId:            "3aad9943-714d-4576-9c6f-bb45b142666c",
 						Scope:               "http://localhost/private/,http://localhost/private2/",
 						ResponseType:        "code",
+						ResponseMode:        model.QueryMode,
 						CodeChallenge:       "FFF",
 						CodeChallengeMethod: "PLAIN",
-						RedirectURL: func() *url.URL {
-							uri, _ := url.Parse("http://localhost/callback")
-							return uri
-						}(),
+						Application: model.Application{
+							Id: "3aad9943-714d-4576-9c6f-bb45b142666c",
+							RedirectURL: func() *url.URL {
+								uri, _ := url.Parse("http://localhost/callback")
+								return uri
+							}(),
+						},
 					},
 				},
 				{
 					id: "xyz",
 					input: model.Authorization{
 						State: "ABC",
-						RedirectURL: func() *url.URL {
-							uri, _ := url.Parse("http://localhost/callback")
-							return uri
-						}(),
+						Application: model.Application{
+							RedirectURL: func() *url.URL {
+								uri, _ := url.Parse("http://localhost/callback")
+								return uri
+							}(),
+						},
 					},
 				},
 			},
@@ -69,6 +74,12 @@ func TestStorage_Create(t *testing.T) {
 				{id: "xyz", input: model.Owner{Id: "xyz", Password: "abc"}},
 			},
 		},
+		{
+			storage: UsedCodeStorage{Client: client},
+			tests: []testCase{
+				{id: "abc", input: "a8f3e1f4-7c2b-4b8e-9d61-2f0a7c6f1e55"},
+			},
+		},
 		{
 			storage: SessionStorage{Client: client},
 			tests: []testCase{
@@ -142,9 +153,11 @@ func TestStorage_Obtain(t *testing.T) {
 				{
 					id: "abc",
 					input: model.Authorization{
-						State:               "",
-						ClientId:            "def",
-						ClientSecret:        "def",
+						State: "",
+						Application: model.Application{
+							Id:     "def",
+							Secret: "def",
+						},
 						Scope:               "qwerty",
 						ResponseType:        "code",
 						CodeChallenge:       "abc",
@@ -246,6 +259,7 @@ func TestStorage_Delete(t *testing.T) {
 		StateStorage{Client: client},
 		OwnerStorage{Client: client},
 		SessionStorage{Client: client},
+		UsedCodeStorage{Client: client},
 	}
 
 	// Here starts unit tests